@@ -3,59 +3,109 @@ package main
 import (
 	"context"
 	"database/sql"
+	"flag"
 	"fmt"
 	"log"
+	"net/http"
 	"os"
-	"strings"
+	"os/signal"
 	"sync"
+	"syscall"
+	"time"
 
 	"github.com/aws/aws-lambda-go/events"
 	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/credentials"
 	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	views "github.com/credmark/abi-sql-view-generator/internal"
 	internal "github.com/credmark/abi-sql-view-generator/internal/cloud/aws"
 	sf "github.com/snowflakedb/gosnowflake"
 )
 
 var (
-	account   = os.Getenv("SF_ACCOUNT")
-	user      = os.Getenv("SF_USER")
-	password  = os.Getenv("SF_PASSWORD")
-	database  = os.Getenv("SF_DATABASE")
-	schema    = os.Getenv("SF_SCHEMA")
-	warehouse = os.Getenv("SF_WAREHOUSE")
-	role      = os.Getenv("SF_ROLE")
-	key       = os.Getenv("LAMBDA_ACCESS_KEY_ID")
-	secret    = os.Getenv("LAMBDA_SECRET_ACCESS_KEY")
-	region    = os.Getenv("LAMBDA_REGION")
-	queueURL  = os.Getenv("SQS_QUEUE_URL")
+	account      = os.Getenv("SF_ACCOUNT")
+	user         = os.Getenv("SF_USER")
+	password     = os.Getenv("SF_PASSWORD")
+	database     = os.Getenv("SF_DATABASE")
+	schema       = os.Getenv("SF_SCHEMA")
+	warehouse    = os.Getenv("SF_WAREHOUSE")
+	role         = os.Getenv("SF_ROLE")
+	key          = os.Getenv("LAMBDA_ACCESS_KEY_ID")
+	secret       = os.Getenv("LAMBDA_SECRET_ACCESS_KEY")
+	region       = os.Getenv("LAMBDA_REGION")
+	queueURL     = os.Getenv("SQS_QUEUE_URL")
+	endpoint     = os.Getenv("SQS_ENDPOINT")
+	mode         = os.Getenv("CONSUMER_MODE")
+	auditTable   = os.Getenv("AUDIT_TABLE")
+	backend      = os.Getenv("QUEUE_BACKEND")
+	spoolDir     = os.Getenv("SPOOL_DIR")
+	payloadStore = os.Getenv("PAYLOAD_STORE")
+	// skipUnchanged is set in worker mode by the -skip-unchanged flag
+	skipUnchanged = os.Getenv("SKIP_UNCHANGED") == "true"
+	queueLanes    = os.Getenv("QUEUE_LANES")
+	backupTable   = os.Getenv("BACKUP_TABLE")
+	backupDir     = os.Getenv("BACKUP_DIR")
+)
+
+const (
+	modeLambda = "lambda"
+	modeWorker = "worker"
 )
 
 func init() {
 	log.SetFlags(log.LstdFlags | log.Lshortfile)
 }
 
-func GetQueueName(url string) string {
-	split := strings.Split(url, "/")
+// newMessageHandler creates the handler shared by all messages of an invocation or worker
+func newMessageHandler(db *sql.DB, cfg internal.Config, payloadStore string) *views.MessageHandler {
+	handler := &views.MessageHandler{DB: db, SkipUnchanged: skipUnchanged}
+
+	if auditTable != "" {
+		handler.AuditLog = views.NewAuditLog(db, auditTable)
+	}
+
+	// The backup table is created by the producer
+	if backupTable != "" {
+		handler.Backup = views.NewTableDDLBackup(db, backupTable)
+	} else if backupDir != "" {
+		backup, err := views.NewFileDDLBackup(backupDir)
+		if err != nil {
+			log.Fatal(err)
+		}
+		handler.Backup = backup
+	}
+
+	stores, err := internal.NewBlobStores(cfg, payloadStore)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if stores != nil {
+		handler.BlobStore = stores
+	}
 
-	return split[len(split)-1]
+	return handler
 }
 
-func Handler(ctx context.Context, event events.SQSEvent) error {
+// Handler executes the records of an SQS batch. The records of a FIFO message group are
+// executed one after another in the order they were received, records of different groups
+// and of standard queues concurrently. A failed record and the records of its group behind
+// it are reported as batch item failures, so SQS redelivers them in order while the records
+// that succeeded are not redriven.
+func Handler(ctx context.Context, event events.SQSEvent) (events.SQSEventResponse, error) {
+	response := events.SQSEventResponse{BatchItemFailures: make([]events.SQSBatchItemFailure, 0)}
+
 	config, err := config.LoadDefaultConfig(ctx,
 		config.WithCredentialsProvider(credentials.NewStaticCredentialsProvider(key, secret, "")),
 	)
 	if err != nil {
-		return fmt.Errorf("error loading config: %w", err)
+		return response, fmt.Errorf("error loading config: %w", err)
 	}
 	config.Region = region
 	client := sqs.NewFromConfig(config)
 
-	queueName := GetQueueName(queueURL)
-
-	// Processing channels
-	errorChan := make(chan error)
+	queueName := internal.GetQueueName(queueURL)
 
 	cfg := sf.Config{
 		User:      user,
@@ -79,39 +129,188 @@ func Handler(ctx context.Context, event events.SQSEvent) error {
 	}
 	defer db.Close()
 
+	handler := newMessageHandler(db, internal.Config(config), payloadStore)
+
+	var mu sync.Mutex
 	wg := new(sync.WaitGroup)
 
-	for _, record := range event.Records {
+	for _, group := range internal.GroupSQSRecords(event.Records) {
 		wg.Add(1)
-		go func(ctx context.Context, client *sqs.Client, queueName string, record events.SQSMessage, db *sql.DB, wg *sync.WaitGroup, errorChan chan error) {
+		go func(group []events.SQSMessage) {
 			defer wg.Done()
 
-			if err := internal.HandleSQSMessage(ctx, client, record, queueName, db); err != nil {
-				errorChan <- err
-				return
+			for idx, record := range group {
+				if err := internal.HandleSQSMessage(ctx, client, record, queueName, handler); err != nil {
+					log.Printf("ERROR: message=%s error=%s\n", record.MessageId, err)
+
+					mu.Lock()
+					for _, failed := range group[idx:] {
+						response.BatchItemFailures = append(response.BatchItemFailures, events.SQSBatchItemFailure{ItemIdentifier: failed.MessageId})
+					}
+					mu.Unlock()
+					return
+				}
 			}
-		}(ctx, client, queueName, record, db, wg, errorChan)
+		}(group)
 	}
 
-	log.Println("finished processing SQS records")
-
 	wg.Wait()
-	close(errorChan)
 
-	errors := make([]error, 0)
+	log.Println("finished processing SQS records")
 
-	for err := range errorChan {
-		log.Println("ERROR:", err)
-		errors = append(errors, err)
+	if len(response.BatchItemFailures) > 0 {
+		log.Printf("%d of %d records failed and are returned to the queue\n", len(response.BatchItemFailures), len(event.Records))
 	}
 
-	if len(errors) > 0 {
-		log.Fatal("errors encountered in processing")
+	return response, nil
+}
+
+// newQueue creates the queue the worker consumes for the given backend
+func newQueue(backend string, queueURL string, spoolDir string, region string, endpoint string, visibilityTimeout time.Duration) views.Queue {
+	switch backend {
+	case views.QueueBackendSQS:
+		if queueURL == "" {
+			log.Fatal("a queue URL is required for the sqs backend")
+		}
+		cfg := internal.NewConfigWithEndpoint(key, secret, region, endpoint)
+		return internal.NewSQSQueue(sqs.NewFromConfig(aws.Config(cfg)), queueURL, visibilityTimeout)
+	case views.QueueBackendSpool:
+		if spoolDir == "" {
+			log.Fatal("a spool directory is required for the spool backend")
+		}
+		queue, err := views.NewSpoolQueue(spoolDir, visibilityTimeout)
+		if err != nil {
+			log.Fatal(err)
+		}
+		return queue
+	default:
+		log.Fatalf("unknown queue backend %q", backend)
 	}
 
 	return nil
 }
 
+// newLaneQueue creates a queue draining the lanes in order of priority. A lane target is a
+// queue URL, or a spool directory for the spool backend.
+func newLaneQueue(backend string, lanes []views.Lane, region string, endpoint string, visibilityTimeout time.Duration) views.Queue {
+	names := make([]string, len(lanes))
+	queues := make([]views.Queue, len(lanes))
+	for idx, lane := range lanes {
+		names[idx] = lane.Name
+		queues[idx] = newQueue(backend, lane.Target, lane.Target, region, endpoint, visibilityTimeout)
+	}
+
+	return views.NewPriorityQueue(names, queues)
+}
+
+// runWorker consumes the queue from a long running process instead of lambda invocations
+// until a SIGTERM or SIGINT is received.
+func runWorker(queue views.Queue, options views.WorkerOptions, healthAddr string, flagRegion string, flagEndpoint string, flagPayloadStore string) {
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, syscall.SIGINT)
+	defer stop()
+
+	dsn, err := sf.DSN(&sf.Config{
+		User:      user,
+		Password:  password,
+		Account:   account,
+		Database:  database,
+		Schema:    schema,
+		Warehouse: warehouse,
+		Role:      role,
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// A single connection pool is shared by all workers for the lifetime of the process
+	db, err := sql.Open("snowflake", dsn)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer db.Close()
+	db.SetMaxOpenConns(options.Workers)
+
+	cfg := internal.NewConfigWithEndpoint(key, secret, flagRegion, flagEndpoint)
+	worker := views.NewWorker(queue, newMessageHandler(db, cfg, flagPayloadStore), options)
+
+	server := &http.Server{Addr: healthAddr, Handler: worker.HealthHandler()}
+	go func() {
+		log.Printf("serving health checks on %s\n", healthAddr)
+		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Fatal(err)
+		}
+	}()
+
+	worker.Run(ctx)
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	server.Shutdown(shutdownCtx)
+}
+
 func main() {
-	lambda.Start(Handler)
+
+	var flagMode string
+	var flagBackend string
+	var flagSpoolDir string
+	var flagPayloadStore string
+	var flagQueueURL string
+	var flagRegion string
+	var flagEndpoint string
+	var healthAddr string
+	var workers int
+	var waitTime int
+	var visibilityTimeout int
+	var flagLanes string
+	if mode == "" {
+		mode = modeLambda
+	}
+	if backend == "" {
+		backend = views.QueueBackendSQS
+	}
+	flag.StringVar(&flagMode, "mode", mode, "run as a lambda handler (lambda) or a long running queue worker (worker)")
+	flag.StringVar(&flagBackend, "queue-backend", backend, "queue backend consumed in worker mode: sqs or spool")
+	flag.StringVar(&flagSpoolDir, "spool-dir", spoolDir, "directory of the spool queue backend")
+	flag.StringVar(&flagPayloadStore, "payload-store", payloadStore, "location of oversized message payloads, s3://bucket/prefix or file:///path")
+	flag.StringVar(&flagQueueURL, "queue-url", queueURL, "URL of the SQS queue to consume in worker mode")
+	flag.StringVar(&flagRegion, "region", region, "AWS Region of the SQS queue")
+	flag.StringVar(&flagEndpoint, "endpoint", endpoint, "custom SQS endpoint, e.g. http://localhost:9324 for a local stand-in")
+	flag.StringVar(&healthAddr, "health-addr", ":8080", "address the worker serves /healthz and /readyz on")
+	flag.IntVar(&workers, "workers", 4, "number of messages processed concurrently in worker mode")
+	flag.IntVar(&waitTime, "wait-time", 20, "seconds each receive call long polls the queue for")
+	flag.IntVar(&visibilityTimeout, "visibility-timeout", 60, "seconds a message stays hidden, extended while its queries run")
+	flag.BoolVar(&skipUnchanged, "skip-unchanged", skipUnchanged, "skip views that already exist with the same definition")
+	flag.StringVar(&flagLanes, "lanes", queueLanes, "queues to drain from the highest to the lowest priority as name=target,..., replaces -queue-url and -spool-dir in worker mode")
+	flag.StringVar(&backupTable, "backup-table", backupTable, "fully qualified name of the table the DDL of views is backed up in before they are replaced or dropped")
+	flag.StringVar(&backupDir, "backup-dir", backupDir, "directory the DDL of views is backed up in when no backup table is set, no backup is made if both are empty")
+	flag.Parse()
+
+	switch flagMode {
+	case modeLambda:
+		// Lambda is handed the messages of its event sources and can't prefer one over another
+		if flagLanes != "" {
+			log.Println("WARNING: -lanes only applies in worker mode, every lane has to be an event source of the lambda")
+		}
+		lambda.Start(Handler)
+	case modeWorker:
+		options := views.WorkerOptions{
+			Workers:           workers,
+			WaitTime:          time.Duration(waitTime) * time.Second,
+			VisibilityTimeout: time.Duration(visibilityTimeout) * time.Second,
+		}
+		lanes, err := views.ParseLanes(flagLanes)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		var queue views.Queue
+		if len(lanes) > 0 {
+			queue = newLaneQueue(flagBackend, lanes, flagRegion, flagEndpoint, options.VisibilityTimeout)
+		} else {
+			queue = newQueue(flagBackend, flagQueueURL, flagSpoolDir, flagRegion, flagEndpoint, options.VisibilityTimeout)
+		}
+		runWorker(queue, options, healthAddr, flagRegion, flagEndpoint, flagPayloadStore)
+	default:
+		log.Fatalf("unknown mode %q", flagMode)
+	}
 }
@@ -0,0 +1,445 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	"github.com/credmark/abi-sql-view-generator/internal"
+	cloud "github.com/credmark/abi-sql-view-generator/internal/cloud/aws"
+	sf "github.com/snowflakedb/gosnowflake"
+)
+
+var (
+	account      = os.Getenv("SF_ACCOUNT")
+	user         = os.Getenv("SF_USER")
+	password     = os.Getenv("SF_PASSWORD")
+	database     = os.Getenv("SF_DATABASE")
+	schema       = os.Getenv("SF_SCHEMA")
+	warehouse    = os.Getenv("SF_WAREHOUSE")
+	role         = os.Getenv("SF_ROLE")
+	key          = os.Getenv("AWS_ACCESS_KEY_ID")
+	secret       = os.Getenv("AWS_SECRET_ACCESS_KEY")
+	region       = os.Getenv("AWS_REGION")
+	queueURL     = os.Getenv("SQS_QUEUE_URL")
+	dlqURL       = os.Getenv("SQS_DLQ_URL")
+	sqsEndpoint  = os.Getenv("SQS_ENDPOINT")
+	payloadStore = os.Getenv("PAYLOAD_STORE")
+)
+
+const (
+	actionList   = "list"
+	actionReplay = "replay"
+	actionRun    = "run"
+)
+
+// dlqMessage is the decoded form of a single message sitting in the dead-letter queue
+type dlqMessage struct {
+	Raw                types.Message
+	ContractAddress    string
+	NumberOfStatements int
+	ReceiveCount       int
+	Message            *internal.QueueMessage
+	// DecodeError is the first error found while decoding or validating the message here,
+	// not the error the consumer failed on, which is recorded in the audit table
+	DecodeError error
+}
+
+type filter struct {
+	ContractList     []string
+	MinReceiveCount  int
+	DecodeErrorsOnly bool
+}
+
+func init() {
+	log.SetFlags(log.LstdFlags | log.Lshortfile)
+}
+
+func decodeMessage(ctx context.Context, store internal.BlobReader, raw types.Message) *dlqMessage {
+	decoded := &dlqMessage{Raw: raw}
+
+	if count, ok := raw.Attributes[string(types.MessageSystemAttributeNameApproximateReceiveCount)]; ok {
+		decoded.ReceiveCount, _ = strconv.Atoi(count)
+	}
+
+	message, err := internal.DeserializeMessage(aws.ToString(raw.Body))
+	if err != nil {
+		decoded.DecodeError = err
+		return decoded
+	}
+
+	decoded.Message = message
+	decoded.ContractAddress = message.ContractAddress
+	decoded.NumberOfStatements = message.NumberOfStatements
+
+	if message.PayloadRef != "" {
+		resolved, err := internal.ResolveMessage(ctx, store, message)
+		if err != nil {
+			decoded.DecodeError = err
+			return decoded
+		}
+		decoded.Message = resolved
+		message = resolved
+	}
+
+	if message.PendingQueryID != "" && len(message.Views) == 0 {
+		decoded.DecodeError = fmt.Errorf("status check message for query ID %s", message.PendingQueryID)
+	} else if n := len(message.Entries()); n != message.NumberOfStatements {
+		decoded.DecodeError = fmt.Errorf("message declares %d statements but contains %d", message.NumberOfStatements, n)
+	}
+
+	return decoded
+}
+
+func (f *filter) match(message *dlqMessage) bool {
+	if f.DecodeErrorsOnly && message.DecodeError == nil {
+		return false
+	}
+
+	if message.ReceiveCount < f.MinReceiveCount {
+		return false
+	}
+
+	if len(f.ContractList) == 0 {
+		return true
+	}
+
+	for _, contractAddress := range f.ContractList {
+		if strings.EqualFold(contractAddress, message.ContractAddress) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// receiveMessages pulls up to max messages from the dead-letter queue. Messages stay
+// invisible for visibilityTimeout seconds so the same message is not returned twice
+// while a single invocation is inspecting the queue.
+func receiveMessages(ctx context.Context, client *sqs.Client, queueURL string, max int, visibilityTimeout int32) ([]types.Message, error) {
+	messages := make([]types.Message, 0)
+
+	for len(messages) < max {
+		batchSize := max - len(messages)
+		if batchSize > 10 {
+			batchSize = 10
+		}
+
+		batch, err := cloud.ReceiveSQSMessages(ctx, client, queueURL, int32(batchSize), 1, visibilityTimeout)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(batch) == 0 {
+			break
+		}
+
+		messages = append(messages, batch...)
+	}
+
+	return messages, nil
+}
+
+// releaseMessages makes received messages visible again right away instead of once their
+// visibility timeout ran out, so that listing the queue doesn't hide it from a redrive
+func releaseMessages(ctx context.Context, client *sqs.Client, queueURL string, messages []types.Message) {
+	for _, message := range messages {
+		if err := cloud.ExtendSQSMessageVisibility(ctx, client, queueURL, aws.ToString(message.ReceiptHandle), 0); err != nil {
+			log.Printf("ERROR: releasing message %s: %s\n", aws.ToString(message.MessageId), err)
+		}
+	}
+}
+
+// printViews lists the views each message creates
+func printViews(messages []*dlqMessage) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "MESSAGE_ID\tCONTRACT_ADDRESS\tKIND\tVIEW_NAME\tSIGNATURE\tHASH")
+
+	for _, message := range messages {
+		if message.Message == nil {
+			continue
+		}
+
+		for _, view := range message.Message.Entries() {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n",
+				aws.ToString(message.Raw.MessageId),
+				message.ContractAddress,
+				view.Kind,
+				view.ViewName,
+				view.Signature,
+				view.Hash,
+			)
+		}
+	}
+
+	w.Flush()
+}
+
+func printMessages(messages []*dlqMessage) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "MESSAGE_ID\tCONTRACT_ADDRESS\tSTATEMENTS\tRECEIVE_COUNT\tDECODE_ERROR")
+
+	for _, message := range messages {
+		decodeError := ""
+		if message.DecodeError != nil {
+			decodeError = message.DecodeError.Error()
+		}
+
+		fmt.Fprintf(w, "%s\t%s\t%d\t%d\t%s\n",
+			aws.ToString(message.Raw.MessageId),
+			message.ContractAddress,
+			message.NumberOfStatements,
+			message.ReceiveCount,
+			decodeError,
+		)
+	}
+
+	w.Flush()
+}
+
+// replayMessage sends the original message body back to the main queue and removes it
+// from the dead-letter queue once the send succeeded.
+func replayMessage(ctx context.Context, client *sqs.Client, targetQueueURL string, dlqQueueName string, message *dlqMessage) error {
+	// A replay is deliberate, so it must not be dropped as a duplicate of the original send
+	replay := internal.Message{
+		Body:            aws.ToString(message.Raw.Body),
+		GroupID:         message.Raw.Attributes[string(types.MessageSystemAttributeNameMessageGroupId)],
+		DeduplicationID: internal.HashDDL(fmt.Sprintf("%s:%d", aws.ToString(message.Raw.MessageId), time.Now().UnixNano())),
+	}
+
+	// messages from a standard dead-letter queue carry no group ID
+	if replay.GroupID == "" && message.Message != nil {
+		replay.GroupID = message.Message.GroupID()
+	}
+
+	if err := cloud.NewSQSQueue(client, targetQueueURL, 0).Publish(ctx, replay); err != nil {
+		return fmt.Errorf("error replaying message %s: %w", aws.ToString(message.Raw.MessageId), err)
+	}
+
+	return cloud.DeleteSQSMessage(ctx, client, dlqQueueName, aws.ToString(message.Raw.ReceiptHandle))
+}
+
+// runMessage executes each statement of the message on its own so that every failing
+// view is reported. When viewNames is set only those views are run. The message is only
+// removed from the dead-letter queue when all of its statements ran and succeeded.
+func runMessage(ctx context.Context, db *sql.DB, client *sqs.Client, dlqQueueName string, message *dlqMessage, viewNames []string) error {
+	if message.Message == nil {
+		return fmt.Errorf("message %s could not be decoded: %w", aws.ToString(message.Raw.MessageId), message.DecodeError)
+	}
+
+	if message.Message.PayloadRef != "" {
+		return fmt.Errorf("payload %s of message %s could not be resolved: %w", message.Message.PayloadRef, aws.ToString(message.Raw.MessageId), message.DecodeError)
+	}
+
+	// Status check messages of older consumers only carry the view names
+	if message.Message.PendingQueryID != "" && len(message.Message.Views) == 0 {
+		return fmt.Errorf("message %s is a status check for query ID %s and carries no statements to run", aws.ToString(message.Raw.MessageId), message.Message.PendingQueryID)
+	}
+
+	views := message.Message.Entries()
+	selected := selectViews(views, viewNames)
+	if len(selected) == 0 {
+		log.Printf("no matching views in message %s for contract address %s\n", aws.ToString(message.Raw.MessageId), message.ContractAddress)
+		return nil
+	}
+
+	results := internal.ExecuteStatements(ctx, db, selected)
+
+	failed := 0
+	for idx, result := range results {
+		if result.Error != nil {
+			failed += 1
+			log.Printf("FAILED: contractAddress=%s statement=%d view=%s queryID=%s error=%s\n", message.ContractAddress, idx+1, result.ViewName, result.QueryID, result.Error.Error())
+			continue
+		}
+
+		log.Printf("OK: contractAddress=%s statement=%d view=%s queryID=%s\n", message.ContractAddress, idx+1, result.ViewName, result.QueryID)
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d of %d statements failed for contract address %s", failed, len(results), message.ContractAddress)
+	}
+
+	if len(selected) < len(views) {
+		log.Printf("%d of %d views of message %s were run, keeping it in the dead-letter queue\n", len(selected), len(views), aws.ToString(message.Raw.MessageId))
+		return nil
+	}
+
+	return cloud.DeleteSQSMessage(ctx, client, dlqQueueName, aws.ToString(message.Raw.ReceiptHandle))
+}
+
+// selectViews returns the views whose name is in viewNames, or all views if it is empty
+func selectViews(views []internal.ViewEntry, viewNames []string) []internal.ViewEntry {
+	if len(viewNames) == 0 {
+		return views
+	}
+
+	selected := make([]internal.ViewEntry, 0)
+	for _, view := range views {
+		for _, viewName := range viewNames {
+			if strings.EqualFold(view.ViewName, viewName) {
+				selected = append(selected, view)
+				break
+			}
+		}
+	}
+
+	return selected
+}
+
+func main() {
+
+	var action string
+	var dryRun bool
+	var max int
+	var visibilityTimeout int
+	var minReceiveCount int
+	var decodeErrorsOnly bool
+	var flagQueueURL string
+	var flagDLQURL string
+	var flagRegion string
+	var flagEndpoint string
+	var flagContractList string
+	var flagPayloadStore string
+	var flagViews string
+	var showViews bool
+	flag.StringVar(&action, "action", actionList, "action to take on matching messages: list, replay or run")
+	flag.BoolVar(&dryRun, "dry-run", false, "only list the messages that the action would be applied to")
+	flag.IntVar(&max, "max", 100, "maximum number of messages to read from the dead-letter queue")
+	flag.IntVar(&visibilityTimeout, "visibility-timeout", 120, "seconds the messages replayed or run stay hidden from other consumers, listed messages are released right away")
+	flag.IntVar(&minReceiveCount, "min-receive-count", 0, "only match messages received at least this many times")
+	flag.BoolVar(&decodeErrorsOnly, "decode-errors-only", false, "only match messages that fail to decode or validate, which doesn't include messages whose statements failed in the consumer")
+	flag.StringVar(&flagQueueURL, "queue-url", queueURL, "URL of the main SQS queue messages are replayed to")
+	flag.StringVar(&flagDLQURL, "dlq-url", dlqURL, "URL of the SQS dead-letter queue")
+	flag.StringVar(&flagRegion, "region", region, "AWS Region of the SQS queues")
+	flag.StringVar(&flagEndpoint, "endpoint", sqsEndpoint, "custom SQS endpoint, e.g. http://localhost:9324 for a local stand-in")
+	flag.StringVar(&flagContractList, "contract-list", "", "comma separated list of contract addresses to filter for")
+	flag.StringVar(&flagPayloadStore, "payload-store", payloadStore, "location of oversized message payloads, s3://bucket/prefix or file:///path")
+	flag.StringVar(&flagViews, "views", "", "comma separated list of view names to run, all views of a message if empty")
+	flag.BoolVar(&showViews, "show-views", false, "list the views of every matching message")
+	flag.Parse()
+
+	if action != actionList && action != actionReplay && action != actionRun {
+		log.Fatalf("unknown action %q", action)
+	}
+
+	if flagDLQURL == "" {
+		log.Fatal("a dead-letter queue URL is required")
+	}
+
+	if action == actionReplay && flagQueueURL == "" {
+		log.Fatal("a target queue URL is required to replay messages")
+	}
+
+	ctx := context.Background()
+	cfg := cloud.NewConfigWithEndpoint(key, secret, flagRegion, flagEndpoint)
+	client := sqs.NewFromConfig(aws.Config(cfg))
+	dlqQueueName := cloud.GetQueueName(flagDLQURL)
+
+	var store internal.BlobReader
+	stores, err := cloud.NewBlobStores(cfg, flagPayloadStore)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if stores != nil {
+		store = stores
+	}
+
+	f := filter{
+		MinReceiveCount:  minReceiveCount,
+		DecodeErrorsOnly: decodeErrorsOnly,
+	}
+	if flagContractList != "" {
+		f.ContractList = strings.Split(flagContractList, ",")
+	}
+
+	var viewNames []string
+	if flagViews != "" {
+		viewNames = strings.Split(flagViews, ",")
+	}
+
+	raw, err := receiveMessages(ctx, client, flagDLQURL, max, int32(visibilityTimeout))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	messages := make([]*dlqMessage, 0)
+	unmatched := make([]types.Message, 0)
+	for _, r := range raw {
+		message := decodeMessage(ctx, store, r)
+		if f.match(message) {
+			messages = append(messages, message)
+		} else {
+			unmatched = append(unmatched, r)
+		}
+	}
+
+	log.Printf("%d of %d messages read from the dead-letter queue match the filter\n", len(messages), len(raw))
+	printMessages(messages)
+	if showViews {
+		printViews(messages)
+	}
+
+	if action == actionList || dryRun {
+		releaseMessages(ctx, client, flagDLQURL, raw)
+		os.Exit(0)
+	}
+	releaseMessages(ctx, client, flagDLQURL, unmatched)
+
+	var db *sql.DB
+	if action == actionRun {
+		dsn, err := sf.DSN(&sf.Config{
+			User:      user,
+			Password:  password,
+			Account:   account,
+			Database:  database,
+			Schema:    schema,
+			Warehouse: warehouse,
+			Role:      role,
+		})
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		db, err = sql.Open("snowflake", dsn)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer db.Close()
+	}
+
+	errors := make([]error, 0)
+	for _, message := range messages {
+		var err error
+		switch action {
+		case actionReplay:
+			err = replayMessage(ctx, client, flagQueueURL, dlqQueueName, message)
+		case actionRun:
+			err = runMessage(ctx, db, client, dlqQueueName, message, viewNames)
+		}
+
+		if err != nil {
+			log.Println("ERROR:", err)
+			errors = append(errors, err)
+		}
+	}
+
+	log.Printf("%s finished: %d succeeded, %d failed\n", action, len(messages)-len(errors), len(errors))
+
+	if len(errors) > 0 {
+		if db != nil {
+			db.Close()
+		}
+		os.Exit(1)
+	}
+}
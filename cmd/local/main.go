@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"os"
+
+	"github.com/credmark/abi-sql-view-generator/utils"
+	_ "github.com/marcboeker/go-duckdb"
+)
+
+var (
+	namespace = os.Getenv("NAMESPACE")
+)
+
+func init() {
+	log.SetFlags(log.LstdFlags | log.Lshortfile)
+}
+
+// local runs the producer and the consumer in one process against an embedded DuckDB
+// database, without AWS or snowflake. It is a separate command so that the lambda builds
+// don't link DuckDB, which needs cgo.
+func main() {
+
+	var flagNamespace string
+	var flagContractList string
+	var concurrency int
+	var targetSchema string
+	var logsTable string
+	var transactionsTable string
+	var tracesTable string
+	var decoderUDF string
+	var abiDir string
+	var logsFile string
+	var transactionsFile string
+	var tracesFile string
+	var duckDBFile string
+	flag.StringVar(&flagNamespace, "namespace", namespace, "namespace prefix of the view names")
+	flag.StringVar(&flagContractList, "contract-list", "", "comma separated list of contract addresses to filter for")
+	flag.IntVar(&concurrency, "concurrency", 8, "number of messages the consumer executes concurrently")
+	flag.StringVar(&targetSchema, "target-schema", "ethereum_contracts", "schema views are created in")
+	flag.StringVar(&logsTable, "logs-table", "ethereum.logs", "fully qualified name of the table the logs export is loaded into")
+	flag.StringVar(&transactionsTable, "transactions-table", "ethereum.transactions", "fully qualified name of the table the transactions export is loaded into")
+	flag.StringVar(&tracesTable, "traces-table", "ethereum.traces", "fully qualified name of the table the traces export is loaded into")
+	flag.StringVar(&decoderUDF, "decoder-udf", "ethereum_contracts.decode_abi_input_prod", "fully qualified name of the decoder macro standing in for the decoder UDF")
+	flag.StringVar(&abiDir, "abi-dir", "", "directory of <contract address>.json ABI files to generate views for")
+	flag.StringVar(&logsFile, "logs-file", "", "CSV, JSON lines or parquet export of the logs table to load")
+	flag.StringVar(&transactionsFile, "transactions-file", "", "CSV, JSON lines or parquet export of the transactions table to load")
+	flag.StringVar(&tracesFile, "traces-file", "", "CSV, JSON lines or parquet export of the traces table to load")
+	flag.StringVar(&duckDBFile, "duckdb-file", "", "DuckDB database file the views are created in so they can be queried afterwards, in memory if empty")
+	flag.Parse()
+
+	options := utils.NewOptions("", flagNamespace, "", "", "", "", false, false, 0, 0, flagContractList)
+	options.Concurrency = concurrency
+	options.TargetSchema = targetSchema
+	options.LogsTable = logsTable
+	options.TransactionsTable = transactionsTable
+	options.TracesTable = tracesTable
+	options.DecoderUDF = decoderUDF
+	options.AbiDir = abiDir
+	options.LogsFile = logsFile
+	options.TransactionsFile = transactionsFile
+	options.TracesFile = tracesFile
+	options.DuckDBFile = duckDBFile
+
+	if err := utils.RunLocal(context.Background(), options); err != nil {
+		log.Fatal(err)
+	}
+}
@@ -5,24 +5,36 @@ import (
 	"flag"
 	"log"
 	"os"
+	"time"
 
+	"github.com/credmark/abi-sql-view-generator/internal"
 	"github.com/credmark/abi-sql-view-generator/utils"
 	sf "github.com/snowflakedb/gosnowflake"
 )
 
 var (
-	account   = os.Getenv("SF_ACCOUNT")
-	user      = os.Getenv("SF_USER")
-	password  = os.Getenv("SF_PASSWORD")
-	database  = os.Getenv("SF_DATABASE")
-	schema    = os.Getenv("SF_SCHEMA")
-	warehouse = os.Getenv("SF_WAREHOUSE")
-	role      = os.Getenv("SF_ROLE")
-	namespace = os.Getenv("NAMESPACE")
-	key       = os.Getenv("AWS_ACCESS_KEY_ID")
-	secret    = os.Getenv("AWS_SECRET_ACCESS_KEY")
-	region    = os.Getenv("AWS_REGION")
-	queueURL  = os.Getenv("SQS_QUEUE_URL")
+	account        = os.Getenv("SF_ACCOUNT")
+	user           = os.Getenv("SF_USER")
+	password       = os.Getenv("SF_PASSWORD")
+	database       = os.Getenv("SF_DATABASE")
+	schema         = os.Getenv("SF_SCHEMA")
+	warehouse      = os.Getenv("SF_WAREHOUSE")
+	role           = os.Getenv("SF_ROLE")
+	namespace      = os.Getenv("NAMESPACE")
+	key            = os.Getenv("AWS_ACCESS_KEY_ID")
+	secret         = os.Getenv("AWS_SECRET_ACCESS_KEY")
+	region         = os.Getenv("AWS_REGION")
+	queueURL       = os.Getenv("SQS_QUEUE_URL")
+	auditTable     = os.Getenv("AUDIT_TABLE")
+	backend        = os.Getenv("QUEUE_BACKEND")
+	spoolDir       = os.Getenv("SPOOL_DIR")
+	endpoint       = os.Getenv("SQS_ENDPOINT")
+	payloadStore   = os.Getenv("PAYLOAD_STORE")
+	queueLanes     = os.Getenv("QUEUE_LANES")
+	queueRoutes    = os.Getenv("QUEUE_ROUTES")
+	watermarkTable = os.Getenv("WATERMARK_TABLE")
+	watermarkFile  = os.Getenv("WATERMARK_FILE")
+	backupTable    = os.Getenv("BACKUP_TABLE")
 )
 
 func init() {
@@ -38,15 +50,127 @@ func main() {
 	var flagQueueURL string
 	var flagRegion string
 	var flagContractList string
-	flag.BoolVar(&drop, "drop", false, "drop all existing views")
+	var flagAuditTable string
+	var flagBackend string
+	var flagSpoolDir string
+	var flagEndpoint string
+	var concurrency int
+	var maxInFlight int
+	var flagOversizeStrategy string
+	var flagPayloadStore string
+	var flagChain string
+	var flagLanes string
+	var flagRoutes string
+	var orderByActivity bool
+	var plan bool
+	var diffOnly bool
+	var planFile string
+	var applyDirect bool
+	var incremental bool
+	var flagWatermarkTable string
+	var flagWatermarkFile string
+	var watermarkColumn string
+	var staleViews string
+	var dropNamespace string
+	var dropKind string
+	var dropName string
+	var dropOlderThan time.Duration
+	var dropOrphaned bool
+	var dropBatchSize int
+	var dropConcurrency int
+	var maxDrop int
+	var yes bool
+	var flagBackupTable string
+	var backupDir string
+	var blueGreen bool
+	var stagingSchema string
+	var targetSchema string
+	var contractsTable string
+	var logsTable string
+	var transactionsTable string
+	var tracesTable string
+	var decoderUDF string
+	var skipDecoderCheck bool
+	var abiDir string
+	var logsFile string
+	var transactionsFile string
+	var tracesFile string
+	var outputDir string
+	var viewDir string
+	var verifySamples int
+	flag.BoolVar(&drop, "drop", false, "drop all existing views, or those matching the -contract-list and -drop-* filters")
 	flag.BoolVar(&dryRun, "dry-run", false, "run without submitting/creating queries")
 	flag.IntVar(&limit, "limit", 0, "limit number of verified contracts returned for processing")
 	flag.IntVar(&count, "count", 5, "number of minimum logs a contract should have")
 	flag.StringVar(&flagQueueURL, "queue-url", queueURL, "URL of the SQS queue")
 	flag.StringVar(&flagRegion, "region", region, "AWS Region of the SQS queue")
 	flag.StringVar(&flagContractList, "contract-list", "", "comma separated list of contract addresses to filter for")
+	flag.StringVar(&flagAuditTable, "audit-table", auditTable, "fully qualified name of the table view creation is audited in, empty to disable")
+	flag.StringVar(&flagBackend, "queue-backend", backend, "queue backend messages are published to: sqs or spool")
+	flag.StringVar(&flagSpoolDir, "spool-dir", spoolDir, "directory of the spool queue backend")
+	flag.StringVar(&flagEndpoint, "endpoint", endpoint, "custom SQS endpoint, e.g. http://localhost:9324 for a local stand-in")
+	flag.IntVar(&concurrency, "concurrency", 8, "number of contracts SQL statements are generated for concurrently")
+	flag.IntVar(&maxInFlight, "max-in-flight", 8, "maximum number of batch publish requests in flight")
+	flag.StringVar(&flagOversizeStrategy, "oversize-strategy", "split", "handling of messages over the SQS size limit: split into parts or store in the payload store")
+	flag.StringVar(&flagPayloadStore, "payload-store", payloadStore, "location oversized messages are stored in, s3://bucket/prefix or file:///path")
+	flag.StringVar(&flagChain, "chain", "ethereum", "blockchain the contracts are deployed on, recorded in every message")
+	flag.StringVar(&flagLanes, "lanes", queueLanes, "target queues from the highest to the lowest priority as name=target,... where target is a queue URL or spool directory")
+	flag.StringVar(&flagRoutes, "routes", queueRoutes, "lane of each contract class (new, changed or unchanged) as class=lane,..., the lowest priority lane if not routed")
+	flag.BoolVar(&orderByActivity, "order-by-activity", false, "process the contracts with the most logs first")
+	flag.BoolVar(&plan, "plan", false, "report which views would be created, replaced, left unchanged or are orphaned without queueing anything")
+	flag.BoolVar(&diffOnly, "diff", false, "only queue views that don't exist yet or differ from their generated definition")
+	flag.StringVar(&planFile, "out", "", "path the plan is saved to in plan mode, to be executed later with apply <planfile>")
+	flag.BoolVar(&applyDirect, "apply-direct", false, "execute a saved plan directly on snowflake instead of queueing it")
+	flag.BoolVar(&incremental, "incremental", false, "only process contracts verified or updated since the last run and contracts that just crossed the -count threshold")
+	flag.StringVar(&flagWatermarkTable, "watermark-table", watermarkTable, "fully qualified name of the table the incremental watermark is stored in")
+	flag.StringVar(&flagWatermarkFile, "watermark-file", watermarkFile, "local file the incremental watermark is stored in when no watermark table is set")
+	flag.StringVar(&watermarkColumn, "watermark-column", "updated_at", "verification or update time column of deployed_contract_metadata")
+	flag.StringVar(&staleViews, "stale-views", "", "detect ABI changes and keep, drop or deprecate the views no longer generated for a contract, empty to disable")
+	flag.StringVar(&dropNamespace, "drop-namespace", "", "only drop views of this namespace")
+	flag.StringVar(&dropKind, "drop-kind", "", "only drop event (evt) or function (fn) views")
+	flag.StringVar(&dropName, "drop-name", "", "only drop views of events or methods matching the name pattern, * and ? are wildcards")
+	flag.DurationVar(&dropOlderThan, "drop-older-than", 0, "only drop views not created or replaced within this duration, e.g. 720h")
+	flag.BoolVar(&dropOrphaned, "drop-orphaned", false, "only drop views whose contract, event or method is no longer in the ABI source")
+	flag.IntVar(&dropBatchSize, "drop-batch-size", 100, "number of views dropped with each query")
+	flag.IntVar(&dropConcurrency, "drop-concurrency", 4, "number of drop batches running at once")
+	flag.IntVar(&maxDrop, "max-drop", 1000, "abort if more views would be dropped, 0 for no limit")
+	flag.BoolVar(&yes, "yes", false, "drop views without asking for confirmation")
+	flag.StringVar(&flagBackupTable, "backup-table", backupTable, "fully qualified name of the table the DDL of replaced and dropped views is backed up in")
+	flag.StringVar(&backupDir, "backup-dir", "backups", "directory the DDL of replaced and dropped views is backed up in when no backup table is set")
+	flag.BoolVar(&blueGreen, "blue-green", false, "create the views in a clone of the target schema and swap it with the target schema once all of them were created")
+	flag.StringVar(&stagingSchema, "staging-schema", "", "schema views are staged in by -blue-green, which keeps the previous views afterwards, the target schema with a _staging suffix if empty")
+	flag.StringVar(&targetSchema, "target-schema", "ethereum_contracts", "schema views are created in and dropped from")
+	flag.StringVar(&contractsTable, "contracts-table", "ethereum.deployed_contract_metadata", "fully qualified name of the table of verified contracts and their ABIs")
+	flag.StringVar(&logsTable, "logs-table", "ethereum.logs", "fully qualified name of the table of event logs")
+	flag.StringVar(&transactionsTable, "transactions-table", "ethereum.transactions", "fully qualified name of the table of transactions")
+	flag.StringVar(&tracesTable, "traces-table", "ethereum.traces", "fully qualified name of the table of traces")
+	flag.StringVar(&decoderUDF, "decoder-udf", "ethereum_contracts.decode_abi_input_prod", "fully qualified name of the UDF decoding event and method inputs")
+	flag.BoolVar(&skipDecoderCheck, "skip-udf-check", false, "don't check the version of the decoder UDF before queueing views")
+	flag.StringVar(&abiDir, "abi-dir", "", "directory of <contract address>.json ABI files decode reads instead of the contracts table")
+	flag.StringVar(&logsFile, "logs-file", "", "CSV, JSON lines or parquet export of the logs table to decode")
+	flag.StringVar(&transactionsFile, "transactions-file", "", "CSV, JSON lines or parquet export of the transactions table to decode")
+	flag.StringVar(&tracesFile, "traces-file", "", "CSV, JSON lines or parquet export of the traces table to decode")
+	flag.StringVar(&outputDir, "output-dir", "decoded", "directory decode writes a parquet file per view to")
+	flag.StringVar(&viewDir, "view-dir", "", "directory of view exports named after the views verify samples instead of snowflake, along with the -logs-file, -transactions-file and -traces-file exports")
+	flag.IntVar(&verifySamples, "samples", 10, "number of rows verify and install-udf sample from each view")
 	flag.Parse()
 
+	if staleViews != "" {
+		if _, err := internal.ParseStaleViews(staleViews); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	lanes, err := internal.ParseLanes(flagLanes)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	routes, err := internal.ParseRoutes(flagRoutes, lanes)
+	if err != nil {
+		log.Fatal(err)
+	}
+
 	ctx := context.Background()
 	cfg := sf.Config{
 		User:      user,
@@ -58,12 +182,113 @@ func main() {
 		Role:      role,
 	}
 
+	// decode, and verify of view exports, run offline and don't need snowflake credentials
+	offline := flag.Arg(0) == "decode" || (flag.Arg(0) == "verify" && viewDir != "")
 	dsn, err := sf.DSN(&cfg)
-	if err != nil {
+	if err != nil && !offline {
 		log.Fatal(err)
 	}
 
 	options := utils.NewOptions(dsn, namespace, key, secret, flagRegion, flagQueueURL, dryRun, drop, limit, count, flagContractList)
+	options.AuditTable = flagAuditTable
+	options.QueueBackend = flagBackend
+	options.SpoolDir = flagSpoolDir
+	options.Endpoint = flagEndpoint
+	options.Concurrency = concurrency
+	options.MaxInFlight = maxInFlight
+	options.OversizeStrategy = flagOversizeStrategy
+	options.PayloadStore = flagPayloadStore
+	options.Chain = flagChain
+	options.Lanes = lanes
+	options.Routes = routes
+	options.OrderByActivity = orderByActivity
+	options.Plan = plan || planFile != ""
+	options.DiffOnly = diffOnly
+	options.PlanFile = planFile
+	options.ApplyDirect = applyDirect
+	options.Incremental = incremental
+	options.WatermarkTable = flagWatermarkTable
+	options.WatermarkFile = flagWatermarkFile
+	options.WatermarkColumn = watermarkColumn
+	options.StaleViews = staleViews
+	options.BlueGreen = blueGreen
+	options.StagingSchema = stagingSchema
+	options.TargetSchema = targetSchema
+	options.ContractsTable = contractsTable
+	options.LogsTable = logsTable
+	options.TransactionsTable = transactionsTable
+	options.TracesTable = tracesTable
+	options.DecoderUDF = decoderUDF
+	options.SkipDecoderCheck = skipDecoderCheck
+	options.AbiDir = abiDir
+	options.LogsFile = logsFile
+	options.TransactionsFile = transactionsFile
+	options.TracesFile = tracesFile
+	options.OutputDir = outputDir
+	options.ViewDir = viewDir
+	options.VerifySamples = verifySamples
+	options.DropBatchSize = dropBatchSize
+	options.DropConcurrency = dropConcurrency
+	options.MaxDrop = maxDrop
+	options.Yes = yes
+	options.BackupTable = flagBackupTable
+	options.BackupDir = backupDir
+	options.DropFilter = internal.DropFilter{
+		ContractAddresses: options.ContractList,
+		Namespace:         dropNamespace,
+		Kind:              dropKind,
+		NamePattern:       dropName,
+		OlderThan:         dropOlderThan,
+		OrphanedOnly:      dropOrphaned,
+	}
+
+	if flag.Arg(0) == "apply" {
+		if flag.NArg() != 2 {
+			log.Fatal("usage: producer [flags] apply <planfile>")
+		}
+		if err := utils.ApplyPlan(ctx, options, flag.Arg(1)); err != nil {
+			log.Fatal(err)
+		}
+		os.Exit(0)
+	}
+
+	if flag.Arg(0) == "restore" {
+		if flag.NArg() < 2 {
+			log.Fatal("usage: producer [flags] restore <run id> [view ...]")
+		}
+		if err := utils.RestoreViews(ctx, options, flag.Arg(1), flag.Args()[2:]); err != nil {
+			log.Fatal(err)
+		}
+		os.Exit(0)
+	}
+
+	if flag.Arg(0) == "install-udf" {
+		if err := utils.InstallDecoder(ctx, options); err != nil {
+			log.Fatal(err)
+		}
+		os.Exit(0)
+	}
+
+	if flag.Arg(0) == "decode" {
+		if err := utils.DecodeExports(ctx, options); err != nil {
+			log.Fatal(err)
+		}
+		os.Exit(0)
+	}
+
+	if flag.Arg(0) == "verify" {
+		if err := utils.VerifyViews(ctx, options); err != nil {
+			log.Fatal(err)
+		}
+		os.Exit(0)
+	}
+
+	if flag.Arg(0) == "rollback" {
+		if err := utils.RollbackDeployment(ctx, options); err != nil {
+			log.Fatal(err)
+		}
+		os.Exit(0)
+	}
 
 	if drop {
 		utils.DropViews(ctx, options)
@@ -0,0 +1,151 @@
+package internal
+
+import (
+	"encoding/hex"
+	"fmt"
+	"math/big"
+	"reflect"
+	"unicode/utf8"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// maxNumberBits is the size up to which the decoder UDF returns integers as numbers, larger
+// integers are returned as decimal strings as they don't fit into a double
+const maxNumberBits = 48
+
+// DecodeEventInputs decodes the inputs of an event log with go-ethereum, in the order of the
+// event inputs. The values have the representation the decoder UDF returns them in, see
+// DecodedValue. Indexed dynamic inputs are only stored as their keccak256 hash, which is
+// returned as the raw topic.
+func DecodeEventInputs(event abi.Event, data []byte, topics []common.Hash) ([]interface{}, error) {
+	unindexed, err := event.Inputs.NonIndexed().Unpack(data)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding data of event %s: %w", event.Sig, err)
+	}
+
+	topicIndex := 1
+	if event.Anonymous {
+		topicIndex = 0
+	}
+
+	values := make([]interface{}, 0, len(event.Inputs))
+	for _, input := range event.Inputs {
+		if !input.Indexed {
+			values = append(values, DecodedValue(input.Type, unindexed[0]))
+			unindexed = unindexed[1:]
+			continue
+		}
+
+		if topicIndex >= len(topics) {
+			return nil, fmt.Errorf("missing topic of indexed input %s of event %s", input.Name, event.Sig)
+		}
+		topic := topics[topicIndex]
+		topicIndex += 1
+
+		if isHashedTopic(input.Type) {
+			values = append(values, "0x"+hex.EncodeToString(topic[:]))
+			continue
+		}
+
+		value, err := abi.Arguments{{Type: input.Type}}.Unpack(topic[:])
+		if err != nil {
+			return nil, fmt.Errorf("error decoding topic of indexed input %s of event %s: %w", input.Name, event.Sig, err)
+		}
+		values = append(values, DecodedValue(input.Type, value[0]))
+	}
+
+	return values, nil
+}
+
+// DecodeMethodInputs decodes the calldata of a method call with go-ethereum, in the order of
+// the method inputs. The input starts with the 4 byte method ID.
+func DecodeMethodInputs(method abi.Method, input []byte) ([]interface{}, error) {
+	if len(input) < 4 {
+		return nil, fmt.Errorf("input of method %s is shorter than the method ID", method.Sig)
+	}
+
+	unpacked, err := method.Inputs.Unpack(input[4:])
+	if err != nil {
+		return nil, fmt.Errorf("error decoding input of method %s: %w", method.Sig, err)
+	}
+
+	values := make([]interface{}, len(unpacked))
+	for idx, value := range unpacked {
+		values[idx] = DecodedValue(method.Inputs[idx].Type, value)
+	}
+
+	return values, nil
+}
+
+// isHashedTopic is true for the indexed inputs that are stored as their keccak256 hash
+func isHashedTopic(t abi.Type) bool {
+	switch t.T {
+	case abi.StringTy, abi.BytesTy, abi.SliceTy, abi.ArrayTy, abi.TupleTy:
+		return true
+	default:
+		return false
+	}
+}
+
+// DecodedValue converts a value unpacked by go-ethereum into the value the decoder UDF
+// returns for it: integers of up to 48 bits as int64, larger integers as decimal strings,
+// addresses and bytes as lower case 0x prefixed hex, strings that aren't valid UTF-8 as hex,
+// and arrays and tuples as []interface{}.
+func DecodedValue(t abi.Type, value interface{}) interface{} {
+	v := reflect.ValueOf(value)
+
+	switch t.T {
+	case abi.IntTy, abi.UintTy:
+		n := toBigInt(v)
+		if t.Size <= maxNumberBits {
+			return n.Int64()
+		}
+		return n.String()
+	case abi.BoolTy:
+		return v.Bool()
+	case abi.AddressTy:
+		address := value.(common.Address)
+		return "0x" + hex.EncodeToString(address[:])
+	case abi.StringTy:
+		s := v.String()
+		if !utf8.ValidString(s) {
+			return "0x" + hex.EncodeToString([]byte(s))
+		}
+		return s
+	case abi.BytesTy:
+		return "0x" + hex.EncodeToString(v.Bytes())
+	case abi.FixedBytesTy, abi.FunctionTy, abi.HashTy:
+		b := make([]byte, v.Len())
+		reflect.Copy(reflect.ValueOf(b), v)
+		return "0x" + hex.EncodeToString(b)
+	case abi.SliceTy, abi.ArrayTy:
+		values := make([]interface{}, v.Len())
+		for i := range values {
+			values[i] = DecodedValue(*t.Elem, v.Index(i).Interface())
+		}
+		return values
+	case abi.TupleTy:
+		values := make([]interface{}, len(t.TupleElems))
+		for i, elem := range t.TupleElems {
+			values[i] = DecodedValue(*elem, v.Field(i).Interface())
+		}
+		return values
+	default:
+		return fmt.Sprint(value)
+	}
+}
+
+func toBigInt(v reflect.Value) *big.Int {
+	if n, ok := v.Interface().(*big.Int); ok {
+		return n
+	}
+
+	switch v.Kind() {
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return new(big.Int).SetUint64(v.Uint())
+	default:
+		return big.NewInt(v.Int())
+	}
+}
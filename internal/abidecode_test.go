@@ -0,0 +1,67 @@
+package internal
+
+import (
+	"math/big"
+	"reflect"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+func TestDecodedValue(t *testing.T) {
+	large, _ := new(big.Int).SetString("1000000000000000000000", 10)
+
+	tests := []struct {
+		name  string
+		typ   string
+		value interface{}
+		want  interface{}
+	}{
+		{name: "small unsigned integer", typ: "uint8", value: uint8(255), want: int64(255)},
+		{name: "small signed integer", typ: "int32", value: int32(-5), want: int64(-5)},
+		{name: "48 bit integer", typ: "uint48", value: big.NewInt(1 << 47), want: int64(1 << 47)},
+		{name: "large integer", typ: "uint256", value: large, want: "1000000000000000000000"},
+		{name: "negative large integer", typ: "int256", value: big.NewInt(-1), want: "-1"},
+		{name: "bool", typ: "bool", value: true, want: true},
+		{name: "address", typ: "address", value: common.HexToAddress("0xA0b86991c6218b36c1d19D4a2e9Eb0cE3606eB48"), want: "0xa0b86991c6218b36c1d19d4a2e9eb0ce3606eb48"},
+		{name: "string", typ: "string", value: "USD Coin", want: "USD Coin"},
+		{name: "invalid UTF-8 string", typ: "string", value: "\xff\xfe", want: "0xfffe"},
+		{name: "bytes", typ: "bytes", value: []byte{0xAB, 0x01}, want: "0xab01"},
+		{name: "fixed bytes", typ: "bytes4", value: [4]byte{0xa9, 0x05, 0x9c, 0xbb}, want: "0xa9059cbb"},
+		{name: "slice", typ: "uint256[]", value: []*big.Int{big.NewInt(1), large}, want: []interface{}{"1", "1000000000000000000000"}},
+		{name: "array", typ: "bool[2]", value: [2]bool{true, false}, want: []interface{}{true, false}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			typ, err := abi.NewType(test.typ, "", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got := DecodedValue(typ, test.value); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("got %#v, want %#v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestDecodedValueTuple(t *testing.T) {
+	typ, err := abi.NewType("tuple", "", []abi.ArgumentMarshaling{
+		{Name: "owner", Type: "address"},
+		{Name: "amount", Type: "uint256"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	value := reflect.New(typ.GetType()).Elem()
+	value.Field(0).Set(reflect.ValueOf(common.HexToAddress("0x01")))
+	value.Field(1).Set(reflect.ValueOf(big.NewInt(7)))
+
+	want := []interface{}{"0x0000000000000000000000000000000000000001", "7"}
+	if got := DecodedValue(typ, value.Interface()); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %#v, want %#v", got, want)
+	}
+}
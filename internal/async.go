@@ -0,0 +1,176 @@
+package internal
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	sf "github.com/snowflakedb/gosnowflake"
+)
+
+const (
+	// handoffMargin is how long before the invocation deadline a running query is handed
+	// off to a status check message instead of being waited on
+	handoffMargin = 30 * time.Second
+	// pollInterval is how often the status of a running query is checked
+	pollInterval = 5 * time.Second
+)
+
+// queryTagPrefix starts the query tag of every query submitted by the consumer
+const queryTagPrefix = "abi-sql-view-generator"
+
+// submitAsync submits the query in async mode and returns as soon as snowflake accepted it.
+// The returned channel receives the outcome of the query once it finished.
+func submitAsync(ctx context.Context, conn *sql.Conn, query string) (string, <-chan error, error) {
+	queryIDChan := make(chan string, 1)
+	res, err := conn.ExecContext(sf.WithQueryIDChan(sf.WithAsyncMode(ctx), queryIDChan), query)
+
+	queryID := ""
+	select {
+	case queryID = <-queryIDChan:
+	default:
+	}
+
+	if err != nil {
+		return queryID, nil, err
+	}
+
+	done := make(chan error, 1)
+	if res == nil {
+		// the query finished before snowflake switched to async mode
+		done <- nil
+		return queryID, done, nil
+	}
+
+	go func() {
+		// RowsAffected blocks until the async query finished
+		_, err := res.RowsAffected()
+		done <- err
+	}()
+
+	return queryID, done, nil
+}
+
+// setQueryTag sets the query tag of the session of conn. The returned function unsets it
+// again, before the connection goes back to the pool.
+func setQueryTag(ctx context.Context, conn *sql.Conn, queryTag string) (func(), error) {
+	// Query tags are built from run IDs, contract addresses and numbers, which need no escaping
+	if _, err := conn.ExecContext(ctx, fmt.Sprintf("ALTER SESSION SET QUERY_TAG = '%s'", queryTag)); err != nil {
+		return func() {}, err
+	}
+
+	return func() {
+		if _, err := conn.ExecContext(ctx, "ALTER SESSION UNSET QUERY_TAG"); err != nil {
+			log.Printf("error unsetting query tag %s: %s\n", queryTag, err)
+		}
+	}, nil
+}
+
+// FindTaggedQuery returns the ID of the latest multi-statement query submitted with the query
+// tag, or an empty string if there is none
+func FindTaggedQuery(ctx context.Context, db *sql.DB, queryTag string) (string, error) {
+	var queryID string
+	err := db.QueryRowContext(ctx, `SELECT query_id
+FROM table(information_schema.query_history(result_limit => 10000))
+WHERE query_tag = ? AND query_type = 'MULTI_STATEMENT'
+ORDER BY start_time DESC
+LIMIT 1`, queryTag).Scan(&queryID)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("error looking up query tagged %s: %w", queryTag, err)
+	}
+
+	return queryID, nil
+}
+
+// waitForResult waits for done until the invocation deadline of ctx gets close. It returns
+// false if the query is still running when the deadline is reached.
+func waitForResult(ctx context.Context, queryID string, done <-chan error) (bool, error) {
+	var handoff <-chan time.Time
+	if deadline, ok := ctx.Deadline(); ok {
+		timer := time.NewTimer(time.Until(deadline) - handoffMargin)
+		defer timer.Stop()
+		handoff = timer.C
+	}
+
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case err := <-done:
+			return true, err
+		case <-handoff:
+			log.Printf("query ID %s still running close to the invocation deadline\n", queryID)
+			return false, nil
+		case <-ticker.C:
+			log.Printf("query ID %s still running...\n", queryID)
+		}
+	}
+}
+
+// WaitForQuery polls the status of a query submitted by an earlier invocation until it
+// finished or the invocation deadline of ctx gets close. It returns false if the query is
+// still running when the deadline is reached.
+func WaitForQuery(ctx context.Context, db *sql.DB, queryID string) (bool, error) {
+	deadline, hasDeadline := ctx.Deadline()
+
+	for {
+		done, err := checkQueryStatus(ctx, db, queryID)
+		if done {
+			return true, err
+		}
+
+		if hasDeadline && time.Until(deadline) < handoffMargin+pollInterval {
+			log.Printf("query ID %s still running close to the invocation deadline\n", queryID)
+			return false, nil
+		}
+
+		log.Printf("query ID %s still running...\n", queryID)
+
+		select {
+		case <-ctx.Done():
+			return false, nil
+		case <-time.After(pollInterval):
+		}
+	}
+}
+
+// checkQueryStatus returns true once the query finished, along with its error if it failed
+func checkQueryStatus(ctx context.Context, db *sql.DB, queryID string) (bool, error) {
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		log.Printf("error checking status of query ID %s: %s\n", queryID, err)
+		return false, nil
+	}
+	defer conn.Close()
+
+	err = conn.Raw(func(driverConn interface{}) error {
+		_, err := driverConn.(sf.SnowflakeConnection).GetQueryStatus(ctx, queryID)
+		return err
+	})
+	if err == nil {
+		return true, nil
+	}
+
+	var sfErr *sf.SnowflakeError
+	if !errors.As(err, &sfErr) {
+		log.Printf("error checking status of query ID %s: %s\n", queryID, err)
+		return false, nil
+	}
+
+	switch {
+	case sfErr.Number == sf.ErrQueryIsRunning:
+		return false, nil
+	case sfErr.Number == sf.ErrQueryStatus && len(sfErr.MessageArgs) == 0:
+		// snowflake has no status for the query yet
+		return false, nil
+	}
+
+	return true, err
+}
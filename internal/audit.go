@@ -0,0 +1,236 @@
+package internal
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+const (
+	AuditStatusQueued  = "queued"
+	AuditStatusCreated = "created"
+	AuditStatusFailed  = "failed"
+	// AuditStatusUnchanged is recorded for views skipped because they already exist as is
+	AuditStatusUnchanged = "unchanged"
+
+	auditBatchSize = 500
+)
+
+type AuditRecord struct {
+	// RunID identifies the producer run the view was generated by
+	RunID string
+	// ContractAddress is the contract address the view belongs to
+	ContractAddress string
+	// ViewName is the fully qualified name of the view
+	ViewName string
+	// Signature is the event or method signature the view decodes
+	Signature string
+	// DDLHash is the hash of the create view statement as returned by HashDDL
+	DDLHash string
+	// QueryID is the snowflake query ID the view was created with
+	QueryID string
+	// Status is one of the AuditStatus constants
+	Status string
+	// Error is the error message if the view could not be queued or created
+	Error string
+}
+
+// AuditLog records the state of every view a run generates in an audit table, keyed by
+// run ID and view name. Later records for the same key update the existing row.
+type AuditLog struct {
+	db     *sql.DB
+	table  string
+	mu     sync.Mutex
+	buffer []AuditRecord
+}
+
+func NewAuditLog(db *sql.DB, table string) *AuditLog {
+	return &AuditLog{
+		db:     db,
+		table:  table,
+		buffer: make([]AuditRecord, 0, auditBatchSize),
+	}
+}
+
+// Record buffers records and writes them once enough have been collected. Call Flush to
+// write any remaining records.
+func (a *AuditLog) Record(ctx context.Context, records ...AuditRecord) error {
+	a.mu.Lock()
+	a.buffer = append(a.buffer, records...)
+	if len(a.buffer) < auditBatchSize {
+		a.mu.Unlock()
+		return nil
+	}
+	batch := a.buffer
+	a.buffer = make([]AuditRecord, 0, auditBatchSize)
+	a.mu.Unlock()
+
+	return a.Write(ctx, batch)
+}
+
+// Flush writes all buffered records
+func (a *AuditLog) Flush(ctx context.Context) error {
+	a.mu.Lock()
+	batch := a.buffer
+	a.buffer = make([]AuditRecord, 0, auditBatchSize)
+	a.mu.Unlock()
+
+	return a.Write(ctx, batch)
+}
+
+// Write merges the records into the audit table immediately
+func (a *AuditLog) Write(ctx context.Context, records []AuditRecord) error {
+	records = dedupeAuditRecords(records)
+	if len(records) == 0 {
+		return nil
+	}
+
+	placeholders := make([]string, len(records))
+	args := make([]interface{}, 0, len(records)*8)
+	for idx, r := range records {
+		placeholders[idx] = "(?, ?, ?, ?, ?, ?, ?, ?)"
+		args = append(args,
+			nullString(r.RunID),
+			nullString(r.ContractAddress),
+			nullString(r.ViewName),
+			nullString(r.Signature),
+			nullString(r.DDLHash),
+			nullString(r.QueryID),
+			nullString(r.Status),
+			nullString(r.Error),
+		)
+	}
+
+	query := fmt.Sprintf(`MERGE INTO %s t
+USING (
+    SELECT column1 AS run_id, column2 AS contract_address, column3 AS view_name, column4 AS signature,
+        column5 AS ddl_hash, column6 AS query_id, column7 AS status, column8 AS error
+    FROM VALUES %s
+) s
+ON t.run_id = s.run_id AND t.view_name = s.view_name
+WHEN MATCHED THEN UPDATE SET
+    t.signature = coalesce(s.signature, t.signature),
+    t.ddl_hash = coalesce(s.ddl_hash, t.ddl_hash),
+    t.query_id = s.query_id,
+    t.status = s.status,
+    t.error = s.error,
+    t.updated_at = current_timestamp()
+WHEN NOT MATCHED THEN INSERT (run_id, contract_address, view_name, signature, ddl_hash, query_id, status, error, created_at, updated_at)
+    VALUES (s.run_id, s.contract_address, s.view_name, s.signature, s.ddl_hash, s.query_id, s.status, s.error, current_timestamp(), current_timestamp())`,
+		a.table, strings.Join(placeholders, ", "))
+
+	if _, err := a.db.ExecContext(ctx, query, args...); err != nil {
+		return fmt.Errorf("error writing %d records to audit table %s: %w", len(records), a.table, err)
+	}
+
+	return nil
+}
+
+// dedupeAuditRecords keeps the last record for every run ID and view name, as snowflake
+// rejects a merge that matches the same target row more than once
+func dedupeAuditRecords(records []AuditRecord) []AuditRecord {
+	seen := make(map[string]int, len(records))
+	deduped := make([]AuditRecord, 0, len(records))
+	for _, r := range records {
+		key := r.RunID + "|" + r.ViewName
+		if idx, ok := seen[key]; ok {
+			deduped[idx] = r
+			continue
+		}
+		seen[key] = len(deduped)
+		deduped = append(deduped, r)
+	}
+
+	return deduped
+}
+
+func nullString(s string) sql.NullString {
+	return sql.NullString{String: s, Valid: s != ""}
+}
+
+// UnchangedAuditRecords returns the records of views skipped because they already exist
+func UnchangedAuditRecords(message *QueueMessage, views []ViewEntry) []AuditRecord {
+	records := make([]AuditRecord, len(views))
+	for idx, view := range views {
+		records[idx] = AuditRecord{
+			RunID:           message.RunID,
+			ContractAddress: message.ContractAddress,
+			ViewName:        view.ViewName,
+			Signature:       view.Signature,
+			DDLHash:         HashDDL(view.DDL),
+			Status:          AuditStatusUnchanged,
+		}
+	}
+
+	return records
+}
+
+// AuditRecords converts the outcome of executing a message into one record per view. err is
+// the error ExecuteMessage returned, if any.
+func (r *ExecutionResult) AuditRecords(message *QueueMessage, err error) []AuditRecord {
+	records := make([]AuditRecord, 0)
+	if r.Pending {
+		return records
+	}
+
+	status := AuditStatusCreated
+	errorMessage := ""
+	if err != nil {
+		status = AuditStatusFailed
+		errorMessage = err.Error()
+	}
+
+	if r.Fallback {
+		for _, result := range r.Statements {
+			record := AuditRecord{
+				RunID:           message.RunID,
+				ContractAddress: message.ContractAddress,
+				ViewName:        result.ViewName,
+				Signature:       result.Signature,
+				DDLHash:         result.DDLHash,
+				QueryID:         result.QueryID,
+				Status:          AuditStatusCreated,
+			}
+			if result.Error != nil {
+				record.Status = AuditStatusFailed
+				record.Error = result.Error.Error()
+			}
+			records = append(records, record)
+		}
+
+		return records
+	}
+
+	// Status check messages of older consumers only carry the view names
+	if message.PendingQueryID != "" && len(message.Views) == 0 {
+		for _, viewName := range message.ViewNames {
+			records = append(records, AuditRecord{
+				RunID:           message.RunID,
+				ContractAddress: message.ContractAddress,
+				ViewName:        viewName,
+				QueryID:         r.QueryID,
+				Status:          status,
+				Error:           errorMessage,
+			})
+		}
+
+		return records
+	}
+
+	for _, view := range message.Entries() {
+		records = append(records, AuditRecord{
+			RunID:           message.RunID,
+			ContractAddress: message.ContractAddress,
+			ViewName:        view.ViewName,
+			Signature:       view.Signature,
+			DDLHash:         HashDDL(view.DDL),
+			QueryID:         r.QueryID,
+			Status:          status,
+			Error:           errorMessage,
+		})
+	}
+
+	return records
+}
@@ -0,0 +1,294 @@
+package internal
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// BackupRecord holds the DDL of a view as it was before a run replaced or dropped it
+type BackupRecord struct {
+	RunID      string    `json:"run_id"`
+	ViewName   string    `json:"view_name"`
+	DDL        string    `json:"ddl"`
+	BackedUpAt time.Time `json:"backed_up_at"`
+}
+
+// DDLBackup stores the DDL of views before they are replaced or dropped, keyed by run ID
+type DDLBackup interface {
+	Save(ctx context.Context, records []BackupRecord) error
+	// Load returns the records of a run in the order they were saved
+	Load(ctx context.Context, runID string) ([]BackupRecord, error)
+}
+
+// FetchDDL returns the current DDL of the views as returned by GET_DDL, keyed by view name
+// as given
+func FetchDDL(ctx context.Context, db *sql.DB, viewNames []string) (map[string]string, error) {
+	if len(viewNames) == 0 {
+		return map[string]string{}, nil
+	}
+
+	selects := make([]string, len(viewNames))
+	args := make([]interface{}, 0, len(viewNames)*2)
+	for idx, viewName := range viewNames {
+		selects[idx] = "SELECT ?, GET_DDL('VIEW', ?)"
+		args = append(args, viewName, viewName)
+	}
+
+	rows, err := db.QueryContext(ctx, strings.Join(selects, "\nUNION ALL\n"), args...)
+	if err != nil {
+		return nil, fmt.Errorf("error reading DDL of %d views: %w", len(viewNames), err)
+	}
+	defer rows.Close()
+
+	ddl := make(map[string]string, len(viewNames))
+	for rows.Next() {
+		var viewName, definition string
+		if err := rows.Scan(&viewName, &definition); err != nil {
+			return nil, fmt.Errorf("error reading DDL of %d views: %w", len(viewNames), err)
+		}
+		ddl[viewName] = definition
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error reading DDL of %d views: %w", len(viewNames), err)
+	}
+
+	return ddl, nil
+}
+
+// BackupViews saves the current DDL of the views to the backup under the run ID
+func BackupViews(ctx context.Context, db *sql.DB, backup DDLBackup, runID string, viewNames []string) error {
+	ddl, err := FetchDDL(ctx, db, viewNames)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now().UTC()
+	records := make([]BackupRecord, 0, len(viewNames))
+	for _, viewName := range viewNames {
+		definition, ok := ddl[viewName]
+		if !ok {
+			return fmt.Errorf("no DDL returned for view %s", viewName)
+		}
+		records = append(records, BackupRecord{RunID: runID, ViewName: viewName, DDL: definition, BackedUpAt: now})
+	}
+
+	return backup.Save(ctx, records)
+}
+
+// BackupChangedViews saves the current DDL of the views that exist with a different
+// definition than the one they are about to be replaced with, or that are about to be
+// dropped
+func BackupChangedViews(ctx context.Context, db *sql.DB, backup DDLBackup, runID string, views []ViewEntry) error {
+	viewNames := make([]string, len(views))
+	for idx, view := range views {
+		viewNames[idx] = view.ViewName
+	}
+
+	existing, err := existingDefinitions(ctx, db, viewNames)
+	if err != nil {
+		return err
+	}
+
+	changed := make([]string, 0, len(existing))
+	for _, view := range views {
+		if hash, ok := existing[view.ViewName]; ok && hash != DefinitionHash(view.DDL) {
+			changed = append(changed, view.ViewName)
+		}
+	}
+
+	if len(changed) == 0 {
+		return nil
+	}
+
+	return BackupViews(ctx, db, backup, runID, changed)
+}
+
+// RestoreStatements returns the statements recreating the views of the records as they
+// were before the run. Only the first record of a view is used when a run touched it more
+// than once. If viewNames is set only those views are restored.
+func RestoreStatements(records []BackupRecord, viewNames []string) ([]ViewEntry, error) {
+	selected := make(map[string]bool, len(viewNames))
+	for _, viewName := range viewNames {
+		selected[strings.ToLower(viewName)] = false
+	}
+
+	seen := make(map[string]bool, len(records))
+	statements := make([]ViewEntry, 0, len(records))
+	for _, record := range records {
+		key := strings.ToLower(record.ViewName)
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+
+		if len(selected) > 0 {
+			if _, ok := selected[key]; !ok {
+				continue
+			}
+			selected[key] = true
+		}
+
+		statements = append(statements, ViewEntry{ViewName: record.ViewName, DDL: record.DDL})
+	}
+
+	missing := make([]string, 0)
+	for viewName, found := range selected {
+		if !found {
+			missing = append(missing, viewName)
+		}
+	}
+
+	if len(missing) > 0 {
+		sort.Strings(missing)
+		return nil, fmt.Errorf("the backup has no DDL for views %s", strings.Join(missing, ", "))
+	}
+
+	return statements, nil
+}
+
+// FileDDLBackup keeps the records of each run in a JSON lines file named after the run ID
+type FileDDLBackup struct {
+	dir string
+	mu  sync.Mutex
+}
+
+func NewFileDDLBackup(dir string) (*FileDDLBackup, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("error creating backup directory %s: %w", dir, err)
+	}
+
+	return &FileDDLBackup{dir: dir}, nil
+}
+
+func (b *FileDDLBackup) path(runID string) string {
+	return filepath.Join(b.dir, runID+".jsonl")
+}
+
+// Save appends the records to the files of their runs
+func (b *FileDDLBackup) Save(ctx context.Context, records []BackupRecord) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	byRun := make(map[string][]BackupRecord)
+	for _, record := range records {
+		byRun[record.RunID] = append(byRun[record.RunID], record)
+	}
+
+	for runID, records := range byRun {
+		if err := b.append(runID, records); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (b *FileDDLBackup) append(runID string, records []BackupRecord) error {
+	file, err := os.OpenFile(b.path(runID), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("error opening backup file: %w", err)
+	}
+
+	encoder := json.NewEncoder(file)
+	for _, record := range records {
+		if err := encoder.Encode(record); err != nil {
+			file.Close()
+			return fmt.Errorf("error writing backup file %s: %w", file.Name(), err)
+		}
+	}
+
+	// The backup has to be on disk before the views are replaced or dropped
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return fmt.Errorf("error writing backup file %s: %w", file.Name(), err)
+	}
+
+	return file.Close()
+}
+
+// Load reads the file of the run
+func (b *FileDDLBackup) Load(ctx context.Context, runID string) ([]BackupRecord, error) {
+	file, err := os.Open(b.path(runID))
+	if err != nil {
+		return nil, fmt.Errorf("error reading backup of run %s: %w", runID, err)
+	}
+	defer file.Close()
+
+	records := make([]BackupRecord, 0)
+	decoder := json.NewDecoder(file)
+	for decoder.More() {
+		record := BackupRecord{}
+		if err := decoder.Decode(&record); err != nil {
+			return nil, fmt.Errorf("error reading backup file %s: %w", file.Name(), err)
+		}
+		records = append(records, record)
+	}
+
+	return records, nil
+}
+
+// TableDDLBackup keeps the records in a backup table
+type TableDDLBackup struct {
+	db    *sql.DB
+	table string
+}
+
+func NewTableDDLBackup(db *sql.DB, table string) *TableDDLBackup {
+	return &TableDDLBackup{db: db, table: table}
+}
+
+// Save inserts the records into the backup table
+func (b *TableDDLBackup) Save(ctx context.Context, records []BackupRecord) error {
+	if len(records) == 0 {
+		return nil
+	}
+
+	placeholders := make([]string, len(records))
+	args := make([]interface{}, 0, len(records)*3)
+	for idx, record := range records {
+		placeholders[idx] = "(?, ?, ?, current_timestamp())"
+		args = append(args, record.RunID, record.ViewName, record.DDL)
+	}
+
+	query := fmt.Sprintf("INSERT INTO %s (run_id, view_name, ddl, backed_up_at) VALUES %s", b.table, strings.Join(placeholders, ", "))
+	if _, err := b.db.ExecContext(ctx, query, args...); err != nil {
+		return fmt.Errorf("error writing %d records to backup table %s: %w", len(records), b.table, err)
+	}
+
+	return nil
+}
+
+// Load queries the records of the run
+func (b *TableDDLBackup) Load(ctx context.Context, runID string) ([]BackupRecord, error) {
+	rows, err := b.db.QueryContext(ctx, fmt.Sprintf("SELECT run_id, view_name, ddl, backed_up_at FROM %s WHERE run_id = ? ORDER BY backed_up_at", b.table), runID)
+	if err != nil {
+		return nil, fmt.Errorf("error reading backup of run %s: %w", runID, err)
+	}
+	defer rows.Close()
+
+	records := make([]BackupRecord, 0)
+	for rows.Next() {
+		record := BackupRecord{}
+		var backedUpAt sql.NullTime
+		if err := rows.Scan(&record.RunID, &record.ViewName, &record.DDL, &backedUpAt); err != nil {
+			return nil, fmt.Errorf("error reading backup of run %s: %w", runID, err)
+		}
+		record.BackedUpAt = backedUpAt.Time
+		records = append(records, record)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error reading backup of run %s: %w", runID, err)
+	}
+
+	return records, nil
+}
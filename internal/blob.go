@@ -0,0 +1,81 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+)
+
+// BlobReader resolves references to stored payloads
+type BlobReader interface {
+	// Get returns the data a reference points to
+	Get(ctx context.Context, ref string) ([]byte, error)
+}
+
+// BlobStore holds message payloads that are too large to be sent through the queue
+type BlobStore interface {
+	BlobReader
+	// Put stores data under key and returns a reference that Get resolves
+	Put(ctx context.Context, key string, data []byte) (string, error)
+}
+
+// BlobStores resolves references by their URL scheme, i.e. s3:// or file://
+type BlobStores map[string]BlobStore
+
+func (s BlobStores) Get(ctx context.Context, ref string) ([]byte, error) {
+	u, err := url.Parse(ref)
+	if err != nil {
+		return nil, fmt.Errorf("invalid payload reference %s: %w", ref, err)
+	}
+
+	store, ok := s[u.Scheme]
+	if !ok {
+		return nil, fmt.Errorf("no payload store configured for %s references", u.Scheme)
+	}
+
+	return store.Get(ctx, ref)
+}
+
+// FileStore is a BlobStore on the local filesystem, a stand-in for S3 when running locally
+type FileStore struct {
+	dir string
+}
+
+func NewFileStore(dir string) (*FileStore, error) {
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	return &FileStore{dir: abs}, nil
+}
+
+func (s *FileStore) Put(ctx context.Context, key string, data []byte) (string, error) {
+	path := filepath.Join(s.dir, filepath.FromSlash(key))
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return "", fmt.Errorf("error creating payload directory: %w", err)
+	}
+
+	if err := ioutil.WriteFile(path, data, 0644); err != nil {
+		return "", fmt.Errorf("error writing payload %s: %w", path, err)
+	}
+
+	return (&url.URL{Scheme: "file", Path: filepath.ToSlash(path)}).String(), nil
+}
+
+func (s *FileStore) Get(ctx context.Context, ref string) ([]byte, error) {
+	u, err := url.Parse(ref)
+	if err != nil {
+		return nil, fmt.Errorf("invalid payload reference %s: %w", ref, err)
+	}
+
+	data, err := ioutil.ReadFile(filepath.FromSlash(u.Path))
+	if err != nil {
+		return nil, fmt.Errorf("error reading payload %s: %w", ref, err)
+	}
+
+	return data, nil
+}
@@ -0,0 +1,237 @@
+package internal
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Actions needed to bring a view in line with its generated definition
+const (
+	ViewActionCreate    = "create"
+	ViewActionReplace   = "replace"
+	ViewActionUnchanged = "unchanged"
+	// ViewActionOrphaned is an existing view that is no longer generated
+	ViewActionOrphaned = "orphaned"
+)
+
+// Classes of contracts by how their generated views compare to the existing ones
+const (
+	// ContractNew has none of its views yet
+	ContractNew = "new"
+	// ContractChanged has views that are missing or differ from their generated definition
+	ContractChanged = "changed"
+	// ContractUnchanged has all of its views with the generated definition
+	ContractUnchanged = "unchanged"
+)
+
+// DefinitionHash hashes a view definition the way snowflake stores it in
+// information_schema.views, without the terminating semicolon. The view name is left out so
+// that a view staged in another schema hashes the same as in the target schema, and so is the
+// COMMENT clause, which only carries the signature and doesn't change what the view selects.
+func DefinitionHash(ddl string) string {
+	ddl = strings.TrimSuffix(strings.TrimSpace(ddl), ";")
+	ddl = viewCommentRegex.ReplaceAllString(ddl, "")
+	if loc := viewNameRegex.FindStringSubmatchIndex(ddl); loc != nil {
+		ddl = ddl[:loc[2]] + ddl[loc[3]:]
+	}
+
+	return HashDDL(ddl)
+}
+
+// splitViewName splits a schema qualified view name into its upper cased schema and name,
+// which is how snowflake stores unquoted identifiers
+func splitViewName(viewName string) (string, string) {
+	parts := strings.Split(strings.ToUpper(viewName), ".")
+	if len(parts) < 2 {
+		return "", parts[0]
+	}
+
+	return parts[len(parts)-2], parts[len(parts)-1]
+}
+
+// ViewCatalog holds the definitions of the existing views of a namespace. The views of a
+// schema are loaded with a single query the first time a view of it is looked up.
+type ViewCatalog struct {
+	db     *sql.DB
+	prefix string
+	mu     sync.Mutex
+	// schemas maps upper cased schema names to the existing views of the schema
+	schemas map[string]map[string]catalogView
+	// contracts maps upper cased schema names to the names of the views of each contract
+	contracts map[string]map[string][]string
+	// seen holds the upper cased schema qualified names of the views looked up so far
+	seen map[string]bool
+}
+
+// NewViewCatalog creates a catalog of the views whose name starts with the namespace
+func NewViewCatalog(db *sql.DB, namespace string) *ViewCatalog {
+	return &ViewCatalog{
+		db:        db,
+		prefix:    strings.ToUpper(namespace) + "_",
+		schemas:   make(map[string]map[string]catalogView),
+		contracts: make(map[string]map[string][]string),
+		seen:      make(map[string]bool),
+	}
+}
+
+// catalogView is an existing view as found in information_schema.views
+type catalogView struct {
+	// hash is the hash of the view definition
+	hash string
+	// comment is the signature of the event or method of the view, or the deprecation
+	// note of a deprecated view
+	comment string
+}
+
+// contractAddress returns the lower cased contract address of an upper cased view name
+func (c *ViewCatalog) contractAddress(name string) string {
+	return strings.ToLower(strings.SplitN(strings.TrimPrefix(name, c.prefix), "_", 2)[0])
+}
+
+func (c *ViewCatalog) load(ctx context.Context, schema string) (map[string]catalogView, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if views, ok := c.schemas[schema]; ok {
+		return views, nil
+	}
+
+	rows, err := c.db.QueryContext(ctx, "SELECT table_name, view_definition, comment FROM information_schema.views WHERE table_schema = ? AND startswith(table_name, ?)", schema, c.prefix)
+	if err != nil {
+		return nil, fmt.Errorf("error reading views of schema %s: %w", schema, err)
+	}
+	defer rows.Close()
+
+	views := make(map[string]catalogView)
+	contracts := make(map[string][]string)
+	for rows.Next() {
+		var name string
+		var definition, comment sql.NullString
+		if err := rows.Scan(&name, &definition, &comment); err != nil {
+			return nil, fmt.Errorf("error reading views of schema %s: %w", schema, err)
+		}
+		views[name] = catalogView{hash: DefinitionHash(definition.String), comment: comment.String}
+
+		contractAddress := c.contractAddress(name)
+		contracts[contractAddress] = append(contracts[contractAddress], name)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error reading views of schema %s: %w", schema, err)
+	}
+
+	c.schemas[schema] = views
+	c.contracts[schema] = contracts
+
+	return views, nil
+}
+
+// State returns the definition hash of the existing view, or an empty string if the view
+// does not exist
+func (c *ViewCatalog) State(ctx context.Context, viewName string) (string, error) {
+	schema, name := splitViewName(viewName)
+
+	views, err := c.load(ctx, schema)
+	if err != nil {
+		return "", err
+	}
+
+	c.mu.Lock()
+	c.seen[schema+"."+name] = true
+	c.mu.Unlock()
+
+	return views[name].hash, nil
+}
+
+// Action returns what has to be done to the view to match its generated definition
+func (c *ViewCatalog) Action(ctx context.Context, view ViewEntry) (string, error) {
+	action, _, err := c.Lookup(ctx, view)
+	return action, err
+}
+
+// Lookup returns the action for the view along with the definition hash of the existing
+// view it was computed against
+func (c *ViewCatalog) Lookup(ctx context.Context, view ViewEntry) (string, string, error) {
+	state, err := c.State(ctx, view.ViewName)
+	if err != nil {
+		return "", "", err
+	}
+
+	switch {
+	case state == "":
+		return ViewActionCreate, state, nil
+	case state != DefinitionHash(view.DDL):
+		return ViewActionReplace, state, nil
+	default:
+		return ViewActionUnchanged, state, nil
+	}
+}
+
+// Classify returns the class of a contract with the given generated views
+func (c *ViewCatalog) Classify(ctx context.Context, views []ViewEntry) (string, error) {
+	created, unchanged := 0, 0
+	for _, view := range views {
+		action, err := c.Action(ctx, view)
+		if err != nil {
+			return "", err
+		}
+
+		switch action {
+		case ViewActionCreate:
+			created += 1
+		case ViewActionUnchanged:
+			unchanged += 1
+		}
+	}
+
+	switch {
+	case created == len(views):
+		return ContractNew, nil
+	case unchanged == len(views):
+		return ContractUnchanged, nil
+	default:
+		return ContractChanged, nil
+	}
+}
+
+// Orphaned returns the existing views of the loaded schemas that were never looked up, i.e.
+// views of events or methods that are no longer part of the ABI. When contractAddresses is
+// set only the views of those contracts are returned, otherwise views of any contract.
+func (c *ViewCatalog) Orphaned(contractAddresses []string) []PlanEntry {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	contracts := make(map[string]bool, len(contractAddresses))
+	for _, contractAddress := range contractAddresses {
+		contracts[strings.ToLower(contractAddress)] = true
+	}
+
+	entries := make([]PlanEntry, 0)
+	for schema, views := range c.schemas {
+		for name := range views {
+			if c.seen[schema+"."+name] {
+				continue
+			}
+
+			contractAddress := c.contractAddress(name)
+			if len(contracts) > 0 && !contracts[contractAddress] {
+				continue
+			}
+
+			entries = append(entries, PlanEntry{
+				ContractAddress: contractAddress,
+				Action:          ViewActionOrphaned,
+				State:           views[name].hash,
+				ViewEntry:       ViewEntry{ViewName: strings.ToLower(schema + "." + name), Signature: views[name].comment},
+			})
+		}
+	}
+
+	sort.Slice(entries, func(i, j int) bool { return entries[i].ViewName < entries[j].ViewName })
+
+	return entries
+}
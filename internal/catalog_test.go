@@ -0,0 +1,42 @@
+package internal
+
+import "testing"
+
+func TestDefinitionHash(t *testing.T) {
+	generated := "CREATE OR REPLACE VIEW ethereum_contracts.ns_0xabc_evt_Transfer\n    COMMENT = 'Transfer(address,address,uint256)'\n    AS\n        SELECT 1;"
+
+	tests := []struct {
+		name       string
+		definition string
+		same       bool
+	}{
+		{
+			name:       "stored without the semicolon",
+			definition: "CREATE OR REPLACE VIEW ethereum_contracts.ns_0xabc_evt_Transfer\n    COMMENT = 'Transfer(address,address,uint256)'\n    AS\n        SELECT 1",
+			same:       true,
+		},
+		{
+			name:       "staged in another schema",
+			definition: "CREATE OR REPLACE VIEW ethereum_contracts_staging.ns_0xabc_evt_Transfer\n    COMMENT = 'Transfer(address,address,uint256)'\n    AS\n        SELECT 1;",
+			same:       true,
+		},
+		{
+			name:       "created before the signature comment",
+			definition: "CREATE OR REPLACE VIEW ethereum_contracts.ns_0xabc_evt_Transfer\n    AS\n        SELECT 1;",
+			same:       true,
+		},
+		{
+			name:       "different query",
+			definition: "CREATE OR REPLACE VIEW ethereum_contracts.ns_0xabc_evt_Transfer\n    COMMENT = 'Transfer(address,address,uint256)'\n    AS\n        SELECT 2;",
+			same:       false,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if same := DefinitionHash(test.definition) == DefinitionHash(generated); same != test.same {
+				t.Errorf("got same hash %v, want %v", same, test.same)
+			}
+		})
+	}
+}
@@ -21,3 +21,35 @@ func NewConfig(key, secret, region string) Config {
 
 	return Config(cfg)
 }
+
+// NewConfigWithEndpoint creates a config that sends all requests to endpoint instead of
+// the default AWS endpoints. This allows running against SQS compatible stand-ins such
+// as ElasticMQ or LocalStack. An empty endpoint behaves the same as NewConfig.
+func NewConfigWithEndpoint(key, secret, region, endpoint string) Config {
+	if endpoint == "" {
+		cfg := NewConfig(key, secret, region)
+		if region != "" {
+			cfg.Region = region
+		}
+		return cfg
+	}
+
+	resolver := aws.EndpointResolverWithOptionsFunc(func(service, region string, options ...interface{}) (aws.Endpoint, error) {
+		return aws.Endpoint{
+			URL:               endpoint,
+			SigningRegion:     region,
+			HostnameImmutable: true,
+		}, nil
+	})
+
+	cfg, err := config.LoadDefaultConfig(context.TODO(),
+		config.WithCredentialsProvider(credentials.NewStaticCredentialsProvider(key, secret, "")),
+		config.WithEndpointResolverWithOptions(resolver),
+		config.WithRegion(region),
+	)
+	if err != nil {
+		log.Fatal("error creating AWS config:", err)
+	}
+
+	return Config(cfg)
+}
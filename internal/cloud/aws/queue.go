@@ -0,0 +1,212 @@
+package aws
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	"github.com/credmark/abi-sql-view-generator/internal"
+)
+
+const (
+	// sqsMaxBatchEntries and sqsMaxBatchBytes are the limits of a single SendMessageBatch call
+	sqsMaxBatchEntries = 10
+	sqsMaxBatchBytes   = 256 * 1024
+	// maxBatchAttempts is how often entries that failed with a retryable error are resent
+	maxBatchAttempts = 5
+)
+
+// SQSQueue implements internal.Queue on top of a single SQS queue
+type SQSQueue struct {
+	client            *sqs.Client
+	queueURL          string
+	visibilityTimeout time.Duration
+	fifo              bool
+}
+
+func NewSQSQueue(client *sqs.Client, queueURL string, visibilityTimeout time.Duration) *SQSQueue {
+	return &SQSQueue{
+		client:            client,
+		queueURL:          queueURL,
+		visibilityTimeout: visibilityTimeout,
+		fifo:              IsFIFOQueue(queueURL),
+	}
+}
+
+// IsFIFOQueue reports whether the queue is a FIFO queue, which SQS requires to be named
+// with a .fifo suffix
+func IsFIFOQueue(queueURL string) bool {
+	return strings.HasSuffix(queueURL, ".fifo")
+}
+
+// fifoAttributes returns the group and deduplication IDs to send the message with, and the
+// delay which FIFO queues only support per queue
+func (q *SQSQueue) fifoAttributes(message internal.Message) (*string, *string, int32) {
+	if !q.fifo {
+		return nil, nil, int32(message.Delay / time.Second)
+	}
+
+	var groupID, deduplicationID *string
+	if message.GroupID != "" {
+		groupID = aws.String(message.GroupID)
+	}
+	if message.DeduplicationID != "" {
+		deduplicationID = aws.String(message.DeduplicationID)
+	}
+
+	return groupID, deduplicationID, 0
+}
+
+func (q *SQSQueue) Publish(ctx context.Context, message internal.Message) error {
+	groupID, deduplicationID, delay := q.fifoAttributes(message)
+
+	_, err := q.client.SendMessage(ctx, &sqs.SendMessageInput{
+		MessageBody:            aws.String(message.Body),
+		QueueUrl:               aws.String(q.queueURL),
+		DelaySeconds:           delay,
+		MessageGroupId:         groupID,
+		MessageDeduplicationId: deduplicationID,
+	})
+	if err != nil {
+		return fmt.Errorf("error sending SQS message: %w", err)
+	}
+
+	return nil
+}
+
+// PublishBatch sends the messages with as few SendMessageBatch calls as the SQS limits allow.
+// Entries that fail with a retryable error are resent on their own, entries rejected because
+// of the message itself are not.
+func (q *SQSQueue) PublishBatch(ctx context.Context, messages []internal.Message) []error {
+	errs := make([]error, len(messages))
+
+	for _, chunk := range chunkMessages(messages) {
+		pending := chunk
+		for attempt := 1; len(pending) > 0; attempt++ {
+			pending = q.sendBatch(ctx, messages, pending, errs)
+			if len(pending) == 0 || attempt >= maxBatchAttempts {
+				break
+			}
+
+			select {
+			case <-ctx.Done():
+				return errs
+			case <-time.After(time.Duration(attempt*attempt) * 100 * time.Millisecond):
+			}
+		}
+	}
+
+	return errs
+}
+
+// sendBatch sends the messages at the given indices and returns the indices to retry
+func (q *SQSQueue) sendBatch(ctx context.Context, messages []internal.Message, indices []int, errs []error) []int {
+	entries := make([]types.SendMessageBatchRequestEntry, len(indices))
+	for idx, i := range indices {
+		groupID, deduplicationID, delay := q.fifoAttributes(messages[i])
+		entries[idx] = types.SendMessageBatchRequestEntry{
+			Id:                     aws.String(strconv.Itoa(i)),
+			MessageBody:            aws.String(messages[i].Body),
+			DelaySeconds:           delay,
+			MessageGroupId:         groupID,
+			MessageDeduplicationId: deduplicationID,
+		}
+	}
+
+	result, err := q.client.SendMessageBatch(ctx, &sqs.SendMessageBatchInput{
+		Entries:  entries,
+		QueueUrl: aws.String(q.queueURL),
+	})
+	if err != nil {
+		for _, i := range indices {
+			errs[i] = fmt.Errorf("error sending SQS message batch: %w", err)
+		}
+		return indices
+	}
+
+	for _, entry := range result.Successful {
+		i, _ := strconv.Atoi(aws.ToString(entry.Id))
+		errs[i] = nil
+	}
+
+	retry := make([]int, 0)
+	for _, entry := range result.Failed {
+		i, _ := strconv.Atoi(aws.ToString(entry.Id))
+		errs[i] = fmt.Errorf("error sending SQS message: %s: %s", aws.ToString(entry.Code), aws.ToString(entry.Message))
+		if !entry.SenderFault {
+			retry = append(retry, i)
+		}
+	}
+
+	return retry
+}
+
+// chunkMessages groups message indices into batches within the SQS entry and size limits
+func chunkMessages(messages []internal.Message) [][]int {
+	chunks := make([][]int, 0)
+	current := make([]int, 0, sqsMaxBatchEntries)
+	size := 0
+
+	for i, message := range messages {
+		if len(current) == sqsMaxBatchEntries || (len(current) > 0 && size+len(message.Body) > sqsMaxBatchBytes) {
+			chunks = append(chunks, current)
+			current = make([]int, 0, sqsMaxBatchEntries)
+			size = 0
+		}
+
+		current = append(current, i)
+		size += len(message.Body)
+	}
+
+	if len(current) > 0 {
+		chunks = append(chunks, current)
+	}
+
+	return chunks
+}
+
+func (q *SQSQueue) Receive(ctx context.Context, maxMessages int, wait time.Duration) ([]internal.Delivery, error) {
+	if maxMessages > 10 {
+		maxMessages = 10
+	}
+
+	messages, err := ReceiveSQSMessages(ctx, q.client, q.queueURL, int32(maxMessages), int32(wait/time.Second), int32(q.visibilityTimeout/time.Second))
+	if err != nil {
+		return nil, err
+	}
+
+	deliveries := make([]internal.Delivery, len(messages))
+	for idx, m := range messages {
+		receiveCount, _ := strconv.Atoi(m.Attributes[string(types.MessageSystemAttributeNameApproximateReceiveCount)])
+		deliveries[idx] = internal.Delivery{
+			ID:           aws.ToString(m.MessageId),
+			Body:         aws.ToString(m.Body),
+			Handle:       aws.ToString(m.ReceiptHandle),
+			ReceiveCount: receiveCount,
+			GroupID:      m.Attributes[string(types.MessageSystemAttributeNameMessageGroupId)],
+		}
+	}
+
+	return deliveries, nil
+}
+
+func (q *SQSQueue) Ack(ctx context.Context, delivery internal.Delivery) error {
+	_, err := q.client.DeleteMessage(ctx, &sqs.DeleteMessageInput{
+		QueueUrl:      aws.String(q.queueURL),
+		ReceiptHandle: aws.String(delivery.Handle),
+	})
+	if err != nil {
+		return fmt.Errorf("error deleting SQS message: %w", err)
+	}
+
+	return nil
+}
+
+func (q *SQSQueue) Extend(ctx context.Context, delivery internal.Delivery, timeout time.Duration) error {
+	return ExtendSQSMessageVisibility(ctx, q.client, q.queueURL, delivery.Handle, int32(timeout/time.Second))
+}
@@ -0,0 +1,109 @@
+package aws
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"path"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/credmark/abi-sql-view-generator/internal"
+)
+
+// S3Store is a BlobStore that keeps payloads in an S3 bucket under a key prefix
+type S3Store struct {
+	client *s3.Client
+	bucket string
+	prefix string
+}
+
+// NewS3Store creates a store for a location of the form s3://bucket/prefix
+func NewS3Store(cfg Config, location string) (*S3Store, error) {
+	u, err := url.Parse(location)
+	if err != nil || u.Scheme != "s3" || u.Host == "" {
+		return nil, fmt.Errorf("invalid S3 location %q, expected s3://bucket/prefix", location)
+	}
+
+	client := s3.NewFromConfig(aws.Config(cfg), func(o *s3.Options) {
+		// custom endpoints such as LocalStack or MinIO don't support virtual hosted buckets
+		o.UsePathStyle = true
+	})
+
+	return &S3Store{
+		client: client,
+		bucket: u.Host,
+		prefix: strings.Trim(u.Path, "/"),
+	}, nil
+}
+
+func (s *S3Store) Put(ctx context.Context, key string, data []byte) (string, error) {
+	objectKey := path.Join(s.prefix, key)
+
+	_, err := s.client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(objectKey),
+		Body:   bytes.NewReader(data),
+	})
+	if err != nil {
+		return "", fmt.Errorf("error uploading payload to s3://%s/%s: %w", s.bucket, objectKey, err)
+	}
+
+	return fmt.Sprintf("s3://%s/%s", s.bucket, objectKey), nil
+}
+
+func (s *S3Store) Get(ctx context.Context, ref string) ([]byte, error) {
+	u, err := url.Parse(ref)
+	if err != nil {
+		return nil, fmt.Errorf("invalid payload reference %s: %w", ref, err)
+	}
+
+	result, err := s.client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(u.Host),
+		Key:    aws.String(strings.TrimPrefix(u.Path, "/")),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error downloading payload %s: %w", ref, err)
+	}
+	defer result.Body.Close()
+
+	return ioutil.ReadAll(result.Body)
+}
+
+// NewBlobStore creates a store for s3://bucket/prefix locations, or a local FileStore for
+// file:///path locations
+func NewBlobStore(cfg Config, location string) (internal.BlobStore, error) {
+	u, err := url.Parse(location)
+	if err != nil {
+		return nil, fmt.Errorf("invalid payload store location %q: %w", location, err)
+	}
+
+	switch u.Scheme {
+	case "s3":
+		return NewS3Store(cfg, location)
+	case "file":
+		return internal.NewFileStore(u.Path)
+	default:
+		return nil, fmt.Errorf("unsupported payload store location %q, expected s3:// or file://", location)
+	}
+}
+
+// NewBlobStores creates a resolver for references to the store at location. An empty
+// location returns nil, meaning payload references cannot be resolved.
+func NewBlobStores(cfg Config, location string) (internal.BlobStores, error) {
+	if location == "" {
+		return nil, nil
+	}
+
+	store, err := NewBlobStore(cfg, location)
+	if err != nil {
+		return nil, err
+	}
+
+	u, _ := url.Parse(location)
+
+	return internal.BlobStores{u.Scheme: store}, nil
+}
@@ -2,17 +2,24 @@ package aws
 
 import (
 	"context"
-	"database/sql"
 	"fmt"
 	"log"
+	"strconv"
+	"strings"
 
 	"github.com/aws/aws-lambda-go/events"
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
 	"github.com/credmark/abi-sql-view-generator/internal"
-	sf "github.com/snowflakedb/gosnowflake"
 )
 
+func GetQueueName(url string) string {
+	split := strings.Split(url, "/")
+
+	return split[len(split)-1]
+}
+
 func SendSQSMessage(cfg Config, queueURL string, body string) error {
 	config := aws.Config(cfg)
 	client := sqs.NewFromConfig(config)
@@ -28,34 +35,81 @@ func SendSQSMessage(cfg Config, queueURL string, body string) error {
 	return nil
 }
 
-func HandleSQSMessage(ctx context.Context, client *sqs.Client, event events.SQSMessage, queueName string, db *sql.DB) error {
-
-	message, err := internal.DeserializeMessage(event.Body)
+// HandleSQSMessage executes the statements of a single message received by the lambda
+// handler and deletes it from the queue
+func HandleSQSMessage(ctx context.Context, client *sqs.Client, event events.SQSMessage, queueName string, handler *internal.MessageHandler) error {
+	queueURL, err := getQueueURL(ctx, client, queueName)
 	if err != nil {
-		return fmt.Errorf("error deserializing SQS message body: %w", err)
+		return fmt.Errorf("error running getQueueURL: %w", err)
 	}
 
-	log.Printf("message details: ContractAddress=%s NumberOfStatements=%d\n", message.ContractAddress, message.NumberOfStatements)
-
-	if message.NumberOfStatements == 0 {
-		log.Println("message has 0 sql statements to process. Deleting message...")
-		return DeleteSQSMessage(ctx, client, queueName, event.ReceiptHandle)
+	receiveCount, _ := strconv.Atoi(event.Attributes["ApproximateReceiveCount"])
+	delivery := internal.Delivery{
+		ID:           event.MessageId,
+		Body:         event.Body,
+		Handle:       event.ReceiptHandle,
+		ReceiveCount: receiveCount,
+		GroupID:      event.Attributes["MessageGroupId"],
 	}
 
-	uuid := sf.NewUUID()
-	ctxWithId := sf.WithRequestID(ctx, uuid)
-	multiStatementCtx, _ := sf.WithMultiStatement(ctxWithId, message.NumberOfStatements)
+	return handler.Handle(ctx, NewSQSQueue(client, queueURL, 0), delivery)
+}
+
+// GroupSQSRecords groups the records of a lambda batch by FIFO message group, keeping the
+// order they were received in. Records of standard queues have no group and each of them is
+// a group of its own.
+func GroupSQSRecords(records []events.SQSMessage) [][]events.SQSMessage {
+	groups := make([][]events.SQSMessage, 0)
+	byGroupID := make(map[string]int)
+	for _, record := range records {
+		groupID, ok := record.Attributes["MessageGroupId"]
+		if !ok || groupID == "" {
+			groups = append(groups, []events.SQSMessage{record})
+			continue
+		}
+
+		idx, ok := byGroupID[groupID]
+		if !ok {
+			idx = len(groups)
+			byGroupID[groupID] = idx
+			groups = append(groups, []events.SQSMessage{})
+		}
+		groups[idx] = append(groups[idx], record)
+	}
 
-	log.Printf("submitting query with query ID: %s\n", uuid.String())
+	return groups
+}
 
-	_, err = db.ExecContext(multiStatementCtx, message.SQLStatements)
+// ReceiveSQSMessages long polls the queue for up to maxMessages messages. Received messages
+// stay hidden from other consumers for visibilityTimeout seconds.
+func ReceiveSQSMessages(ctx context.Context, client *sqs.Client, queueURL string, maxMessages, waitTime, visibilityTimeout int32) ([]types.Message, error) {
+	result, err := client.ReceiveMessage(ctx, &sqs.ReceiveMessageInput{
+		QueueUrl:            aws.String(queueURL),
+		MaxNumberOfMessages: maxMessages,
+		WaitTimeSeconds:     waitTime,
+		VisibilityTimeout:   visibilityTimeout,
+		AttributeNames:      []types.QueueAttributeName{types.QueueAttributeNameAll},
+	})
 	if err != nil {
-		return fmt.Errorf("error with multistatement query for contract address: %s: %w", message.ContractAddress, err)
+		return nil, fmt.Errorf("error receiving SQS messages: %w", err)
 	}
 
-	log.Printf("query ID %s completed. Deleting SQS message receipt handle %s\n", uuid.String(), event.ReceiptHandle)
+	return result.Messages, nil
+}
 
-	return DeleteSQSMessage(ctx, client, queueName, event.ReceiptHandle)
+// ExtendSQSMessageVisibility hides a received message from other consumers for another
+// visibilityTimeout seconds.
+func ExtendSQSMessageVisibility(ctx context.Context, client *sqs.Client, queueURL string, receiptHandle string, visibilityTimeout int32) error {
+	_, err := client.ChangeMessageVisibility(ctx, &sqs.ChangeMessageVisibilityInput{
+		QueueUrl:          aws.String(queueURL),
+		ReceiptHandle:     aws.String(receiptHandle),
+		VisibilityTimeout: visibilityTimeout,
+	})
+	if err != nil {
+		return fmt.Errorf("error extending SQS message visibility: %w", err)
+	}
+
+	return nil
 }
 
 func DeleteSQSMessage(ctx context.Context, client *sqs.Client, queueName string, receiptHandle string) error {
@@ -0,0 +1,188 @@
+package internal
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+)
+
+// pendingMessageDelay is how long a status check message, or a FIFO message kept in flight,
+// stays invisible before the query it refers to is checked again
+const pendingMessageDelay = 30 * time.Second
+
+// ErrQueryRunning is returned for a FIFO message whose query is still running when the
+// invocation has to return. The message is kept in flight, which holds back the rest of its
+// message group, and the query is picked up again when the message is redelivered.
+var ErrQueryRunning = errors.New("query still running")
+
+// MessageHandler executes the statements of messages received from a queue
+type MessageHandler struct {
+	// DB is the connection pool statements are executed on, snowflake unless Execute is set
+	DB *sql.DB
+	// AuditLog receives the outcome of every view, auditing is disabled if nil
+	AuditLog *AuditLog
+	// BlobStore resolves the payload of messages too large for the queue
+	BlobStore BlobReader
+	// SkipUnchanged leaves out views that already exist with the same definition
+	SkipUnchanged bool
+	// Backup receives the DDL of views before they are replaced or dropped, no backup is
+	// made if nil
+	Backup DDLBackup
+	// Execute executes the statements of a message, ExecuteMessage if nil
+	Execute func(ctx context.Context, db *sql.DB, message *QueueMessage) (*ExecutionResult, error)
+}
+
+// Handle executes the statements of a single delivery and acknowledges it on the queue it
+// was received from
+func (h *MessageHandler) Handle(ctx context.Context, queue Queue, delivery Delivery) error {
+
+	message, err := DeserializeMessage(delivery.Body)
+	if err != nil {
+		return fmt.Errorf("error deserializing message body: %w", err)
+	}
+
+	// A status check for the query of the message refers to the same payload
+	payloadRef := message.PayloadRef
+	message, err = ResolveMessage(ctx, h.BlobStore, message)
+	if err != nil {
+		return err
+	}
+
+	if message.TotalParts > 0 {
+		log.Printf("message is part %d of %d for contract address %s\n", message.Part, message.TotalParts, message.ContractAddress)
+	}
+
+	log.Printf("message details: ContractAddress=%s NumberOfStatements=%d\n", message.ContractAddress, message.NumberOfStatements)
+
+	if message.NumberOfStatements == 0 {
+		log.Println("message has 0 sql statements to process. Deleting message...")
+		return queue.Ack(ctx, delivery)
+	}
+
+	// A FIFO message redelivered after its query outlived an invocation resumes that query
+	if delivery.GroupID != "" && delivery.ReceiveCount > 1 && message.PendingQueryID == "" {
+		queryID, err := FindTaggedQuery(ctx, h.DB, message.QueryTag())
+		if err != nil {
+			log.Printf("ERROR: %s\n", err)
+		} else if queryID != "" {
+			log.Printf("message %s was redelivered, resuming query ID %s for contract address %s\n", delivery.ID, queryID, message.ContractAddress)
+			message = NewPendingMessage(message, queryID, "")
+		}
+	}
+
+	if h.SkipUnchanged && message.PendingQueryID == "" {
+		changed, unchanged, err := SkipUnchangedViews(ctx, h.DB, message)
+		if err != nil {
+			return err
+		}
+
+		if len(unchanged) > 0 {
+			log.Printf("skipping %d of %d views that exist unchanged for contract address %s\n", len(unchanged), message.NumberOfStatements, message.ContractAddress)
+			h.audit(ctx, UnchangedAuditRecords(message, unchanged))
+		}
+
+		message = changed
+		if message.NumberOfStatements == 0 {
+			return queue.Ack(ctx, delivery)
+		}
+	}
+
+	if h.Backup != nil && message.PendingQueryID == "" {
+		if err := BackupChangedViews(ctx, h.DB, h.Backup, message.RunID, message.Entries()); err != nil {
+			return fmt.Errorf("error backing up views of contract address %s: %w", message.ContractAddress, err)
+		}
+	}
+
+	execute := h.Execute
+	if execute == nil {
+		execute = ExecuteMessage
+	}
+
+	result, err := execute(ctx, h.DB, message)
+
+	h.audit(ctx, result.AuditRecords(message, err))
+
+	if err != nil {
+		return err
+	}
+
+	if result.Pending {
+		if delivery.GroupID != "" {
+			return holdPendingMessage(ctx, queue, delivery, result.QueryID)
+		}
+
+		if err := requeuePendingMessage(ctx, queue, message, payloadRef, result.QueryID); err != nil {
+			return err
+		}
+
+		log.Printf("query ID %s handed off to a status check message. Deleting message %s\n", result.QueryID, delivery.ID)
+
+		return queue.Ack(ctx, delivery)
+	}
+
+	if result.Partial() {
+		failed := result.Failed()
+		log.Printf("PARTIAL: contractAddress=%s %d of %d views failed\n", message.ContractAddress, len(failed), len(result.Statements))
+		for _, f := range failed {
+			log.Printf("FAILED: contractAddress=%s view=%s queryID=%s error=%s\n", message.ContractAddress, f.ViewName, f.QueryID, f.Error.Error())
+		}
+	}
+
+	if !result.Fallback {
+		for _, view := range message.Entries() {
+			log.Printf("CREATED: contractAddress=%s view=%s queryID=%s\n", message.ContractAddress, view.ViewName, result.QueryID)
+		}
+	}
+
+	log.Printf("query ID %s completed. Deleting message %s\n", result.QueryID, delivery.ID)
+
+	return queue.Ack(ctx, delivery)
+}
+
+func (h *MessageHandler) audit(ctx context.Context, records []AuditRecord) {
+	if h.AuditLog == nil || len(records) == 0 {
+		return
+	}
+
+	if err := h.AuditLog.Write(ctx, records); err != nil {
+		log.Println("ERROR:", err)
+	}
+}
+
+// holdPendingMessage keeps a FIFO message whose query is still running in flight instead of
+// handing the query off to a status check message, which would be queued behind newer
+// statements of the same message group. The message is delivered again after
+// pendingMessageDelay and finds its query by the query tag.
+func holdPendingMessage(ctx context.Context, queue Queue, delivery Delivery, queryID string) error {
+	if err := queue.Extend(ctx, delivery, pendingMessageDelay); err != nil {
+		return fmt.Errorf("error keeping message %s of running query ID %s in flight: %w", delivery.ID, queryID, err)
+	}
+
+	return fmt.Errorf("query ID %s is still running, message %s stays in flight: %w", queryID, delivery.ID, ErrQueryRunning)
+}
+
+// requeuePendingMessage publishes a status check message for a query that is still running
+// so that a later invocation can pick it up instead of the whole batch being redriven
+func requeuePendingMessage(ctx context.Context, publisher Publisher, message *QueueMessage, payloadRef string, queryID string) error {
+	pending := NewPendingMessage(message, queryID, payloadRef)
+	body, err := SerializeMessage(pending)
+	if err != nil {
+		return err
+	}
+
+	err = publisher.Publish(ctx, Message{
+		Body:    body,
+		Delay:   pendingMessageDelay,
+		GroupID: pending.GroupID(),
+		// a query can be handed off several times, each status check has to be delivered
+		DeduplicationID: HashDDL(fmt.Sprintf("%s:%d", queryID, time.Now().UnixNano())),
+	})
+	if err != nil {
+		return fmt.Errorf("error requeueing pending query ID %s for contract address %s: %w", queryID, message.ContractAddress, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,128 @@
+package internal
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+const (
+	// DecoderVersion is the version of the decoder UDF in templates/decoder.sql, it has to
+	// be increased with every change of the UDF
+	DecoderVersion = "1"
+
+	// decoderCommentPrefix starts the comment the decoder UDF is installed with, followed
+	// by its version
+	decoderCommentPrefix = "abi-sql-view-generator decoder "
+	// decoderUnverifiedSuffix follows the version of a decoder UDF that was installed but
+	// not compared to the existing UDF yet
+	decoderUnverifiedSuffix = " unverified"
+)
+
+// ErrDecoderUnversioned is returned for a decoder UDF that doesn't exist or has no version
+// comment, such as a UDF installed by other means, whose version can't be checked
+var ErrDecoderUnversioned = errors.New("decoder UDF has no version")
+
+// DecoderComment returns the comment identifying the version of an installed decoder UDF
+func DecoderComment(version string) string {
+	return decoderCommentPrefix + version
+}
+
+// UnverifiedDecoderComment returns the comment a decoder UDF is installed with until it was
+// compared to the existing UDF. Runs refuse to use it as its version doesn't match.
+func UnverifiedDecoderComment(version string) string {
+	return DecoderComment(version) + decoderUnverifiedSuffix
+}
+
+// VersionedDecoderUDF returns the name a version of the decoder UDF is installed under, next
+// to the UDF the views use
+func VersionedDecoderUDF(udf string, version string) string {
+	return udf + "_v" + version
+}
+
+// MarkDecoderVerified sets the comment of a decoder UDF installed with
+// UnverifiedDecoderComment to its version, once it was compared to the existing UDF
+func MarkDecoderVerified(ctx context.Context, db *sql.DB, udf string, version string) error {
+	query := fmt.Sprintf("ALTER FUNCTION %s(VARCHAR, VARCHAR, VARIANT, VARCHAR, BOOLEAN) SET COMMENT = '%s'", udf, DecoderComment(version))
+	if _, err := db.ExecContext(ctx, query); err != nil {
+		return fmt.Errorf("error marking decoder UDF %s as verified: %w", udf, err)
+	}
+
+	return nil
+}
+
+// DeployedDecoderVersions returns the versions of the decoder UDFs installed under the
+// name, an empty version for a function that was not installed from templates/decoder.sql.
+// It returns no versions if the function does not exist.
+func DeployedDecoderVersions(ctx context.Context, db *sql.DB, udf string) ([]string, error) {
+	parts := strings.Split(strings.ToUpper(udf), ".")
+	if len(parts) < 2 {
+		return nil, fmt.Errorf("decoder UDF %s is not schema qualified", udf)
+	}
+
+	informationSchema := "information_schema"
+	if len(parts) > 2 {
+		informationSchema = parts[len(parts)-3] + ".information_schema"
+	}
+
+	query := fmt.Sprintf("SELECT comment FROM %s.functions WHERE function_schema = ? AND function_name = ?", informationSchema)
+	rows, err := db.QueryContext(ctx, query, parts[len(parts)-2], parts[len(parts)-1])
+	if err != nil {
+		return nil, fmt.Errorf("error looking up decoder UDF %s: %w", udf, err)
+	}
+	defer rows.Close()
+
+	versions := make([]string, 0)
+	for rows.Next() {
+		var comment sql.NullString
+		if err := rows.Scan(&comment); err != nil {
+			return nil, fmt.Errorf("error looking up decoder UDF %s: %w", udf, err)
+		}
+
+		version := ""
+		if strings.HasPrefix(comment.String, decoderCommentPrefix) {
+			version = strings.TrimPrefix(comment.String, decoderCommentPrefix)
+		}
+		versions = append(versions, version)
+	}
+
+	return versions, rows.Err()
+}
+
+// CheckDecoder returns an error unless the version of the decoder UDF is installed. It wraps
+// ErrDecoderUnversioned if the UDF doesn't exist or none of the functions under its name has
+// a version comment.
+func CheckDecoder(ctx context.Context, db *sql.DB, udf string, version string) error {
+	versions, err := DeployedDecoderVersions(ctx, db, udf)
+	if err != nil {
+		return err
+	}
+
+	if len(versions) == 0 {
+		return fmt.Errorf("decoder UDF %s does not exist, install it with install-udf: %w", udf, ErrDecoderUnversioned)
+	}
+
+	versioned := make([]string, 0, len(versions))
+	for _, deployed := range versions {
+		if deployed == version {
+			return nil
+		}
+		if deployed != "" {
+			versioned = append(versioned, deployed)
+		}
+	}
+
+	if len(versioned) == 0 {
+		return fmt.Errorf("decoder UDF %s has no version comment: %w", udf, ErrDecoderUnversioned)
+	}
+
+	for _, deployed := range versioned {
+		if deployed == version+decoderUnverifiedSuffix {
+			return fmt.Errorf("decoder UDF %s version %s was installed but not verified against the existing UDF, see the report of install-udf", udf, version)
+		}
+	}
+
+	return fmt.Errorf("decoder UDF %s has version %q, expected %q, install it with install-udf and point the views at it with -decoder-udf", udf, strings.Join(versioned, ", "), version)
+}
@@ -0,0 +1,116 @@
+package internal
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// DropFilter narrows down the views a drop applies to. The zero value matches every view.
+type DropFilter struct {
+	// ContractAddresses only matches the views of these contracts
+	ContractAddresses []string
+	// Namespace only matches the views of this namespace
+	Namespace string
+	// Kind only matches event (evt) or function (fn) views
+	Kind string
+	// NamePattern only matches views of events or methods with a matching name, * matches
+	// any number of characters and ? a single character
+	NamePattern string
+	// OlderThan only matches views that were not created or replaced within this duration
+	OlderThan time.Duration
+	// OrphanedOnly only matches views that are no longer generated from the ABI source
+	OrphanedOnly bool
+}
+
+// Validate checks the kind and name pattern of the filter
+func (f *DropFilter) Validate() error {
+	switch f.Kind {
+	case "", ViewKindEvent, ViewKindFunction:
+	default:
+		return fmt.Errorf("unknown view kind %q, expected %s or %s", f.Kind, ViewKindEvent, ViewKindFunction)
+	}
+
+	if f.OlderThan < 0 {
+		return fmt.Errorf("invalid view age %s", f.OlderThan)
+	}
+
+	if _, err := regexp.Compile(f.namePattern()); err != nil {
+		return fmt.Errorf("invalid view filter: %w", err)
+	}
+
+	return nil
+}
+
+// IsZero is true if the filter matches every view
+func (f *DropFilter) IsZero() bool {
+	return len(f.ContractAddresses) == 0 && f.Namespace == "" && f.Kind == "" && f.NamePattern == "" && f.OlderThan == 0 && !f.OrphanedOnly
+}
+
+// namePattern returns the regular expression view names have to match, views are named
+// <namespace>_<contract address>_<kind>_<event or method name>
+func (f *DropFilter) namePattern() string {
+	namespace := ".+"
+	if f.Namespace != "" {
+		namespace = regexp.QuoteMeta(strings.ToUpper(f.Namespace))
+	}
+
+	contract := "0X[0-9A-F]+"
+	if len(f.ContractAddresses) > 0 {
+		addresses := make([]string, len(f.ContractAddresses))
+		for idx, contractAddress := range f.ContractAddresses {
+			addresses[idx] = regexp.QuoteMeta(strings.ToUpper(strings.TrimSpace(contractAddress)))
+		}
+		contract = "(" + strings.Join(addresses, "|") + ")"
+	}
+
+	kind := "(EVT|FN)"
+	if f.Kind != "" {
+		kind = strings.ToUpper(f.Kind)
+	}
+
+	name := ".+"
+	if f.NamePattern != "" {
+		name = globPattern(strings.ToUpper(f.NamePattern))
+	}
+
+	return fmt.Sprintf("^%s_%s_%s_%s$", namespace, contract, kind, name)
+}
+
+// globPattern turns a pattern with * and ? wildcards into a regular expression
+func globPattern(glob string) string {
+	builder := strings.Builder{}
+	for _, r := range glob {
+		switch r {
+		case '*':
+			builder.WriteString(".*")
+		case '?':
+			builder.WriteString(".")
+		default:
+			builder.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+
+	return builder.String()
+}
+
+// Conditions returns the conditions the filter adds to a query of information_schema.views,
+// each starting with AND, along with their bind values. OrphanedOnly can't be expressed in
+// SQL and is left to the caller.
+func (f *DropFilter) Conditions() (string, []interface{}) {
+	conditions := make([]string, 0, 2)
+	args := make([]interface{}, 0, 2)
+
+	if len(f.ContractAddresses) > 0 || f.Namespace != "" || f.Kind != "" || f.NamePattern != "" {
+		conditions = append(conditions, "AND rlike(table_name, ?)")
+		args = append(args, f.namePattern())
+	}
+
+	if f.OlderThan > 0 {
+		conditions = append(conditions, "AND last_altered < dateadd(second, -?, current_timestamp())")
+		args = append(args, int64(f.OlderThan/time.Second))
+	}
+
+	return strings.Join(conditions, "\n"), args
+}
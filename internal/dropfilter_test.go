@@ -0,0 +1,138 @@
+package internal
+
+import (
+	"reflect"
+	"regexp"
+	"testing"
+	"time"
+)
+
+func TestDropFilterNamePattern(t *testing.T) {
+	tests := []struct {
+		name    string
+		filter  DropFilter
+		matches []string
+		misses  []string
+	}{
+		{
+			name:    "zero filter",
+			filter:  DropFilter{},
+			matches: []string{"NS_0XABC_EVT_TRANSFER", "OTHER_0X12_FN_APPROVE"},
+			misses:  []string{"NS_0XABC_TABLE_TRANSFER", "NS_ABC_EVT_TRANSFER"},
+		},
+		{
+			name:    "namespace and contracts",
+			filter:  DropFilter{Namespace: "ns", ContractAddresses: []string{"0xabc", " 0xdef "}},
+			matches: []string{"NS_0XABC_EVT_TRANSFER", "NS_0XDEF_FN_APPROVE"},
+			misses:  []string{"NS_0X123_EVT_TRANSFER", "NS2_0XABC_EVT_TRANSFER", "NS_0XABCD_EVT_TRANSFER"},
+		},
+		{
+			name:    "kind",
+			filter:  DropFilter{Kind: ViewKindFunction},
+			matches: []string{"NS_0XABC_FN_TRANSFER"},
+			misses:  []string{"NS_0XABC_EVT_TRANSFER"},
+		},
+		{
+			name:    "name glob",
+			filter:  DropFilter{NamePattern: "trans*r?"},
+			matches: []string{"NS_0XABC_EVT_TRANSFERS", "NS_0XABC_FN_TRANSFERX"},
+			misses:  []string{"NS_0XABC_EVT_TRANSFER", "NS_0XABC_EVT_APPROVAL"},
+		},
+		{
+			name:    "regular expression characters are literal",
+			filter:  DropFilter{Namespace: "n.s", NamePattern: "a+b"},
+			matches: []string{"N.S_0XABC_EVT_A+B"},
+			misses:  []string{"NXS_0XABC_EVT_A+B", "N.S_0XABC_EVT_AAB"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if err := test.filter.Validate(); err != nil {
+				t.Fatal(err)
+			}
+
+			pattern := regexp.MustCompile(test.filter.namePattern())
+			for _, name := range test.matches {
+				if !pattern.MatchString(name) {
+					t.Errorf("%s doesn't match %s", pattern, name)
+				}
+			}
+			for _, name := range test.misses {
+				if pattern.MatchString(name) {
+					t.Errorf("%s matches %s", pattern, name)
+				}
+			}
+		})
+	}
+}
+
+func TestDropFilterConditions(t *testing.T) {
+	tests := []struct {
+		name           string
+		filter         DropFilter
+		wantConditions string
+		wantArgs       []interface{}
+	}{
+		{
+			name:     "zero filter",
+			filter:   DropFilter{},
+			wantArgs: []interface{}{},
+		},
+		{
+			name:     "orphaned only",
+			filter:   DropFilter{OrphanedOnly: true},
+			wantArgs: []interface{}{},
+		},
+		{
+			name:           "kind",
+			filter:         DropFilter{Kind: ViewKindEvent},
+			wantConditions: "AND rlike(table_name, ?)",
+			wantArgs:       []interface{}{"^.+_0X[0-9A-F]+_EVT_.+$"},
+		},
+		{
+			name:           "older than",
+			filter:         DropFilter{OlderThan: 36 * time.Hour},
+			wantConditions: "AND last_altered < dateadd(second, -?, current_timestamp())",
+			wantArgs:       []interface{}{int64(129600)},
+		},
+		{
+			name:           "namespace and older than",
+			filter:         DropFilter{Namespace: "ns", OlderThan: time.Minute},
+			wantConditions: "AND rlike(table_name, ?)\nAND last_altered < dateadd(second, -?, current_timestamp())",
+			wantArgs:       []interface{}{"^NS_0X[0-9A-F]+_(EVT|FN)_.+$", int64(60)},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			conditions, args := test.filter.Conditions()
+			if conditions != test.wantConditions {
+				t.Errorf("got conditions %q, want %q", conditions, test.wantConditions)
+			}
+			if !reflect.DeepEqual(args, test.wantArgs) {
+				t.Errorf("got args %v, want %v", args, test.wantArgs)
+			}
+		})
+	}
+}
+
+func TestDropFilterValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		filter  DropFilter
+		wantErr bool
+	}{
+		{name: "zero filter", filter: DropFilter{}},
+		{name: "unknown kind", filter: DropFilter{Kind: "table"}, wantErr: true},
+		{name: "negative age", filter: DropFilter{OlderThan: -time.Hour}, wantErr: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if err := test.filter.Validate(); (err != nil) != test.wantErr {
+				t.Errorf("got error %v, want an error: %v", err, test.wantErr)
+			}
+		})
+	}
+}
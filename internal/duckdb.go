@@ -0,0 +1,44 @@
+package internal
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"regexp"
+)
+
+var (
+	// viewCommentRegex matches the COMMENT clause of a generated view, which DuckDB doesn't
+	// support and view definitions are hashed without
+	viewCommentRegex = regexp.MustCompile(`(?m)^[ \t]*COMMENT = '(?:[^']|'')*'[ \t]*\r?\n`)
+	// variantPathRegex matches the val:name paths into the variant returned by the decoder UDF
+	variantPathRegex = regexp.MustCompile(`\bval:([A-Za-z_][A-Za-z0-9_]*)`)
+)
+
+// DuckDBStatement translates a generated snowflake statement to DuckDB. The COMMENT of the
+// view is dropped and the inputs are read from the map returned by the local decoder macro
+// instead of a variant.
+func DuckDBStatement(statement string) string {
+	statement = viewCommentRegex.ReplaceAllString(statement, "")
+
+	return variantPathRegex.ReplaceAllString(statement, "element_at(val, '$1')[1]")
+}
+
+// ExecuteDuckDBMessage executes the statements of a message on an embedded DuckDB database.
+// DuckDB has no asynchronous multi-statement queries, so every view is created separately as
+// in the snowflake fallback.
+func ExecuteDuckDBMessage(ctx context.Context, db *sql.DB, message *QueueMessage) (*ExecutionResult, error) {
+	entries := message.Entries()
+	views := make([]ViewEntry, len(entries))
+	for idx, entry := range entries {
+		entry.DDL = DuckDBStatement(entry.DDL)
+		views[idx] = entry
+	}
+
+	result := &ExecutionResult{Fallback: true, Statements: ExecuteStatements(ctx, db, views)}
+	if len(result.Failed()) == len(result.Statements) {
+		return result, fmt.Errorf("all %d views of contract address %s failed: %w", len(result.Statements), message.ContractAddress, result.Statements[0].Error)
+	}
+
+	return result, nil
+}
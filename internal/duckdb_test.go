@@ -0,0 +1,40 @@
+package internal
+
+import "testing"
+
+func TestDuckDBStatement(t *testing.T) {
+	tests := []struct {
+		name      string
+		statement string
+		want      string
+	}{
+		{
+			name:      "comment",
+			statement: "CREATE OR REPLACE VIEW s.v\n    COMMENT = 'Transfer(address,address,uint256)'\n    AS SELECT 1;",
+			want:      "CREATE OR REPLACE VIEW s.v\n    AS SELECT 1;",
+		},
+		{
+			name:      "comment with escaped quotes",
+			statement: "CREATE OR REPLACE VIEW s.v\n    COMMENT = 'deprecated: it''s gone'\r\n    AS SELECT 1;",
+			want:      "CREATE OR REPLACE VIEW s.v\n    AS SELECT 1;",
+		},
+		{
+			name:      "variant paths",
+			statement: "SELECT val:from as inp_from, val:_value as inp__value FROM q;",
+			want:      "SELECT element_at(val, 'from')[1] as inp_from, element_at(val, '_value')[1] as inp__value FROM q;",
+		},
+		{
+			name:      "other columns",
+			statement: "SELECT interval:x, 'val' FROM q;",
+			want:      "SELECT interval:x, 'val' FROM q;",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := DuckDBStatement(test.statement); got != test.want {
+				t.Errorf("got %q, want %q", got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,150 @@
+package internal
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+)
+
+const (
+	// EnvelopeVersion is the schema version of the envelope messages are serialized in.
+	// Messages without an envelope are version 0 and are still accepted, as are version 1
+	// messages which carry the statements as a single SQL string instead of view entries.
+	EnvelopeVersion = 2
+
+	// EncodingJSON is a payload holding the message as plain JSON
+	EncodingJSON = "json"
+	// EncodingGzipBase64 is a payload holding the gzip compressed message JSON, base64 encoded
+	EncodingGzipBase64 = "gzip+base64"
+)
+
+// Envelope wraps a serialized QueueMessage. The header fields are kept uncompressed so that
+// a message can be identified without decoding the payload.
+type Envelope struct {
+	Version         int             `json:"version"`
+	Encoding        string          `json:"encoding"`
+	ProducerVersion string          `json:"producer_version,omitempty"`
+	RunID           string          `json:"run_id,omitempty"`
+	Chain           string          `json:"chain,omitempty"`
+	Namespace       string          `json:"namespace,omitempty"`
+	Payload         json.RawMessage `json:"payload"`
+}
+
+// NewEnvelope encodes the message into an envelope of the current version
+func NewEnvelope(message *QueueMessage, encoding string) (*Envelope, error) {
+	data, err := json.Marshal(message)
+	if err != nil {
+		return nil, fmt.Errorf("error JSON serializing message: %w", err)
+	}
+
+	payload, err := encodePayload(data, encoding)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Envelope{
+		Version:         EnvelopeVersion,
+		Encoding:        encoding,
+		ProducerVersion: Version,
+		RunID:           message.RunID,
+		Chain:           message.Chain,
+		Namespace:       message.Namespace,
+		Payload:         payload,
+	}, nil
+}
+
+// Message validates the envelope and decodes the message it carries
+func (e *Envelope) Message() (*QueueMessage, error) {
+	if e.Version > EnvelopeVersion {
+		return nil, fmt.Errorf("message envelope version %d is newer than the supported version %d, upgrade the consumer (producer version %s)", e.Version, EnvelopeVersion, e.ProducerVersion)
+	}
+
+	if e.Version < 1 {
+		return nil, fmt.Errorf("invalid message envelope version %d", e.Version)
+	}
+
+	if len(e.Payload) == 0 {
+		return nil, fmt.Errorf("message envelope has no payload")
+	}
+
+	data, err := decodePayload(e.Payload, e.Encoding)
+	if err != nil {
+		return nil, err
+	}
+
+	message := QueueMessage{}
+	if err := json.Unmarshal(data, &message); err != nil {
+		return nil, fmt.Errorf("error deserializing message payload: %w", err)
+	}
+
+	// The header is authoritative for the fields it carries
+	if e.RunID != "" {
+		message.RunID = e.RunID
+	}
+	if e.Chain != "" {
+		message.Chain = e.Chain
+	}
+	if e.Namespace != "" {
+		message.Namespace = e.Namespace
+	}
+
+	return &message, nil
+}
+
+func encodePayload(data []byte, encoding string) (json.RawMessage, error) {
+	switch encoding {
+	case EncodingJSON:
+		return data, nil
+	case EncodingGzipBase64:
+		buffer := bytes.Buffer{}
+		writer, err := gzip.NewWriterLevel(&buffer, gzip.BestCompression)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := writer.Write(data); err != nil {
+			return nil, fmt.Errorf("error compressing message: %w", err)
+		}
+		if err := writer.Close(); err != nil {
+			return nil, fmt.Errorf("error compressing message: %w", err)
+		}
+
+		return json.Marshal(base64.StdEncoding.EncodeToString(buffer.Bytes()))
+	default:
+		return nil, fmt.Errorf("unsupported message encoding %q", encoding)
+	}
+}
+
+func decodePayload(payload json.RawMessage, encoding string) ([]byte, error) {
+	switch encoding {
+	case EncodingJSON:
+		return payload, nil
+	case EncodingGzipBase64:
+		var encoded string
+		if err := json.Unmarshal(payload, &encoded); err != nil {
+			return nil, fmt.Errorf("message payload with %s encoding is not a string: %w", encoding, err)
+		}
+
+		compressed, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("error decoding message payload: %w", err)
+		}
+
+		reader, err := gzip.NewReader(bytes.NewReader(compressed))
+		if err != nil {
+			return nil, fmt.Errorf("error decompressing message payload: %w", err)
+		}
+		defer reader.Close()
+
+		data, err := ioutil.ReadAll(reader)
+		if err != nil {
+			return nil, fmt.Errorf("error decompressing message payload: %w", err)
+		}
+
+		return data, nil
+	default:
+		return nil, fmt.Errorf("unsupported message encoding %q", encoding)
+	}
+}
@@ -0,0 +1,121 @@
+package internal
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func testMessage() *QueueMessage {
+	message := NewMessage("run", "0xabc", []ViewEntry{
+		{ViewName: "ethereum_contracts.ns_0xabc_evt_transfer", Kind: ViewKindEvent, Signature: "Transfer(address,address,uint256)", Hash: "0xddf2", DDL: "CREATE OR REPLACE VIEW ethereum_contracts.ns_0xabc_evt_transfer AS SELECT 1;"},
+		{ViewName: "ethereum_contracts.ns_0xabc_fn_transfer", Kind: ViewKindFunction, Signature: "transfer(address,uint256)", Hash: "0xa905", DDL: "CREATE OR REPLACE VIEW ethereum_contracts.ns_0xabc_fn_transfer AS SELECT 2;"},
+	})
+	message.Chain = "ethereum"
+	message.Namespace = "ns"
+
+	return message
+}
+
+func TestEnvelopeRoundTrip(t *testing.T) {
+	for _, encoding := range []string{EncodingJSON, EncodingGzipBase64} {
+		t.Run(encoding, func(t *testing.T) {
+			message := testMessage()
+			envelope, err := NewEnvelope(message, encoding)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			body, err := json.Marshal(envelope)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			decoded, err := DeserializeMessage(string(body))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !reflect.DeepEqual(decoded, message) {
+				t.Errorf("got %+v, want %+v", decoded, message)
+			}
+		})
+	}
+}
+
+func TestDeserializeMessage(t *testing.T) {
+	sql := "CREATE OR REPLACE VIEW ethereum_contracts.ns_0xabc_evt_transfer AS SELECT 1;\nCREATE OR REPLACE VIEW ethereum_contracts.ns_0xabc_fn_transfer AS SELECT 2;\n"
+	v1Payload, _ := json.Marshal(QueueMessage{ContractAddress: "0xabc", SQLStatements: sql, NumberOfStatements: 2})
+
+	tests := []struct {
+		name      string
+		body      string
+		wantViews []string
+		wantRunID string
+		wantErr   string
+	}{
+		{
+			name:      "version 0 without envelope",
+			body:      `{"contract_address":"0xabc","sql_statements":` + quote(sql) + `,"number_of_statements":2}`,
+			wantViews: []string{"ethereum_contracts.ns_0xabc_evt_transfer", "ethereum_contracts.ns_0xabc_fn_transfer"},
+		},
+		{
+			name:      "version 1 with SQL statements",
+			body:      `{"version":1,"encoding":"json","run_id":"run","payload":` + string(v1Payload) + `}`,
+			wantViews: []string{"ethereum_contracts.ns_0xabc_evt_transfer", "ethereum_contracts.ns_0xabc_fn_transfer"},
+			wantRunID: "run",
+		},
+		{
+			name:    "newer version",
+			body:    `{"version":99,"encoding":"json","payload":{}}`,
+			wantErr: "newer than the supported version",
+		},
+		{
+			name:    "invalid version",
+			body:    `{"version":0,"encoding":"json","payload":{}}`,
+			wantErr: "invalid message envelope version",
+		},
+		{
+			name:    "unknown encoding",
+			body:    `{"version":2,"encoding":"zstd","payload":"abc"}`,
+			wantErr: "unsupported message encoding",
+		},
+		{
+			name:    "missing contract address",
+			body:    `{"sql_statements":"SELECT 1;","number_of_statements":1}`,
+			wantErr: "missing contract address",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			message, err := DeserializeMessage(test.body)
+			if test.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+					t.Fatalf("got error %v, want %q", err, test.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			views := make([]string, 0)
+			for _, entry := range message.Entries() {
+				views = append(views, entry.ViewName)
+			}
+			if !reflect.DeepEqual(views, test.wantViews) {
+				t.Errorf("got views %v, want %v", views, test.wantViews)
+			}
+			if message.RunID != test.wantRunID {
+				t.Errorf("got run ID %q, want %q", message.RunID, test.wantRunID)
+			}
+		})
+	}
+}
+
+func quote(s string) string {
+	bs, _ := json.Marshal(s)
+	return string(bs)
+}
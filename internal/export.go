@@ -0,0 +1,207 @@
+package internal
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+
+	"github.com/xitongsys/parquet-go-source/local"
+	"github.com/xitongsys/parquet-go/common"
+	"github.com/xitongsys/parquet-go/reader"
+)
+
+// parquetReadBatchSize is the number of rows read from a parquet export at once
+const parquetReadBatchSize = 1000
+
+// ExportRow is a row of an export of the logs, transactions or traces table, or of a view,
+// keyed by lower cased column name. Values are strings as in the CSV export and null values
+// are empty. Lists such as the topics of a log are comma separated in parquet exports, other
+// values of JSON exports are kept as JSON.
+type ExportRow map[string]string
+
+// ReadExport calls fn with every row of the export. The format is taken from the file
+// extension: .csv with a header row, .json or .jsonl with one object per line, or .parquet.
+func ReadExport(path string, fn func(row ExportRow) error) error {
+	var err error
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".csv":
+		err = readCSVExport(path, fn)
+	case ".json", ".jsonl", ".ndjson":
+		err = readJSONExport(path, fn)
+	case ".parquet":
+		err = readParquetExport(path, fn)
+	default:
+		return fmt.Errorf("unknown format of export %s, expected .csv, .jsonl or .parquet", path)
+	}
+
+	if err != nil {
+		return fmt.Errorf("error reading export %s: %w", path, err)
+	}
+
+	return nil
+}
+
+func readCSVExport(path string, fn func(row ExportRow) error) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	r := csv.NewReader(bufio.NewReader(file))
+	header, err := r.Read()
+	if err == io.EOF {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	for {
+		record, err := r.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		row := make(ExportRow, len(header))
+		for idx, column := range header {
+			row[strings.ToLower(column)] = record[idx]
+		}
+
+		if err := fn(row); err != nil {
+			return err
+		}
+	}
+}
+
+func readJSONExport(path string, fn func(row ExportRow) error) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	decoder := json.NewDecoder(bufio.NewReader(file))
+	decoder.UseNumber()
+	for decoder.More() {
+		object := map[string]interface{}{}
+		if err := decoder.Decode(&object); err != nil {
+			return err
+		}
+
+		row := make(ExportRow, len(object))
+		for column, value := range object {
+			row[strings.ToLower(column)] = jsonExportValue(value)
+		}
+
+		if err := fn(row); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func readParquetExport(path string, fn func(row ExportRow) error) error {
+	file, err := local.NewLocalFileReader(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	pr, err := reader.NewParquetReader(file, nil, 1)
+	if err != nil {
+		return err
+	}
+	defer pr.ReadStop()
+
+	root := pr.SchemaHandler.GetRootInName()
+	for remaining := int(pr.GetNumRows()); remaining > 0; remaining -= parquetReadBatchSize {
+		batchSize := parquetReadBatchSize
+		if remaining < batchSize {
+			batchSize = remaining
+		}
+
+		records, err := pr.ReadByNumber(batchSize)
+		if err != nil {
+			return err
+		}
+
+		for _, record := range records {
+			v := reflect.ValueOf(record)
+			row := make(ExportRow, v.NumField())
+			for i := 0; i < v.NumField(); i++ {
+				inName := v.Type().Field(i).Name
+				exPath := pr.SchemaHandler.InPathToExPath[root+common.PAR_GO_PATH_DELIMITER+inName]
+				column := exPath[strings.LastIndex(exPath, common.PAR_GO_PATH_DELIMITER)+1:]
+				row[strings.ToLower(column)] = exportValue(v.Field(i))
+			}
+
+			if err := fn(row); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// jsonExportValue keeps the JSON of values other than strings, so that nested arrays such as
+// the inputs of a view export survive
+func jsonExportValue(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	default:
+		bs, _ := json.Marshal(v)
+		return string(bs)
+	}
+}
+
+// exportValue converts a parquet value to its CSV representation
+func exportValue(v reflect.Value) string {
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return ""
+		}
+		v = v.Elem()
+	}
+
+	switch v.Kind() {
+	case reflect.String:
+		return v.String()
+	case reflect.Slice, reflect.Array:
+		if v.Kind() == reflect.Slice && v.Type().Elem().Kind() == reflect.Uint8 {
+			return string(v.Bytes())
+		}
+		values := make([]string, v.Len())
+		for i := range values {
+			values[i] = exportValue(v.Index(i))
+		}
+		return strings.Join(values, ",")
+	case reflect.Map:
+		var buffer bytes.Buffer
+		_ = json.NewEncoder(&buffer).Encode(v.Interface())
+		return strings.TrimSpace(buffer.String())
+	case reflect.Struct:
+		// Parquet lists are read as a struct holding the list
+		if v.NumField() == 1 {
+			return exportValue(v.Field(0))
+		}
+		return fmt.Sprint(v.Interface())
+	default:
+		return fmt.Sprint(v.Interface())
+	}
+}
@@ -0,0 +1,88 @@
+package internal
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Lane is a named target queue. Lanes are listed from the highest to the lowest priority.
+type Lane struct {
+	Name string
+	// Target is the queue URL, or the directory of the spool backend
+	Target string
+}
+
+// ParseLanes parses lanes of the form name=target,name=target in order of priority
+func ParseLanes(value string) ([]Lane, error) {
+	lanes := make([]Lane, 0)
+	if strings.TrimSpace(value) == "" {
+		return lanes, nil
+	}
+
+	seen := make(map[string]bool)
+	for _, item := range strings.Split(value, ",") {
+		parts := strings.SplitN(strings.TrimSpace(item), "=", 2)
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return nil, fmt.Errorf("invalid lane %q, expected name=target", item)
+		}
+
+		if seen[parts[0]] {
+			return nil, fmt.Errorf("lane %s is defined twice", parts[0])
+		}
+		seen[parts[0]] = true
+
+		lanes = append(lanes, Lane{Name: parts[0], Target: parts[1]})
+	}
+
+	return lanes, nil
+}
+
+// Routes maps contract classes, i.e. ContractNew, to the name of the lane they are sent to
+type Routes map[string]string
+
+// ParseRoutes parses routes of the form class=lane,class=lane and checks that every lane
+// they refer to exists
+func ParseRoutes(value string, lanes []Lane) (Routes, error) {
+	routes := make(Routes)
+	if strings.TrimSpace(value) == "" {
+		return routes, nil
+	}
+
+	for _, item := range strings.Split(value, ",") {
+		parts := strings.SplitN(strings.TrimSpace(item), "=", 2)
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return nil, fmt.Errorf("invalid route %q, expected class=lane", item)
+		}
+
+		switch parts[0] {
+		case ContractNew, ContractChanged, ContractUnchanged:
+		default:
+			return nil, fmt.Errorf("invalid route %q, class has to be one of %s, %s or %s", item, ContractNew, ContractChanged, ContractUnchanged)
+		}
+
+		found := false
+		for _, lane := range lanes {
+			if lane.Name == parts[1] {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("route %q refers to unknown lane %s", item, parts[1])
+		}
+
+		routes[parts[0]] = parts[1]
+	}
+
+	return routes, nil
+}
+
+// Lane returns the lane contracts of the class are sent to. Classes without a route go to
+// the lane with the lowest priority.
+func (r Routes) Lane(class string, lanes []Lane) string {
+	if lane, ok := r[class]; ok {
+		return lane
+	}
+
+	return lanes[len(lanes)-1].Name
+}
@@ -0,0 +1,124 @@
+package internal
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseLanes(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   string
+		want    []Lane
+		wantErr string
+	}{
+		{
+			name:  "empty",
+			value: " ",
+			want:  []Lane{},
+		},
+		{
+			name:  "lanes in order of priority",
+			value: "high=https://sqs/high.fifo, low=spool/low",
+			want:  []Lane{{Name: "high", Target: "https://sqs/high.fifo"}, {Name: "low", Target: "spool/low"}},
+		},
+		{
+			name:  "target containing =",
+			value: "high=https://sqs/high?a=b",
+			want:  []Lane{{Name: "high", Target: "https://sqs/high?a=b"}},
+		},
+		{
+			name:    "missing target",
+			value:   "high=",
+			wantErr: "expected name=target",
+		},
+		{
+			name:    "missing name",
+			value:   "high=a,b",
+			wantErr: "expected name=target",
+		},
+		{
+			name:    "duplicate lane",
+			value:   "high=a,high=b",
+			wantErr: "defined twice",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			lanes, err := ParseLanes(test.value)
+			if test.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+					t.Fatalf("got error %v, want %q", err, test.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !reflect.DeepEqual(lanes, test.want) {
+				t.Errorf("got %+v, want %+v", lanes, test.want)
+			}
+		})
+	}
+}
+
+func TestRoutes(t *testing.T) {
+	lanes := []Lane{{Name: "high", Target: "a"}, {Name: "normal", Target: "b"}, {Name: "low", Target: "c"}}
+
+	tests := []struct {
+		name      string
+		value     string
+		wantLanes map[string]string
+		wantErr   string
+	}{
+		{
+			name:      "no routes",
+			value:     "",
+			wantLanes: map[string]string{ContractNew: "low", ContractChanged: "low", ContractUnchanged: "low"},
+		},
+		{
+			name:      "routed classes",
+			value:     "new=high, changed=normal",
+			wantLanes: map[string]string{ContractNew: "high", ContractChanged: "normal", ContractUnchanged: "low"},
+		},
+		{
+			name:    "unknown class",
+			value:   "stale=high",
+			wantErr: "class has to be one of",
+		},
+		{
+			name:    "unknown lane",
+			value:   "new=urgent",
+			wantErr: "unknown lane urgent",
+		},
+		{
+			name:    "invalid route",
+			value:   "new",
+			wantErr: "expected class=lane",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			routes, err := ParseRoutes(test.value, lanes)
+			if test.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+					t.Fatalf("got error %v, want %q", err, test.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for class, want := range test.wantLanes {
+				if got := routes.Lane(class, lanes); got != want {
+					t.Errorf("class %s goes to lane %s, want %s", class, got, want)
+				}
+			}
+		})
+	}
+}
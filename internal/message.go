@@ -3,16 +3,62 @@ package internal
 import (
 	"encoding/json"
 	"fmt"
+	"sort"
+	"strings"
 )
 
+// Kinds of views generated for a contract
+const (
+	ViewKindEvent    = "evt"
+	ViewKindFunction = "fn"
+)
+
+// ViewEntry is a single view a message creates
+type ViewEntry struct {
+	// ViewName is the fully qualified name of the view
+	ViewName string `json:"view_name"`
+	// Kind is ViewKindEvent or ViewKindFunction
+	Kind string `json:"kind,omitempty"`
+	// Signature is the event or method signature the view decodes
+	Signature string `json:"signature,omitempty"`
+	// Hash is the SigHash of an event or the MethodIdHash of a method
+	Hash string `json:"hash,omitempty"`
+	// DDL is the create view statement
+	DDL string `json:"ddl"`
+}
+
 type QueueMessage struct {
-	ContractAddress    string `json:"contract_address"`
-	SQLStatements      string `json:"sql_statements"`
-	NumberOfStatements int    `json:"number_of_statements"`
+	RunID           string `json:"run_id,omitempty"`
+	Chain           string `json:"chain,omitempty"`
+	Namespace       string `json:"namespace,omitempty"`
+	ContractAddress string `json:"contract_address"`
+	// Views holds one entry per view. Messages of envelope version 1 and earlier carry
+	// the statements concatenated in SQLStatements instead.
+	Views              []ViewEntry `json:"views,omitempty"`
+	SQLStatements      string      `json:"sql_statements,omitempty"`
+	NumberOfStatements int         `json:"number_of_statements"`
+	// PendingQueryID is set on status check messages for a query submitted by an earlier
+	// invocation. These messages carry the views, or the reference to the payload they were
+	// stored in, so that the statements can be run one at a time if the query fails.
+	// ViewNames is kept for status check messages sent by older consumers.
+	PendingQueryID string   `json:"pending_query_id,omitempty"`
+	ViewNames      []string `json:"view_names,omitempty"`
+	// Part and TotalParts are set when the statements of a contract are split over several
+	// messages to stay within the queue message size limit. Parts can run in any order.
+	Part       int `json:"part,omitempty"`
+	TotalParts int `json:"total_parts,omitempty"`
+	// PayloadRef points to the full message in a BlobStore when it is too large for the queue
+	PayloadRef string `json:"payload_ref,omitempty"`
 }
 
+// SerializeMessage encodes the message into a compressed envelope of the current version
 func SerializeMessage(message *QueueMessage) (string, error) {
-	bytes, err := json.Marshal(message)
+	envelope, err := NewEnvelope(message, EncodingGzipBase64)
+	if err != nil {
+		return "", err
+	}
+
+	bytes, err := json.Marshal(envelope)
 	if err != nil {
 		return "", fmt.Errorf("error JSON serializing message: %w", err)
 	}
@@ -20,20 +66,194 @@ func SerializeMessage(message *QueueMessage) (string, error) {
 	return string(bytes), nil
 }
 
+// DeserializeMessage decodes a message from its envelope. Bodies without an envelope are
+// version 0 messages, the plain JSON QueueMessage sent by older producers.
 func DeserializeMessage(body string) (*QueueMessage, error) {
-	message := QueueMessage{}
+	probe := struct {
+		Version *int `json:"version"`
+	}{}
 
-	if err := json.Unmarshal([]byte(body), &message); err != nil {
+	if err := json.Unmarshal([]byte(body), &probe); err != nil {
 		return nil, fmt.Errorf("error deserializing SQS message body: %w", err)
 	}
 
-	return &message, nil
+	var message *QueueMessage
+	if probe.Version == nil {
+		message = &QueueMessage{}
+		if err := json.Unmarshal([]byte(body), message); err != nil {
+			return nil, fmt.Errorf("error deserializing SQS message body: %w", err)
+		}
+	} else {
+		envelope := Envelope{}
+		if err := json.Unmarshal([]byte(body), &envelope); err != nil {
+			return nil, fmt.Errorf("error deserializing SQS message envelope: %w", err)
+		}
+
+		var err error
+		message, err = envelope.Message()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if err := message.Validate(); err != nil {
+		return nil, err
+	}
+
+	return message, nil
 }
 
-func NewMessage(contractAddress string, sql string, numberOfStatements int) *QueueMessage {
+// Validate checks the fields every message has to carry
+func (m *QueueMessage) Validate() error {
+	if m.ContractAddress == "" {
+		return fmt.Errorf("invalid message: missing contract address")
+	}
+
+	if m.NumberOfStatements < 0 {
+		return fmt.Errorf("invalid message for contract address %s: negative number of statements", m.ContractAddress)
+	}
+
+	if m.Part < 0 || m.Part > m.TotalParts {
+		return fmt.Errorf("invalid message for contract address %s: part %d of %d", m.ContractAddress, m.Part, m.TotalParts)
+	}
+
+	if len(m.Views) == 0 {
+		return nil
+	}
+
+	if len(m.Views) != m.NumberOfStatements {
+		return fmt.Errorf("invalid message for contract address %s: declares %d statements but contains %d views", m.ContractAddress, m.NumberOfStatements, len(m.Views))
+	}
+
+	for idx, view := range m.Views {
+		if view.ViewName == "" || view.DDL == "" {
+			return fmt.Errorf("invalid message for contract address %s: view %d has no name or DDL", m.ContractAddress, idx+1)
+		}
+
+		if view.Kind != "" && view.Kind != ViewKindEvent && view.Kind != ViewKindFunction {
+			return fmt.Errorf("invalid message for contract address %s: view %s has unknown kind %q", m.ContractAddress, view.ViewName, view.Kind)
+		}
+	}
+
+	return nil
+}
+
+// Entries returns the views of the message. For older messages they are parsed from
+// SQLStatements and only carry the view name and DDL.
+func (m *QueueMessage) Entries() []ViewEntry {
+	if len(m.Views) > 0 {
+		return m.Views
+	}
+
+	statements := SplitStatements(m.SQLStatements)
+	entries := make([]ViewEntry, len(statements))
+	for idx, statement := range statements {
+		entries[idx] = ViewEntry{
+			ViewName: ViewName(statement),
+			DDL:      statement,
+		}
+	}
+
+	return entries
+}
+
+// SQL returns the statements of all views as a single multi-statement query
+func (m *QueueMessage) SQL() string {
+	if len(m.Views) == 0 {
+		return m.SQLStatements
+	}
+
+	builder := strings.Builder{}
+	for _, view := range m.Views {
+		ddl := strings.TrimSpace(view.DDL)
+		builder.WriteString(ddl)
+		if !strings.HasSuffix(ddl, ";") {
+			builder.WriteString(";")
+		}
+		builder.WriteString("\n")
+	}
+
+	return builder.String()
+}
+
+// WithViews returns a copy of the message that only carries the given views
+func (m *QueueMessage) WithViews(views []ViewEntry) *QueueMessage {
+	message := *m
+	message.Views = views
+	message.SQLStatements = ""
+	message.NumberOfStatements = len(views)
+
+	return &message
+}
+
+// GroupID returns the FIFO message group of the message. All messages of a contract share a
+// group so that they are applied in the order they were sent.
+func (m *QueueMessage) GroupID() string {
+	return MessageGroupID(m.Chain, m.ContractAddress)
+}
+
+// DeduplicationID returns the FIFO deduplication ID of the message, derived from its DDL so
+// that sending the same statements twice is ignored. The views are sorted by name first, as
+// their order follows the iteration order of the ABI maps and differs between runs.
+func (m *QueueMessage) DeduplicationID() string {
+	views := append([]ViewEntry{}, m.Entries()...)
+	sort.SliceStable(views, func(i, j int) bool { return views[i].ViewName < views[j].ViewName })
+
+	return HashDDL(m.WithViews(views).SQL())
+}
+
+// QueryTag returns the snowflake query tag the statements of the message are submitted with.
+// It identifies the message by run, contract and part, so it doesn't change when views of
+// the message are skipped.
+func (m *QueueMessage) QueryTag() string {
+	return fmt.Sprintf("%s:%s:%s:%s:%d/%d", queryTagPrefix, m.Chain, m.RunID, strings.ToLower(m.ContractAddress), m.Part, m.TotalParts)
+}
+
+// MessageGroupID returns the FIFO message group of a contract
+func MessageGroupID(chain string, contractAddress string) string {
+	if chain == "" {
+		return strings.ToLower(contractAddress)
+	}
+
+	return fmt.Sprintf("%s:%s", chain, strings.ToLower(contractAddress))
+}
+
+func NewMessage(runID string, contractAddress string, views []ViewEntry) *QueueMessage {
 	return &QueueMessage{
+		RunID:              runID,
 		ContractAddress:    contractAddress,
-		SQLStatements:      sql,
-		NumberOfStatements: numberOfStatements,
+		Views:              views,
+		NumberOfStatements: len(views),
 	}
 }
+
+// NewPendingMessage creates a status check message for a query that is still running when
+// the invocation that submitted it has to return. It carries the views of the message, or
+// payloadRef if the message was stored in a BlobStore, to fall back to if the query fails.
+func NewPendingMessage(message *QueueMessage, queryID string, payloadRef string) *QueueMessage {
+	views := message.Entries()
+	viewNames := make([]string, 0, len(views))
+	for _, view := range views {
+		viewNames = append(viewNames, view.ViewName)
+	}
+
+	pending := &QueueMessage{
+		RunID:              message.RunID,
+		Chain:              message.Chain,
+		Namespace:          message.Namespace,
+		ContractAddress:    message.ContractAddress,
+		NumberOfStatements: message.NumberOfStatements,
+		Part:               message.Part,
+		TotalParts:         message.TotalParts,
+		PendingQueryID:     queryID,
+		ViewNames:          viewNames,
+	}
+
+	if payloadRef != "" {
+		pending.PayloadRef = payloadRef
+	} else {
+		pending.Views = views
+	}
+
+	return pending
+}
@@ -0,0 +1,68 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+)
+
+// MaxMessageBytes is the largest message body SQS accepts
+const MaxMessageBytes = 256 * 1024
+
+// Strategies for messages larger than MaxMessageBytes
+const (
+	// OversizeSplit splits the statements of a contract across several messages
+	OversizeSplit = "split"
+	// OversizeStore uploads the message to a BlobStore and sends a reference to it instead
+	OversizeStore = "store"
+)
+
+// NewPointerMessage stores body in the blob store under key and returns a small message
+// that references it, in the style of the SQS extended client
+func NewPointerMessage(ctx context.Context, store BlobStore, key string, message *QueueMessage, body string) (*QueueMessage, error) {
+	ref, err := store.Put(ctx, key, []byte(body))
+	if err != nil {
+		return nil, err
+	}
+
+	return &QueueMessage{
+		RunID:              message.RunID,
+		Chain:              message.Chain,
+		Namespace:          message.Namespace,
+		ContractAddress:    message.ContractAddress,
+		NumberOfStatements: message.NumberOfStatements,
+		Part:               message.Part,
+		TotalParts:         message.TotalParts,
+		PayloadRef:         ref,
+	}, nil
+}
+
+// ResolveMessage returns the full message if message only references its payload, and
+// message itself otherwise
+func ResolveMessage(ctx context.Context, store BlobReader, message *QueueMessage) (*QueueMessage, error) {
+	if message.PayloadRef == "" {
+		return message, nil
+	}
+
+	if store == nil {
+		return nil, fmt.Errorf("message for contract address %s references payload %s but no payload store is configured", message.ContractAddress, message.PayloadRef)
+	}
+
+	data, err := store.Get(ctx, message.PayloadRef)
+	if err != nil {
+		return nil, err
+	}
+
+	resolved, err := DeserializeMessage(string(data))
+	if err != nil {
+		return nil, fmt.Errorf("error deserializing payload %s: %w", message.PayloadRef, err)
+	}
+
+	if resolved.PayloadRef != "" {
+		return nil, fmt.Errorf("payload %s references another payload", message.PayloadRef)
+	}
+
+	// A status check message refers to the payload of the message whose query it checks
+	resolved.PendingQueryID = message.PendingQueryID
+
+	return resolved, nil
+}
@@ -0,0 +1,116 @@
+package internal
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/xitongsys/parquet-go/parquet"
+	"github.com/xitongsys/parquet-go/writer"
+)
+
+// Column types of the parquet tables written by ParquetTableWriter
+const (
+	ParquetString  = "string"
+	ParquetInt64   = "int64"
+	ParquetBoolean = "boolean"
+)
+
+// parquetRowGroupSize keeps the memory of a writer low, as a decode run has a writer open
+// for every view
+const parquetRowGroupSize = 16 * 1024 * 1024
+
+var parquetTypeTags = map[string]string{
+	ParquetString:  "type=BYTE_ARRAY, convertedtype=UTF8",
+	ParquetInt64:   "type=INT64",
+	ParquetBoolean: "type=BOOLEAN",
+}
+
+// ParquetColumn is a nullable column of a parquet table
+type ParquetColumn struct {
+	Name string
+	Type string
+}
+
+type parquetSchemaItem struct {
+	Tag    string               `json:"Tag"`
+	Fields []*parquetSchemaItem `json:"Fields,omitempty"`
+}
+
+// ParquetTableWriter writes rows of values in the order of its columns to a parquet file
+type ParquetTableWriter struct {
+	file    *os.File
+	writer  *writer.JSONWriter
+	columns []ParquetColumn
+	rows    int
+}
+
+func NewParquetTableWriter(path string, columns []ParquetColumn) (*ParquetTableWriter, error) {
+	schema := parquetSchemaItem{Tag: "name=parquet_go_root, repetitiontype=REQUIRED"}
+	for _, column := range columns {
+		tag, ok := parquetTypeTags[column.Type]
+		if !ok {
+			return nil, fmt.Errorf("unknown type %s of parquet column %s", column.Type, column.Name)
+		}
+		schema.Fields = append(schema.Fields, &parquetSchemaItem{Tag: fmt.Sprintf("name=%s, %s, repetitiontype=OPTIONAL", column.Name, tag)})
+	}
+
+	bs, err := json.Marshal(schema)
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, fmt.Errorf("error creating parquet file: %w", err)
+	}
+
+	w, err := writer.NewJSONWriterFromWriter(string(bs), file, 1)
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("error creating parquet file %s: %w", path, err)
+	}
+	w.RowGroupSize = parquetRowGroupSize
+	w.CompressionType = parquet.CompressionCodec_SNAPPY
+
+	return &ParquetTableWriter{file: file, writer: w, columns: columns}, nil
+}
+
+// Write appends a row. Values must match the column types, nil values are written as null.
+func (w *ParquetTableWriter) Write(values []interface{}) error {
+	if len(values) != len(w.columns) {
+		return fmt.Errorf("row of %s has %d values for %d columns", w.file.Name(), len(values), len(w.columns))
+	}
+
+	row := make(map[string]interface{}, len(values))
+	for idx, column := range w.columns {
+		row[column.Name] = values[idx]
+	}
+
+	bs, err := json.Marshal(row)
+	if err != nil {
+		return err
+	}
+
+	if err := w.writer.Write(string(bs)); err != nil {
+		return fmt.Errorf("error writing row to %s: %w", w.file.Name(), err)
+	}
+	w.rows += 1
+
+	return nil
+}
+
+// Rows returns the number of rows written so far
+func (w *ParquetTableWriter) Rows() int {
+	return w.rows
+}
+
+// Close writes the footer and closes the file
+func (w *ParquetTableWriter) Close() error {
+	if err := w.writer.WriteStop(); err != nil {
+		w.file.Close()
+		return fmt.Errorf("error writing %s: %w", w.file.Name(), err)
+	}
+
+	return w.file.Close()
+}
@@ -0,0 +1,90 @@
+package internal
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"text/tabwriter"
+)
+
+// PlanEntry is the action planned for a single view
+type PlanEntry struct {
+	ContractAddress string `json:"contract_address"`
+	// Action is one of the ViewAction constants
+	Action string `json:"action"`
+	// State is the definition hash of the existing view the action was planned against,
+	// empty if the view did not exist
+	State string `json:"state,omitempty"`
+	ViewEntry
+}
+
+// Plan collects the actions needed to bring the existing views in line with the generated
+// ones. Entries can be added from several goroutines.
+type Plan struct {
+	mu      sync.Mutex
+	Entries []PlanEntry `json:"entries"`
+}
+
+func (p *Plan) Add(entries ...PlanEntry) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.Entries = append(p.Entries, entries...)
+}
+
+// ContractAddresses returns the distinct contracts of the plan
+func (p *Plan) ContractAddresses() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	seen := make(map[string]bool)
+	contracts := make([]string, 0)
+	for _, entry := range p.Entries {
+		if !seen[entry.ContractAddress] {
+			seen[entry.ContractAddress] = true
+			contracts = append(contracts, entry.ContractAddress)
+		}
+	}
+
+	return contracts
+}
+
+// Counts returns the number of views per action
+func (p *Plan) Counts() map[string]int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	counts := make(map[string]int)
+	for _, entry := range p.Entries {
+		counts[entry.Action] += 1
+	}
+
+	return counts
+}
+
+// WriteReport writes the views that need an action as a table, followed by the number of
+// views per action. Unchanged views are only counted.
+func (p *Plan) WriteReport(out io.Writer) {
+	p.mu.Lock()
+	entries := make([]PlanEntry, 0, len(p.Entries))
+	for _, entry := range p.Entries {
+		if entry.Action != ViewActionUnchanged {
+			entries = append(entries, entry)
+		}
+	}
+	p.mu.Unlock()
+
+	sort.SliceStable(entries, func(i, j int) bool { return entries[i].ViewName < entries[j].ViewName })
+
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ACTION\tCONTRACT_ADDRESS\tKIND\tVIEW_NAME\tSIGNATURE")
+	for _, entry := range entries {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", entry.Action, entry.ContractAddress, entry.Kind, entry.ViewName, entry.Signature)
+	}
+	w.Flush()
+
+	counts := p.Counts()
+	fmt.Fprintf(out, "\nPlan: %d to create, %d to replace, %d unchanged, %d orphaned\n",
+		counts[ViewActionCreate], counts[ViewActionReplace], counts[ViewActionUnchanged], counts[ViewActionOrphaned])
+}
@@ -0,0 +1,134 @@
+package internal
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// PlanFileVersion is the schema version of saved plan files. Version 2 added the actions
+	// and DDL to the state hash.
+	PlanFileVersion = 2
+
+	// ViewActionDrop removes an orphaned view when a saved plan is applied
+	ViewActionDrop = "drop"
+)
+
+// PlanFile is a saved plan that can be reviewed and applied later. It only lists the views
+// that need an action, and the state of the existing views it was computed against.
+type PlanFile struct {
+	Version         int       `json:"version"`
+	CreatedAt       time.Time `json:"created_at"`
+	ProducerVersion string    `json:"producer_version"`
+	Chain           string    `json:"chain,omitempty"`
+	Namespace       string    `json:"namespace"`
+	// StateHash covers the action, DDL and State of every entry, it changes when the plan
+	// was edited or any of the views the plan touches was changed
+	StateHash string      `json:"state_hash"`
+	Entries   []PlanEntry `json:"entries"`
+}
+
+// NewPlanFile creates a plan file from the entries of plan that need an action. Orphaned
+// views are planned to be dropped.
+func NewPlanFile(plan *Plan, chain string, namespace string) *PlanFile {
+	plan.mu.Lock()
+	entries := make([]PlanEntry, 0, len(plan.Entries))
+	for _, entry := range plan.Entries {
+		switch entry.Action {
+		case ViewActionUnchanged:
+			continue
+		case ViewActionOrphaned:
+			entry.Action = ViewActionDrop
+			entry.DDL = DropViewDDL(entry.ViewName)
+		}
+		entries = append(entries, entry)
+	}
+	plan.mu.Unlock()
+
+	sort.SliceStable(entries, func(i, j int) bool { return entries[i].ViewName < entries[j].ViewName })
+
+	return &PlanFile{
+		Version:         PlanFileVersion,
+		CreatedAt:       time.Now().UTC(),
+		ProducerVersion: Version,
+		Chain:           chain,
+		Namespace:       namespace,
+		StateHash:       stateHash(entries),
+		Entries:         entries,
+	}
+}
+
+// stateHash hashes the view names, actions, DDL and states of the entries independent of
+// their order
+func stateHash(entries []PlanEntry) string {
+	lines := make([]string, len(entries))
+	for idx, entry := range entries {
+		lines[idx] = fmt.Sprintf("%s=%s %s %s", strings.ToLower(entry.ViewName), entry.Action, HashDDL(entry.DDL), entry.State)
+	}
+	sort.Strings(lines)
+
+	sum := sha256.Sum256([]byte(strings.Join(lines, "\n")))
+	return hex.EncodeToString(sum[:])
+}
+
+// WritePlanFile saves the plan as indented JSON so that it can be reviewed
+func WritePlanFile(path string, file *PlanFile) error {
+	data, err := json.MarshalIndent(file, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error serializing plan: %w", err)
+	}
+
+	if err := ioutil.WriteFile(path, data, 0644); err != nil {
+		return fmt.Errorf("error writing plan file %s: %w", path, err)
+	}
+
+	return nil
+}
+
+// ReadPlanFile loads a saved plan and checks that the actions, DDL and states of its entries
+// were not edited
+func ReadPlanFile(path string) (*PlanFile, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading plan file %s: %w", path, err)
+	}
+
+	file := PlanFile{}
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("error deserializing plan file %s: %w", path, err)
+	}
+
+	if file.Version != PlanFileVersion {
+		return nil, fmt.Errorf("plan file %s has version %d, expected %d", path, file.Version, PlanFileVersion)
+	}
+
+	if stateHash(file.Entries) != file.StateHash {
+		return nil, fmt.Errorf("the entries of plan file %s don't match its state hash, it was edited after it was created", path)
+	}
+
+	return &file, nil
+}
+
+// Drift returns the entries whose view changed since the plan was created
+func (f *PlanFile) Drift(ctx context.Context, catalog *ViewCatalog) ([]PlanEntry, error) {
+	drifted := make([]PlanEntry, 0)
+	for _, entry := range f.Entries {
+		state, err := catalog.State(ctx, entry.ViewName)
+		if err != nil {
+			return nil, err
+		}
+
+		if state != entry.State {
+			drifted = append(drifted, entry)
+		}
+	}
+
+	return drifted, nil
+}
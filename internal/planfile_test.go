@@ -0,0 +1,157 @@
+package internal
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func testPlanEntries() []PlanEntry {
+	return []PlanEntry{
+		{
+			Action:    ViewActionCreate,
+			ViewEntry: ViewEntry{ViewName: "ethereum_contracts.ns_0xabc_evt_transfer", DDL: "CREATE OR REPLACE VIEW ethereum_contracts.ns_0xabc_evt_transfer AS SELECT 1;"},
+		},
+		{
+			Action:    ViewActionReplace,
+			State:     "hash-approval",
+			ViewEntry: ViewEntry{ViewName: "ethereum_contracts.ns_0xabc_evt_approval", DDL: "CREATE OR REPLACE VIEW ethereum_contracts.ns_0xabc_evt_approval AS SELECT 2;"},
+		},
+		{
+			Action:    ViewActionDrop,
+			State:     "hash-old",
+			ViewEntry: ViewEntry{ViewName: "ethereum_contracts.ns_0xabc_evt_old", DDL: DropViewDDL("ethereum_contracts.ns_0xabc_evt_old")},
+		},
+	}
+}
+
+func TestStateHash(t *testing.T) {
+	base := stateHash(testPlanEntries())
+
+	tests := []struct {
+		name     string
+		edit     func(entries []PlanEntry) []PlanEntry
+		wantSame bool
+	}{
+		{
+			name: "reordered entries",
+			edit: func(entries []PlanEntry) []PlanEntry {
+				return []PlanEntry{entries[2], entries[0], entries[1]}
+			},
+			wantSame: true,
+		},
+		{
+			name: "view name case",
+			edit: func(entries []PlanEntry) []PlanEntry {
+				entries[0].ViewName = strings.ToUpper(entries[0].ViewName)
+				return entries
+			},
+			wantSame: true,
+		},
+		{
+			name: "changed state",
+			edit: func(entries []PlanEntry) []PlanEntry {
+				entries[1].State = "hash-changed"
+				return entries
+			},
+		},
+		{
+			name: "changed action",
+			edit: func(entries []PlanEntry) []PlanEntry {
+				entries[0].Action = ViewActionDrop
+				return entries
+			},
+		},
+		{
+			name: "changed DDL",
+			edit: func(entries []PlanEntry) []PlanEntry {
+				entries[1].DDL = "CREATE OR REPLACE VIEW ethereum_contracts.ns_0xabc_evt_approval AS SELECT 3;"
+				return entries
+			},
+		},
+		{
+			name: "removed entry",
+			edit: func(entries []PlanEntry) []PlanEntry {
+				return entries[1:]
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := stateHash(test.edit(testPlanEntries()))
+			if (got == base) != test.wantSame {
+				t.Errorf("got hash %s for base hash %s, want the same hash: %v", got, base, test.wantSame)
+			}
+		})
+	}
+}
+
+func TestReadPlanFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "plan.json")
+	entries := testPlanEntries()
+	file := &PlanFile{Version: PlanFileVersion, Namespace: "ns", StateHash: stateHash(entries), Entries: entries}
+	if err := WritePlanFile(path, file); err != nil {
+		t.Fatal(err)
+	}
+
+	read, err := ReadPlanFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(read.Entries) != len(entries) || read.StateHash != file.StateHash {
+		t.Fatalf("got %+v, want %+v", read, file)
+	}
+
+	file.Entries[0].DDL = "DROP TABLE ethereum.logs;"
+	if err := WritePlanFile(path, file); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ReadPlanFile(path); err == nil || !strings.Contains(err.Error(), "state hash") {
+		t.Fatalf("got error %v for an edited plan, want a state hash mismatch", err)
+	}
+}
+
+func TestPlanFileDrift(t *testing.T) {
+	catalog := NewViewCatalog(nil, "ns")
+	// The catalog only queries a schema the first time one of its views is looked up
+	catalog.schemas["ETHEREUM_CONTRACTS"] = map[string]catalogView{
+		"NS_0XABC_EVT_APPROVAL": {hash: "hash-approval"},
+		"NS_0XABC_EVT_OLD":      {hash: "hash-changed"},
+		"NS_0XABC_EVT_TRANSFER": {hash: "hash-created-meanwhile"},
+	}
+
+	tests := []struct {
+		name  string
+		entry PlanEntry
+		drift bool
+	}{
+		{name: "unchanged view", entry: testPlanEntries()[1]},
+		{name: "changed view", entry: testPlanEntries()[2], drift: true},
+		{name: "view created after the plan", entry: testPlanEntries()[0], drift: true},
+		{
+			name: "view dropped after the plan",
+			entry: PlanEntry{
+				Action:    ViewActionReplace,
+				State:     "hash-missing",
+				ViewEntry: ViewEntry{ViewName: "ethereum_contracts.ns_0xabc_evt_missing"},
+			},
+			drift: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			file := &PlanFile{Entries: []PlanEntry{test.entry}}
+			drifted, err := file.Drift(context.Background(), catalog)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if (len(drifted) > 0) != test.drift {
+				t.Errorf("got drifted entries %+v, want drift: %v", drifted, test.drift)
+			}
+		})
+	}
+}
@@ -0,0 +1,113 @@
+package internal
+
+import (
+	"context"
+	"sync"
+)
+
+const publishBatchSize = 10
+
+// BatchPublisher is implemented by backends that can send several messages in one request
+type BatchPublisher interface {
+	// PublishBatch sends the messages and returns one error per message, nil if it was sent
+	PublishBatch(ctx context.Context, messages []Message) []error
+}
+
+// PublishBatch sends the messages with a single request if the publisher supports it and
+// one at a time otherwise
+func PublishBatch(ctx context.Context, publisher Publisher, messages []Message) []error {
+	if batchPublisher, ok := publisher.(BatchPublisher); ok {
+		return batchPublisher.PublishBatch(ctx, messages)
+	}
+
+	errs := make([]error, len(messages))
+	for idx, message := range messages {
+		errs[idx] = publisher.Publish(ctx, message)
+	}
+
+	return errs
+}
+
+type pooledMessage struct {
+	message Message
+	done    func(error)
+}
+
+// PublisherPool groups messages into batches and publishes them with a bounded number of
+// requests in flight. Publish blocks while the limit is reached, which keeps the producer
+// from running ahead of the queue.
+type PublisherPool struct {
+	ctx       context.Context
+	publisher Publisher
+	sem       chan struct{}
+	wg        sync.WaitGroup
+	mu        sync.Mutex
+	pending   []pooledMessage
+}
+
+func NewPublisherPool(ctx context.Context, publisher Publisher, maxInFlight int) *PublisherPool {
+	if maxInFlight < 1 {
+		maxInFlight = 1
+	}
+
+	return &PublisherPool{
+		ctx:       ctx,
+		publisher: publisher,
+		sem:       make(chan struct{}, maxInFlight),
+		pending:   make([]pooledMessage, 0, publishBatchSize),
+	}
+}
+
+// Publish adds the message to the current batch. done is called with the outcome once the
+// batch was sent.
+func (p *PublisherPool) Publish(message Message, done func(error)) {
+	p.mu.Lock()
+	p.pending = append(p.pending, pooledMessage{message: message, done: done})
+	if len(p.pending) < publishBatchSize {
+		p.mu.Unlock()
+		return
+	}
+	batch := p.pending
+	p.pending = make([]pooledMessage, 0, publishBatchSize)
+	p.mu.Unlock()
+
+	p.dispatch(batch)
+}
+
+// Close sends the last partial batch and waits for all requests to finish
+func (p *PublisherPool) Close() {
+	p.mu.Lock()
+	batch := p.pending
+	p.pending = nil
+	p.mu.Unlock()
+
+	if len(batch) > 0 {
+		p.dispatch(batch)
+	}
+
+	p.wg.Wait()
+}
+
+func (p *PublisherPool) dispatch(batch []pooledMessage) {
+	p.sem <- struct{}{}
+	p.wg.Add(1)
+
+	go func() {
+		defer func() {
+			<-p.sem
+			p.wg.Done()
+		}()
+
+		messages := make([]Message, len(batch))
+		for idx, m := range batch {
+			messages[idx] = m.message
+		}
+
+		errs := PublishBatch(p.ctx, p.publisher, messages)
+		for idx, m := range batch {
+			if m.done != nil {
+				m.done(errs[idx])
+			}
+		}
+	}()
+}
@@ -0,0 +1,64 @@
+package internal
+
+import (
+	"context"
+	"time"
+)
+
+const (
+	QueueBackendSQS    = "sqs"
+	QueueBackendSpool  = "spool"
+	QueueBackendMemory = "memory"
+)
+
+// Message is a serialized message handed to a Publisher
+type Message struct {
+	// Body is the serialized QueueMessage
+	Body string
+	// Delay postpones the delivery of the message. FIFO queues don't support per message
+	// delays, backends ignore it for them.
+	Delay time.Duration
+	// GroupID orders messages on FIFO queues, messages of the same group are delivered in
+	// the order they were sent
+	GroupID string
+	// DeduplicationID makes FIFO queues drop repeated sends of the same message
+	DeduplicationID string
+}
+
+// Delivery is a message received from a Subscriber. It has to be acknowledged once it was
+// processed, otherwise it is delivered again after its visibility timeout.
+type Delivery struct {
+	// ID is the backend specific message ID
+	ID string
+	// Body is the serialized QueueMessage
+	Body string
+	// Handle identifies this particular delivery of the message to the backend
+	Handle string
+	// ReceiveCount is the number of times the message has been delivered, if known
+	ReceiveCount int
+	// GroupID is the FIFO message group of the message, empty for other queues
+	GroupID string
+	// Lane is the name of the lane a PriorityQueue received the message from
+	Lane string
+}
+
+type Publisher interface {
+	// Publish sends a single message
+	Publish(ctx context.Context, message Message) error
+}
+
+type Subscriber interface {
+	// Receive waits up to wait for at most maxMessages messages. Received messages stay
+	// hidden from other subscribers for the visibility timeout of the backend.
+	Receive(ctx context.Context, maxMessages int, wait time.Duration) ([]Delivery, error)
+	// Ack removes a processed message from the queue
+	Ack(ctx context.Context, delivery Delivery) error
+	// Extend keeps a message hidden from other subscribers for another timeout
+	Extend(ctx context.Context, delivery Delivery, timeout time.Duration) error
+}
+
+// Queue is a backend messages can be both published to and received from
+type Queue interface {
+	Publisher
+	Subscriber
+}
@@ -0,0 +1,130 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+type memoryMessage struct {
+	id           string
+	body         string
+	handle       string
+	receiveCount int
+	visibleAt    time.Time
+}
+
+// MemoryQueue is an in-process Queue, meant for tests and for running the producer and
+// consumer in a single process
+type MemoryQueue struct {
+	mu                sync.Mutex
+	messages          []*memoryMessage
+	visibilityTimeout time.Duration
+	sequence          int
+}
+
+func NewMemoryQueue(visibilityTimeout time.Duration) *MemoryQueue {
+	return &MemoryQueue{
+		messages:          make([]*memoryMessage, 0),
+		visibilityTimeout: visibilityTimeout,
+	}
+}
+
+func (q *MemoryQueue) Publish(ctx context.Context, message Message) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.sequence += 1
+	q.messages = append(q.messages, &memoryMessage{
+		id:        fmt.Sprintf("memory-%d", q.sequence),
+		body:      message.Body,
+		visibleAt: time.Now().Add(message.Delay),
+	})
+
+	return nil
+}
+
+func (q *MemoryQueue) Receive(ctx context.Context, maxMessages int, wait time.Duration) ([]Delivery, error) {
+	deadline := time.Now().Add(wait)
+
+	for {
+		deliveries := q.receive(maxMessages)
+		if len(deliveries) > 0 || !time.Now().Before(deadline) {
+			return deliveries, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+}
+
+func (q *MemoryQueue) receive(maxMessages int) []Delivery {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	now := time.Now()
+	deliveries := make([]Delivery, 0)
+	for _, m := range q.messages {
+		if len(deliveries) >= maxMessages {
+			break
+		}
+
+		if m.visibleAt.After(now) {
+			continue
+		}
+
+		q.sequence += 1
+		m.handle = fmt.Sprintf("%s-%d", m.id, q.sequence)
+		m.receiveCount += 1
+		m.visibleAt = now.Add(q.visibilityTimeout)
+
+		deliveries = append(deliveries, Delivery{
+			ID:           m.id,
+			Body:         m.body,
+			Handle:       m.handle,
+			ReceiveCount: m.receiveCount,
+		})
+	}
+
+	return deliveries
+}
+
+func (q *MemoryQueue) Ack(ctx context.Context, delivery Delivery) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for idx, m := range q.messages {
+		if m.handle == delivery.Handle {
+			q.messages = append(q.messages[:idx], q.messages[idx+1:]...)
+			return nil
+		}
+	}
+
+	return fmt.Errorf("message %s is no longer in flight", delivery.ID)
+}
+
+func (q *MemoryQueue) Extend(ctx context.Context, delivery Delivery, timeout time.Duration) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for _, m := range q.messages {
+		if m.handle == delivery.Handle {
+			m.visibleAt = time.Now().Add(timeout)
+			return nil
+		}
+	}
+
+	return fmt.Errorf("message %s is no longer in flight", delivery.ID)
+}
+
+// Len returns the number of messages in the queue, including messages in flight
+func (q *MemoryQueue) Len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	return len(q.messages)
+}
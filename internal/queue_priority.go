@@ -0,0 +1,100 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// lanePollWait is how long the lanes are polled for before moving on to the next one. SQS
+// samples only some of its servers without a wait and can miss messages that are there.
+const lanePollWait = time.Second
+
+// PriorityQueue receives from several queues, always draining a queue before moving on to
+// the next one. Queues are given from the highest to the lowest priority.
+type PriorityQueue struct {
+	names  []string
+	queues map[string]Queue
+}
+
+func NewPriorityQueue(names []string, queues []Queue) *PriorityQueue {
+	q := &PriorityQueue{
+		names:  names,
+		queues: make(map[string]Queue, len(queues)),
+	}
+	for idx, name := range names {
+		q.queues[name] = queues[idx]
+	}
+
+	return q
+}
+
+// Publish sends the message to the queue with the highest priority, which is only used for
+// status checks of long running queries
+func (q *PriorityQueue) Publish(ctx context.Context, message Message) error {
+	return q.queues[q.names[0]].Publish(ctx, message)
+}
+
+// Receive returns messages of the queue with the highest priority that has any. Each queue is
+// polled for up to a second, or wait if it is shorter. When all queues are empty it long polls
+// the queue with the highest priority.
+func (q *PriorityQueue) Receive(ctx context.Context, maxMessages int, wait time.Duration) ([]Delivery, error) {
+	laneWait := lanePollWait
+	if wait < laneWait {
+		laneWait = wait
+	}
+
+	for _, name := range q.names {
+		deliveries, err := q.queues[name].Receive(ctx, maxMessages, laneWait)
+		if err != nil {
+			return nil, fmt.Errorf("error receiving from lane %s: %w", name, err)
+		}
+
+		if len(deliveries) > 0 {
+			return withLane(deliveries, name), nil
+		}
+	}
+
+	name := q.names[0]
+	deliveries, err := q.queues[name].Receive(ctx, maxMessages, wait)
+	if err != nil {
+		return nil, fmt.Errorf("error receiving from lane %s: %w", name, err)
+	}
+
+	return withLane(deliveries, name), nil
+}
+
+func withLane(deliveries []Delivery, name string) []Delivery {
+	for idx := range deliveries {
+		deliveries[idx].Lane = name
+	}
+
+	return deliveries
+}
+
+func (q *PriorityQueue) lane(delivery Delivery) (Queue, error) {
+	queue, ok := q.queues[delivery.Lane]
+	if !ok {
+		return nil, fmt.Errorf("message %s was not received from a lane", delivery.ID)
+	}
+
+	return queue, nil
+}
+
+func (q *PriorityQueue) Ack(ctx context.Context, delivery Delivery) error {
+	queue, err := q.lane(delivery)
+	if err != nil {
+		return err
+	}
+
+	return queue.Ack(ctx, delivery)
+}
+
+func (q *PriorityQueue) Extend(ctx context.Context, delivery Delivery, timeout time.Duration) error {
+	queue, err := q.lane(delivery)
+	if err != nil {
+		return err
+	}
+
+	return queue.Extend(ctx, delivery, timeout)
+}
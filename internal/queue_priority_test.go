@@ -0,0 +1,41 @@
+package internal
+
+import (
+	"context"
+	"testing"
+)
+
+func TestPriorityQueue(t *testing.T) {
+	ctx := context.Background()
+	high := NewMemoryQueue(testVisibilityTimeout)
+	low := NewMemoryQueue(testVisibilityTimeout)
+	queue := NewPriorityQueue([]string{"high", "low"}, []Queue{high, low})
+
+	if err := low.Publish(ctx, Message{Body: "backfill"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := high.Publish(ctx, Message{Body: "new"}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, want := range []struct{ body, lane string }{{"new", "high"}, {"backfill", "low"}} {
+		deliveries, err := queue.Receive(ctx, 10, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(deliveries) != 1 || deliveries[0].Body != want.body || deliveries[0].Lane != want.lane {
+			t.Fatalf("got %+v, want %s from lane %s", deliveries, want.body, want.lane)
+		}
+		if err := queue.Ack(ctx, deliveries[0]); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	deliveries, err := queue.Receive(ctx, 10, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(deliveries) != 0 {
+		t.Errorf("got %+v from empty lanes", deliveries)
+	}
+}
@@ -0,0 +1,226 @@
+package internal
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	spoolReadyDir      = "ready"
+	spoolProcessingDir = "processing"
+)
+
+// SpoolQueue is a Queue backed by a directory with one JSON file per message, which lets
+// a producer and consumer exchange messages locally without AWS.
+//
+// New messages are written to ready/<visible at>_<id>_<receive count>.json. A subscriber
+// claims a message by renaming it into processing/, whose modification time holds the end
+// of its visibility timeout. Messages whose timeout expired are moved back to ready/.
+type SpoolQueue struct {
+	dir               string
+	visibilityTimeout time.Duration
+}
+
+func NewSpoolQueue(dir string, visibilityTimeout time.Duration) (*SpoolQueue, error) {
+	for _, sub := range []string{spoolReadyDir, spoolProcessingDir} {
+		if err := os.MkdirAll(filepath.Join(dir, sub), 0755); err != nil {
+			return nil, fmt.Errorf("error creating spool directory: %w", err)
+		}
+	}
+
+	return &SpoolQueue{
+		dir:               dir,
+		visibilityTimeout: visibilityTimeout,
+	}, nil
+}
+
+func (q *SpoolQueue) Publish(ctx context.Context, message Message) error {
+	id, err := newSpoolID()
+	if err != nil {
+		return err
+	}
+
+	return q.write(id, message.Body, time.Now().Add(message.Delay), 0)
+}
+
+// write creates the file under a temporary name first so that subscribers never see a
+// partially written message
+func (q *SpoolQueue) write(id string, body string, visibleAt time.Time, receiveCount int) error {
+	name := fmt.Sprintf("%020d_%s_%d.json", visibleAt.UnixNano(), id, receiveCount)
+	tmp := filepath.Join(q.dir, "."+name)
+
+	if err := ioutil.WriteFile(tmp, []byte(body), 0644); err != nil {
+		return fmt.Errorf("error writing spool message %s: %w", id, err)
+	}
+
+	if err := os.Rename(tmp, filepath.Join(q.dir, spoolReadyDir, name)); err != nil {
+		return fmt.Errorf("error publishing spool message %s: %w", id, err)
+	}
+
+	return nil
+}
+
+func (q *SpoolQueue) Receive(ctx context.Context, maxMessages int, wait time.Duration) ([]Delivery, error) {
+	deadline := time.Now().Add(wait)
+
+	for {
+		if err := q.requeueExpired(); err != nil {
+			return nil, err
+		}
+
+		deliveries, err := q.receive(maxMessages)
+		if err != nil || len(deliveries) > 0 || !time.Now().Before(deadline) {
+			return deliveries, err
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+}
+
+func (q *SpoolQueue) receive(maxMessages int) ([]Delivery, error) {
+	files, err := ioutil.ReadDir(filepath.Join(q.dir, spoolReadyDir))
+	if err != nil {
+		return nil, fmt.Errorf("error reading spool directory: %w", err)
+	}
+
+	names := make([]string, 0, len(files))
+	for _, f := range files {
+		names = append(names, f.Name())
+	}
+	sort.Strings(names)
+
+	now := time.Now()
+	deliveries := make([]Delivery, 0)
+	for _, name := range names {
+		if len(deliveries) >= maxMessages {
+			break
+		}
+
+		visibleAt, id, receiveCount, ok := parseSpoolName(name)
+		if !ok {
+			continue
+		}
+
+		if visibleAt.After(now) {
+			// names sort by visibility so all remaining messages are delayed as well
+			break
+		}
+
+		// The end of the visibility timeout is set before the rename, a message in
+		// processing/ with an expired modification time would be moved back to ready/ by the
+		// requeueExpired of another subscriber before it's claimed
+		ready := filepath.Join(q.dir, spoolReadyDir, name)
+		if err := os.Chtimes(ready, now, now.Add(q.visibilityTimeout)); err != nil {
+			if os.IsNotExist(err) {
+				// claimed by another subscriber in the meantime
+				continue
+			}
+			return deliveries, fmt.Errorf("error claiming spool message %s: %w", id, err)
+		}
+
+		handle := fmt.Sprintf("%s_%d.json", id, receiveCount+1)
+		processing := filepath.Join(q.dir, spoolProcessingDir, handle)
+		if err := os.Rename(ready, processing); err != nil {
+			// claimed by another subscriber in the meantime
+			continue
+		}
+
+		body, err := ioutil.ReadFile(processing)
+		if err != nil {
+			return deliveries, fmt.Errorf("error reading spool message %s: %w", id, err)
+		}
+
+		deliveries = append(deliveries, Delivery{
+			ID:           id,
+			Body:         string(body),
+			Handle:       handle,
+			ReceiveCount: receiveCount + 1,
+		})
+	}
+
+	return deliveries, nil
+}
+
+// requeueExpired moves messages whose visibility timeout ran out back to ready/
+func (q *SpoolQueue) requeueExpired() error {
+	files, err := ioutil.ReadDir(filepath.Join(q.dir, spoolProcessingDir))
+	if err != nil {
+		return fmt.Errorf("error reading spool directory: %w", err)
+	}
+
+	now := time.Now()
+	for _, f := range files {
+		if f.ModTime().After(now) {
+			continue
+		}
+
+		parts := strings.SplitN(strings.TrimSuffix(f.Name(), ".json"), "_", 2)
+		if len(parts) != 2 {
+			continue
+		}
+
+		name := fmt.Sprintf("%020d_%s_%s.json", now.UnixNano(), parts[0], parts[1])
+		// a failed rename means the message was acknowledged or requeued concurrently
+		os.Rename(filepath.Join(q.dir, spoolProcessingDir, f.Name()), filepath.Join(q.dir, spoolReadyDir, name))
+	}
+
+	return nil
+}
+
+func (q *SpoolQueue) Ack(ctx context.Context, delivery Delivery) error {
+	if err := os.Remove(filepath.Join(q.dir, spoolProcessingDir, delivery.Handle)); err != nil {
+		return fmt.Errorf("error acknowledging spool message %s: %w", delivery.ID, err)
+	}
+
+	return nil
+}
+
+func (q *SpoolQueue) Extend(ctx context.Context, delivery Delivery, timeout time.Duration) error {
+	now := time.Now()
+	if err := os.Chtimes(filepath.Join(q.dir, spoolProcessingDir, delivery.Handle), now, now.Add(timeout)); err != nil {
+		return fmt.Errorf("error extending spool message %s: %w", delivery.ID, err)
+	}
+
+	return nil
+}
+
+func parseSpoolName(name string) (time.Time, string, int, bool) {
+	parts := strings.Split(strings.TrimSuffix(name, ".json"), "_")
+	if len(parts) != 3 || !strings.HasSuffix(name, ".json") {
+		return time.Time{}, "", 0, false
+	}
+
+	visibleAt, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return time.Time{}, "", 0, false
+	}
+
+	receiveCount, err := strconv.Atoi(parts[2])
+	if err != nil {
+		return time.Time{}, "", 0, false
+	}
+
+	return time.Unix(0, visibleAt), parts[1], receiveCount, true
+}
+
+func newSpoolID() (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("error generating spool message ID: %w", err)
+	}
+
+	return hex.EncodeToString(b), nil
+}
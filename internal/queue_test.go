@@ -0,0 +1,156 @@
+package internal
+
+import (
+	"context"
+	"sort"
+	"testing"
+	"time"
+)
+
+const testVisibilityTimeout = 100 * time.Millisecond
+
+func testQueues(t *testing.T) map[string]Queue {
+	spool, err := NewSpoolQueue(t.TempDir(), testVisibilityTimeout)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return map[string]Queue{
+		"memory": NewMemoryQueue(testVisibilityTimeout),
+		"spool":  spool,
+	}
+}
+
+func receiveBodies(t *testing.T, queue Queue, maxMessages int) ([]string, []Delivery) {
+	deliveries, err := queue.Receive(context.Background(), maxMessages, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bodies := make([]string, len(deliveries))
+	for idx, delivery := range deliveries {
+		bodies[idx] = delivery.Body
+	}
+	sort.Strings(bodies)
+
+	return bodies, deliveries
+}
+
+func TestQueues(t *testing.T) {
+	tests := []struct {
+		name string
+		run  func(t *testing.T, queue Queue)
+	}{
+		{
+			name: "receive at most max messages and ack them",
+			run: func(t *testing.T, queue Queue) {
+				ctx := context.Background()
+				for _, body := range []string{"a", "b", "c"} {
+					if err := queue.Publish(ctx, Message{Body: body}); err != nil {
+						t.Fatal(err)
+					}
+				}
+
+				first, deliveries := receiveBodies(t, queue, 2)
+				if len(first) != 2 {
+					t.Fatalf("got %d messages, want 2", len(first))
+				}
+				for _, delivery := range deliveries {
+					if err := queue.Ack(ctx, delivery); err != nil {
+						t.Fatal(err)
+					}
+				}
+
+				second, deliveries := receiveBodies(t, queue, 10)
+				if len(second) != 1 || second[0] == first[0] || second[0] == first[1] {
+					t.Fatalf("got %v after %v, want the remaining message", second, first)
+				}
+				if err := queue.Ack(ctx, deliveries[0]); err != nil {
+					t.Fatal(err)
+				}
+
+				if bodies, _ := receiveBodies(t, queue, 10); len(bodies) != 0 {
+					t.Fatalf("got %v from an empty queue", bodies)
+				}
+			},
+		},
+		{
+			name: "redeliver once the visibility timeout expired",
+			run: func(t *testing.T, queue Queue) {
+				ctx := context.Background()
+				if err := queue.Publish(ctx, Message{Body: "a"}); err != nil {
+					t.Fatal(err)
+				}
+
+				_, first := receiveBodies(t, queue, 10)
+				if len(first) != 1 || first[0].ReceiveCount != 1 {
+					t.Fatalf("got %+v, want one delivery received once", first)
+				}
+
+				if bodies, _ := receiveBodies(t, queue, 10); len(bodies) != 0 {
+					t.Fatalf("got %v while the message is in flight", bodies)
+				}
+
+				time.Sleep(2 * testVisibilityTimeout)
+				_, second := receiveBodies(t, queue, 10)
+				if len(second) != 1 || second[0].ReceiveCount != 2 {
+					t.Fatalf("got %+v, want one delivery received twice", second)
+				}
+
+				if err := queue.Ack(ctx, first[0]); err == nil {
+					t.Error("acknowledged a message with the handle of an expired delivery")
+				}
+				if err := queue.Ack(ctx, second[0]); err != nil {
+					t.Fatal(err)
+				}
+			},
+		},
+		{
+			name: "extend the visibility timeout",
+			run: func(t *testing.T, queue Queue) {
+				ctx := context.Background()
+				if err := queue.Publish(ctx, Message{Body: "a"}); err != nil {
+					t.Fatal(err)
+				}
+
+				_, deliveries := receiveBodies(t, queue, 10)
+				if len(deliveries) != 1 {
+					t.Fatalf("got %d deliveries, want 1", len(deliveries))
+				}
+				if err := queue.Extend(ctx, deliveries[0], time.Hour); err != nil {
+					t.Fatal(err)
+				}
+
+				time.Sleep(2 * testVisibilityTimeout)
+				if bodies, _ := receiveBodies(t, queue, 10); len(bodies) != 0 {
+					t.Fatalf("got %v while the message is in flight", bodies)
+				}
+			},
+		},
+		{
+			name: "hold back delayed messages",
+			run: func(t *testing.T, queue Queue) {
+				ctx := context.Background()
+				if err := queue.Publish(ctx, Message{Body: "delayed", Delay: time.Hour}); err != nil {
+					t.Fatal(err)
+				}
+				if err := queue.Publish(ctx, Message{Body: "a"}); err != nil {
+					t.Fatal(err)
+				}
+
+				if bodies, _ := receiveBodies(t, queue, 10); len(bodies) != 1 || bodies[0] != "a" {
+					t.Fatalf("got %v, want only the message without delay", bodies)
+				}
+			},
+		},
+	}
+
+	for _, test := range tests {
+		for name, queue := range testQueues(t) {
+			queue := queue
+			t.Run(name+"/"+test.name, func(t *testing.T) {
+				test.run(t, queue)
+			})
+		}
+	}
+}
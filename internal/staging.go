@@ -0,0 +1,38 @@
+package internal
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+// RetargetView returns a copy of the view created in, or dropped from, schema instead of
+// the schema of its name
+func RetargetView(view ViewEntry, schema string) ViewEntry {
+	_, name := splitViewName(view.ViewName)
+	viewName := strings.ToLower(schema + "." + name)
+
+	view.DDL = strings.Replace(view.DDL, view.ViewName, viewName, 1)
+	view.ViewName = viewName
+
+	return view
+}
+
+// CloneSchema replaces the staging schema with a zero-copy clone of the target schema
+func CloneSchema(ctx context.Context, db *sql.DB, target string, staging string) error {
+	if _, err := db.ExecContext(ctx, fmt.Sprintf("CREATE OR REPLACE SCHEMA %s CLONE %s", staging, target)); err != nil {
+		return fmt.Errorf("error cloning schema %s into %s: %w", target, staging, err)
+	}
+
+	return nil
+}
+
+// SwapSchemas atomically exchanges the contents of the two schemas
+func SwapSchemas(ctx context.Context, db *sql.DB, target string, staging string) error {
+	if _, err := db.ExecContext(ctx, fmt.Sprintf("ALTER SCHEMA %s SWAP WITH %s", target, staging)); err != nil {
+		return fmt.Errorf("error swapping schema %s with %s: %w", target, staging, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,155 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// What happens to the views of a contract that are no longer generated from its ABI
+const (
+	// StaleViewsKeep leaves stale views in place
+	StaleViewsKeep = "keep"
+	// StaleViewsDrop drops stale views
+	StaleViewsDrop = "drop"
+	// StaleViewsDeprecate marks stale views as deprecated in their comment
+	StaleViewsDeprecate = "deprecate"
+)
+
+// DeprecatedCommentPrefix starts the comment of a view that was deprecated
+const DeprecatedCommentPrefix = "DEPRECATED"
+
+// ABIChange describes how the views of a contract change with its current ABI
+type ABIChange struct {
+	ContractAddress string
+	// Before holds the signatures of the existing views, After those of the generated views
+	Before []string
+	After  []string
+	// Stale holds the existing views that are no longer generated
+	Stale []PlanEntry
+}
+
+// DropViewDDL returns the statement dropping a view
+func DropViewDDL(viewName string) string {
+	return fmt.Sprintf("DROP VIEW IF EXISTS %s;", viewName)
+}
+
+// DeprecateViewDDL returns the statement marking a stale view as deprecated. The signature
+// the view was generated for is kept in the comment.
+func DeprecateViewDDL(viewName string, signature string, at time.Time) string {
+	comment := fmt.Sprintf("%s %s: no longer part of the contract ABI", DeprecatedCommentPrefix, at.UTC().Format("2006-01-02"))
+	if signature != "" {
+		comment = fmt.Sprintf("%s, was %s", comment, signature)
+	}
+
+	return fmt.Sprintf("ALTER VIEW IF EXISTS %s SET COMMENT = '%s';", viewName, strings.ReplaceAll(comment, "'", "''"))
+}
+
+// ParseStaleViews validates the stale views strategy, which defaults to keeping them
+func ParseStaleViews(value string) (string, error) {
+	switch value {
+	case "":
+		return StaleViewsKeep, nil
+	case StaleViewsKeep, StaleViewsDrop, StaleViewsDeprecate:
+		return value, nil
+	default:
+		return "", fmt.Errorf("unknown stale views strategy %q, expected %s, %s or %s", value, StaleViewsKeep, StaleViewsDrop, StaleViewsDeprecate)
+	}
+}
+
+// ABIChange compares the generated views of a contract with the existing views of the
+// contract in the schemas of the generated views. It returns nil if the contract has no
+// views yet or its views match the generated ones. Views that were already deprecated are
+// not reported as stale again.
+func (c *ViewCatalog) ABIChange(ctx context.Context, contractAddress string, views []ViewEntry) (*ABIChange, error) {
+	generated := make(map[string]ViewEntry, len(views))
+	schemas := make(map[string]bool)
+	for _, view := range views {
+		schema, name := splitViewName(view.ViewName)
+		generated[schema+"."+name] = view
+		schemas[schema] = true
+	}
+
+	change := &ABIChange{ContractAddress: strings.ToLower(contractAddress)}
+	changed := false
+	for schema := range schemas {
+		existing, err := c.load(ctx, schema)
+		if err != nil {
+			return nil, err
+		}
+
+		c.mu.Lock()
+		names := c.contracts[schema][change.ContractAddress]
+		c.mu.Unlock()
+
+		for _, name := range names {
+			view := existing[name]
+			if strings.HasPrefix(view.comment, DeprecatedCommentPrefix) {
+				continue
+			}
+
+			// Views created before signatures were kept in their comment are listed by name
+			signature := view.comment
+			if signature == "" {
+				signature = strings.ToLower(strings.TrimPrefix(name, c.prefix+strings.ToUpper(change.ContractAddress)+"_"))
+			}
+			change.Before = append(change.Before, signature)
+
+			entry, ok := generated[schema+"."+name]
+			if !ok {
+				change.Stale = append(change.Stale, PlanEntry{
+					ContractAddress: change.ContractAddress,
+					Action:          ViewActionOrphaned,
+					State:           view.hash,
+					ViewEntry:       ViewEntry{ViewName: strings.ToLower(schema + "." + name), Signature: view.comment},
+				})
+				changed = true
+				continue
+			}
+
+			if view.comment != "" && view.comment != entry.Signature {
+				changed = true
+			}
+		}
+	}
+
+	if len(change.Before) == 0 {
+		return nil, nil
+	}
+
+	for _, view := range views {
+		change.After = append(change.After, view.Signature)
+	}
+
+	// A generated view without an existing one is an event or method added to the ABI
+	if len(change.After) != len(change.Before)-len(change.Stale) {
+		changed = true
+	}
+
+	if !changed {
+		return nil, nil
+	}
+
+	sort.Strings(change.Before)
+	sort.Strings(change.After)
+	sort.Slice(change.Stale, func(i, j int) bool { return change.Stale[i].ViewName < change.Stale[j].ViewName })
+
+	return change, nil
+}
+
+// StaleStatements returns the statements applying the strategy to the stale views
+func (a *ABIChange) StaleStatements(strategy string, at time.Time) []ViewEntry {
+	statements := make([]ViewEntry, 0, len(a.Stale))
+	for _, entry := range a.Stale {
+		switch strategy {
+		case StaleViewsDrop:
+			statements = append(statements, ViewEntry{ViewName: entry.ViewName, Signature: entry.Signature, DDL: DropViewDDL(entry.ViewName)})
+		case StaleViewsDeprecate:
+			statements = append(statements, ViewEntry{ViewName: entry.ViewName, Signature: entry.Signature, DDL: DeprecateViewDDL(entry.ViewName, entry.Signature, at)})
+		}
+	}
+
+	return statements
+}
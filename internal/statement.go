@@ -0,0 +1,215 @@
+package internal
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+
+	sf "github.com/snowflakedb/gosnowflake"
+)
+
+var viewNameRegex = regexp.MustCompile(`(?i)^\s*CREATE\s+OR\s+REPLACE\s+VIEW\s+([^\s(]+)`)
+
+type StatementResult struct {
+	// ViewName is the fully qualified name of the view the statement creates
+	ViewName string
+	// Signature is the event or method signature of the view, empty for older messages
+	Signature string
+	// DDLHash is the hash of the statement as returned by HashDDL
+	DDLHash string
+	// QueryID is the snowflake query ID of the statement
+	QueryID string
+	// Error is the error returned by snowflake, nil if the statement succeeded
+	Error error
+}
+
+// SplitStatements splits a multi-statement SQL buffer into its individual statements.
+// The generated view definitions never contain a semicolon other than the one
+// terminating the statement, so splitting on it is sufficient here.
+func SplitStatements(sql string) []string {
+	statements := []string{}
+	for _, s := range strings.Split(sql, ";") {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+		statements = append(statements, s+";")
+	}
+
+	return statements
+}
+
+// ViewName returns the view name created by a CREATE OR REPLACE VIEW statement or an
+// empty string if the statement is not a view definition.
+func ViewName(statement string) string {
+	match := viewNameRegex.FindStringSubmatch(statement)
+	if match == nil {
+		return ""
+	}
+
+	return match[1]
+}
+
+// HashDDL returns the hex encoded sha256 hash of a statement, ignoring surrounding whitespace
+func HashDDL(statement string) string {
+	sum := sha256.Sum256([]byte(strings.TrimSpace(statement)))
+	return hex.EncodeToString(sum[:])
+}
+
+// execWithQueryID runs the query and returns the snowflake query ID along with the error.
+// The query ID is empty if the query never reached snowflake.
+func execWithQueryID(ctx context.Context, db *sql.DB, query string) (string, error) {
+	queryIDChan := make(chan string, 1)
+	_, err := db.ExecContext(sf.WithQueryIDChan(ctx, queryIDChan), query)
+
+	select {
+	case queryID := <-queryIDChan:
+		return queryID, err
+	default:
+		return "", err
+	}
+}
+
+// ExecuteStatements submits the statement of each view separately, continuing past
+// failures so that every view gets its own result.
+func ExecuteStatements(ctx context.Context, db *sql.DB, views []ViewEntry) []StatementResult {
+	results := make([]StatementResult, len(views))
+	for idx, view := range views {
+		queryID, err := execWithQueryID(ctx, db, view.DDL)
+		results[idx] = StatementResult{
+			ViewName:  view.ViewName,
+			Signature: view.Signature,
+			DDLHash:   HashDDL(view.DDL),
+			QueryID:   queryID,
+			Error:     err,
+		}
+	}
+
+	return results
+}
+
+type ExecutionResult struct {
+	// QueryID is the snowflake query ID of the multi-statement query
+	QueryID string
+	// Pending is true when the query was still running close to the invocation deadline
+	Pending bool
+	// Fallback is true when the statements had to be submitted one at a time
+	Fallback bool
+	// Statements holds the per statement results when Fallback is true
+	Statements []StatementResult
+}
+
+// Failed returns the results of the statements that could not be executed
+func (r *ExecutionResult) Failed() []StatementResult {
+	failed := make([]StatementResult, 0)
+	for _, result := range r.Statements {
+		if result.Error != nil {
+			failed = append(failed, result)
+		}
+	}
+
+	return failed
+}
+
+// Partial is true when some but not all statements of the message succeeded
+func (r *ExecutionResult) Partial() bool {
+	failed := len(r.Failed())
+	return failed > 0 && failed < len(r.Statements)
+}
+
+// ExecuteMessage submits all statements of the message as a single async multi-statement
+// query and waits for it while the invocation deadline of ctx allows. If the query is still
+// running close to the deadline the result is marked as Pending so that the caller can hand
+// off the query ID. If the query fails the statements are submitted one at a time so that a
+// single broken view does not prevent the remaining views of the contract from being
+// created. An error is only returned when no statement could be executed at all.
+func ExecuteMessage(ctx context.Context, db *sql.DB, message *QueueMessage) (*ExecutionResult, error) {
+	if message.PendingQueryID != "" {
+		return checkPendingMessage(ctx, db, message)
+	}
+
+	uuid := sf.NewUUID()
+	ctxWithId := sf.WithRequestID(ctx, uuid)
+	multiStatementCtx, _ := sf.WithMultiStatement(ctxWithId, message.NumberOfStatements)
+
+	log.Printf("submitting query with request ID: %s\n", uuid.String())
+
+	// The query is submitted from a dedicated session tagged with the message, so that it can
+	// be found again if a FIFO message is redelivered while the query is still running
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return &ExecutionResult{}, fmt.Errorf("error connecting for contract address: %s: %w", message.ContractAddress, err)
+	}
+	defer conn.Close()
+
+	unsetQueryTag, err := setQueryTag(ctx, conn, message.QueryTag())
+	if err != nil {
+		log.Printf("error setting query tag for contract address %s: %s\n", message.ContractAddress, err)
+	}
+
+	queryID, done, err := submitAsync(multiStatementCtx, conn, message.SQL())
+	unsetQueryTag()
+	result := &ExecutionResult{QueryID: queryID}
+	if err == nil {
+		log.Printf("query ID %s submitted for contract address %s\n", queryID, message.ContractAddress)
+
+		finished, queryErr := waitForResult(ctx, queryID, done)
+		if !finished {
+			result.Pending = true
+			return result, nil
+		}
+		err = queryErr
+	}
+
+	if err == nil {
+		return result, nil
+	}
+
+	log.Printf("multistatement query %s failed for contract address %s, falling back to single statements: %s\n", queryID, message.ContractAddress, err)
+
+	result.Fallback = true
+	result.Statements = ExecuteStatements(ctx, db, message.Entries())
+
+	if len(result.Failed()) == len(result.Statements) {
+		return result, fmt.Errorf("error with multistatement query for contract address: %s: %w", message.ContractAddress, err)
+	}
+
+	return result, nil
+}
+
+// checkPendingMessage waits for a query submitted by an earlier invocation. If the query
+// failed the statements are submitted one at a time as in ExecuteMessage. Status check
+// messages of older consumers carry no statements, so there is nothing to fall back to.
+func checkPendingMessage(ctx context.Context, db *sql.DB, message *QueueMessage) (*ExecutionResult, error) {
+	result := &ExecutionResult{QueryID: message.PendingQueryID}
+
+	finished, err := WaitForQuery(ctx, db, message.PendingQueryID)
+	if !finished {
+		result.Pending = true
+		return result, nil
+	}
+
+	if err == nil {
+		return result, nil
+	}
+
+	if len(message.Views) == 0 {
+		return result, fmt.Errorf("pending query ID %s failed for contract address: %s: %w", message.PendingQueryID, message.ContractAddress, err)
+	}
+
+	log.Printf("pending query ID %s failed for contract address %s, falling back to single statements: %s\n", message.PendingQueryID, message.ContractAddress, err)
+
+	result.Fallback = true
+	result.Statements = ExecuteStatements(ctx, db, message.Entries())
+
+	if len(result.Failed()) == len(result.Statements) {
+		return result, fmt.Errorf("pending query ID %s failed for contract address: %s: %w", message.PendingQueryID, message.ContractAddress, err)
+	}
+
+	return result, nil
+}
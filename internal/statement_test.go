@@ -0,0 +1,43 @@
+package internal
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSplitStatements(t *testing.T) {
+	tests := []struct {
+		name string
+		sql  string
+		want []string
+	}{
+		{
+			name: "empty",
+			sql:  "",
+			want: []string{},
+		},
+		{
+			name: "whitespace and empty statements",
+			sql:  " \n;;\n ; ",
+			want: []string{},
+		},
+		{
+			name: "single statement without semicolon",
+			sql:  "CREATE OR REPLACE VIEW a AS SELECT 1",
+			want: []string{"CREATE OR REPLACE VIEW a AS SELECT 1;"},
+		},
+		{
+			name: "multiple statements",
+			sql:  "CREATE OR REPLACE VIEW a AS SELECT 1;\n\n  CREATE OR REPLACE VIEW b AS\nSELECT 2;\n",
+			want: []string{"CREATE OR REPLACE VIEW a AS SELECT 1;", "CREATE OR REPLACE VIEW b AS\nSELECT 2;"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := SplitStatements(test.sql); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("got %q, want %q", got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,86 @@
+package internal
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+// existingDefinitions returns the definition hash of every view of the list that exists,
+// keyed by view name as given
+func existingDefinitions(ctx context.Context, db *sql.DB, viewNames []string) (map[string]string, error) {
+	bySchema := make(map[string][]string)
+	keys := make(map[string]string)
+	for _, viewName := range viewNames {
+		schema, name := splitViewName(viewName)
+		bySchema[schema] = append(bySchema[schema], name)
+		keys[schema+"."+name] = viewName
+	}
+
+	hashes := make(map[string]string)
+	for schema, names := range bySchema {
+		placeholders := strings.TrimSuffix(strings.Repeat("?,", len(names)), ",")
+		query := fmt.Sprintf("SELECT table_schema, table_name, view_definition FROM information_schema.views WHERE table_schema = ? AND table_name IN (%s)", placeholders)
+
+		args := make([]interface{}, 0, len(names)+1)
+		args = append(args, schema)
+		for _, name := range names {
+			args = append(args, name)
+		}
+
+		rows, err := db.QueryContext(ctx, query, args...)
+		if err != nil {
+			return nil, fmt.Errorf("error reading existing view definitions: %w", err)
+		}
+
+		for rows.Next() {
+			var tableSchema, tableName string
+			var definition sql.NullString
+			if err := rows.Scan(&tableSchema, &tableName, &definition); err != nil {
+				rows.Close()
+				return nil, fmt.Errorf("error reading existing view definitions: %w", err)
+			}
+			hashes[keys[tableSchema+"."+tableName]] = DefinitionHash(definition.String)
+		}
+
+		err = rows.Err()
+		rows.Close()
+		if err != nil {
+			return nil, fmt.Errorf("error reading existing view definitions: %w", err)
+		}
+	}
+
+	return hashes, nil
+}
+
+// SkipUnchangedViews returns a copy of the message without the views that already exist
+// with the same definition, along with the views that were left out
+func SkipUnchangedViews(ctx context.Context, db *sql.DB, message *QueueMessage) (*QueueMessage, []ViewEntry, error) {
+	entries := message.Entries()
+	viewNames := make([]string, len(entries))
+	for idx, view := range entries {
+		viewNames[idx] = view.ViewName
+	}
+
+	existing, err := existingDefinitions(ctx, db, viewNames)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	changed := make([]ViewEntry, 0, len(entries))
+	unchanged := make([]ViewEntry, 0)
+	for _, view := range entries {
+		if hash, ok := existing[view.ViewName]; ok && hash == DefinitionHash(view.DDL) {
+			unchanged = append(unchanged, view)
+			continue
+		}
+		changed = append(changed, view)
+	}
+
+	if len(unchanged) == 0 {
+		return message, unchanged, nil
+	}
+
+	return message.WithViews(changed), unchanged, nil
+}
@@ -0,0 +1,205 @@
+package internal
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"text/tabwriter"
+)
+
+// FieldMismatch is an input of a view row the decoder UDF decoded differently than go-ethereum
+type FieldMismatch struct {
+	ViewName string
+	// Row identifies the row by transaction hash and log or transaction index
+	Row    string
+	Column string
+	// UDF is the value of the view, Go the value decoded by go-ethereum
+	UDF string
+	Go  string
+}
+
+// CompareInputs compares the inp_ columns of a view row to the values decoded by go-ethereum.
+// actual holds the JSON of the columns, nil for SQL NULL. expected is nil if go-ethereum
+// couldn't decode the inputs, in which case the decoder UDF must return null as well.
+// Scalars are compared by their text, so 1000 and "1000" are equal.
+func CompareInputs(columns []string, expected []interface{}, actual []*string) []FieldMismatch {
+	mismatches := make([]FieldMismatch, 0)
+	for idx, column := range columns {
+		goValue := expectedValue(expected, idx)
+		udfValue := columnValue(actual[idx])
+
+		if goValue != udfValue {
+			mismatches = append(mismatches, FieldMismatch{Column: column, UDF: udfValue, Go: goValue})
+		}
+	}
+
+	return mismatches
+}
+
+// CompareDecoders compares the inputs a new version of the decoder UDF decoded to the ones
+// of the existing UDF, both as in CompareInputs. A field decoded differently is a fix if the
+// candidate matches go-ethereum and a regression otherwise. existing is nil if there is no
+// existing UDF, then every field of the candidate has to match go-ethereum. It returns the
+// regressions and the number of fixes.
+func CompareDecoders(columns []string, expected []interface{}, existing []*string, candidate []*string) ([]FieldMismatch, int) {
+	regressions := make([]FieldMismatch, 0)
+	fixes := 0
+	for idx, column := range columns {
+		candidateValue := columnValue(candidate[idx])
+		if existing != nil && candidateValue == columnValue(existing[idx]) {
+			continue
+		}
+
+		goValue := expectedValue(expected, idx)
+		if candidateValue != goValue {
+			regressions = append(regressions, FieldMismatch{Column: column, UDF: candidateValue, Go: goValue})
+			continue
+		}
+
+		if existing != nil {
+			fixes += 1
+		}
+	}
+
+	return regressions, fixes
+}
+
+func expectedValue(expected []interface{}, idx int) string {
+	if expected == nil {
+		return "null"
+	}
+
+	return canonicalValue(expected[idx])
+}
+
+func columnValue(value *string) string {
+	if value == nil {
+		return "null"
+	}
+
+	return canonicalValue(parseJSONValue(*value))
+}
+
+// parseJSONValue parses the JSON of a variant column. Values that aren't valid JSON, such as
+// strings exported without their quotes, are returned as is.
+func parseJSONValue(s string) interface{} {
+	decoder := json.NewDecoder(strings.NewReader(s))
+	decoder.UseNumber()
+
+	var value interface{}
+	if err := decoder.Decode(&value); err != nil || decoder.More() {
+		return s
+	}
+
+	return value
+}
+
+func canonicalValue(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return "null"
+	case string:
+		return v
+	case json.Number:
+		return v.String()
+	case bool:
+		return strconv.FormatBool(v)
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case []interface{}:
+		values := make([]string, len(v))
+		for i, elem := range v {
+			values[i] = canonicalValue(elem)
+		}
+		return "[" + strings.Join(values, ",") + "]"
+	default:
+		var buffer bytes.Buffer
+		_ = json.NewEncoder(&buffer).Encode(v)
+		return strings.TrimSpace(buffer.String())
+	}
+}
+
+// VerifyReport collects the sampled rows and mismatches of a verify run
+type VerifyReport struct {
+	mu sync.Mutex
+	// Sampled maps view names to the number of rows sampled from them
+	Sampled map[string]int
+	// Unmatched maps view names to the number of sampled rows without a raw log or input
+	Unmatched  map[string]int
+	Mismatches []FieldMismatch
+}
+
+func NewVerifyReport() *VerifyReport {
+	return &VerifyReport{Sampled: make(map[string]int), Unmatched: make(map[string]int)}
+}
+
+// Add records the rows sampled from a view and their mismatches
+func (r *VerifyReport) Add(viewName string, sampled int, unmatched int, mismatches []FieldMismatch) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.Sampled[viewName] += sampled
+	r.Unmatched[viewName] += unmatched
+	for _, mismatch := range mismatches {
+		mismatch.ViewName = viewName
+		r.Mismatches = append(r.Mismatches, mismatch)
+	}
+}
+
+// MismatchedViews returns the number of views with at least one mismatch
+func (r *VerifyReport) MismatchedViews() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	views := make(map[string]bool)
+	for _, mismatch := range r.Mismatches {
+		views[mismatch.ViewName] = true
+	}
+
+	return len(views)
+}
+
+// WriteReport writes the mismatches as a table, followed by the number of sampled,
+// unmatched and mismatched rows per view
+func (r *VerifyReport) WriteReport(out io.Writer) {
+	r.mu.Lock()
+	mismatches := append([]FieldMismatch{}, r.Mismatches...)
+	viewNames := make([]string, 0, len(r.Sampled))
+	for viewName := range r.Sampled {
+		viewNames = append(viewNames, viewName)
+	}
+	r.mu.Unlock()
+
+	sort.SliceStable(mismatches, func(i, j int) bool { return mismatches[i].ViewName < mismatches[j].ViewName })
+	sort.Strings(viewNames)
+
+	mismatchedRows := make(map[string]map[string]bool)
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "VIEW_NAME\tROW\tCOLUMN\tUDF\tGO")
+	for _, mismatch := range mismatches {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", mismatch.ViewName, mismatch.Row, mismatch.Column, mismatch.UDF, mismatch.Go)
+		if mismatchedRows[mismatch.ViewName] == nil {
+			mismatchedRows[mismatch.ViewName] = make(map[string]bool)
+		}
+		mismatchedRows[mismatch.ViewName][mismatch.Row] = true
+	}
+	w.Flush()
+
+	fmt.Fprintln(out)
+	w = tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "VIEW_NAME\tSAMPLED\tUNMATCHED\tMISMATCHED")
+	sampled := 0
+	for _, viewName := range viewNames {
+		fmt.Fprintf(w, "%s\t%d\t%d\t%d\n", viewName, r.Sampled[viewName], r.Unmatched[viewName], len(mismatchedRows[viewName]))
+		sampled += r.Sampled[viewName]
+	}
+	w.Flush()
+
+	fmt.Fprintf(out, "\nVerify: %d rows sampled from %d views, %d mismatched fields in %d views\n",
+		sampled, len(viewNames), len(mismatches), len(mismatchedRows))
+}
@@ -0,0 +1,126 @@
+package internal
+
+import (
+	"reflect"
+	"testing"
+)
+
+func stringPtr(s string) *string {
+	return &s
+}
+
+func TestCompareInputs(t *testing.T) {
+	columns := []string{"inp_from", "inp_value"}
+
+	tests := []struct {
+		name     string
+		expected []interface{}
+		actual   []*string
+		want     []FieldMismatch
+	}{
+		{
+			name:     "equal values",
+			expected: []interface{}{"0xabc", "1000000000000000000000"},
+			actual:   []*string{stringPtr(`"0xabc"`), stringPtr(`"1000000000000000000000"`)},
+			want:     []FieldMismatch{},
+		},
+		{
+			name:     "numbers equal to their text",
+			expected: []interface{}{"0xabc", int64(1000)},
+			actual:   []*string{stringPtr(`"0xabc"`), stringPtr(`"1000"`)},
+			want:     []FieldMismatch{},
+		},
+		{
+			name:     "strings without quotes",
+			expected: []interface{}{"0xabc", "1"},
+			actual:   []*string{stringPtr(`0xabc`), stringPtr(`1`)},
+			want:     []FieldMismatch{},
+		},
+		{
+			name:     "arrays",
+			expected: []interface{}{[]interface{}{"0xabc", "0xdef"}, []interface{}{int64(1), true}},
+			actual:   []*string{stringPtr(`["0xabc", "0xdef"]`), stringPtr(`[1, true]`)},
+			want:     []FieldMismatch{},
+		},
+		{
+			name:     "different value",
+			expected: []interface{}{"0xabc", "5"},
+			actual:   []*string{stringPtr(`"0xabc"`), stringPtr(`"6"`)},
+			want:     []FieldMismatch{{Column: "inp_value", UDF: "6", Go: "5"}},
+		},
+		{
+			name:     "null from the UDF",
+			expected: []interface{}{"0xabc", "5"},
+			actual:   []*string{stringPtr(`"0xabc"`), nil},
+			want:     []FieldMismatch{{Column: "inp_value", UDF: "null", Go: "5"}},
+		},
+		{
+			name:     "inputs go-ethereum can't decode are null",
+			expected: nil,
+			actual:   []*string{nil, stringPtr(`"5"`)},
+			want:     []FieldMismatch{{Column: "inp_value", UDF: "5", Go: "null"}},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := CompareInputs(columns, test.expected, test.actual); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("got %+v, want %+v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestCompareDecoders(t *testing.T) {
+	columns := []string{"inp_from", "inp_value"}
+
+	tests := []struct {
+		name      string
+		expected  []interface{}
+		existing  []*string
+		candidate []*string
+		want      []FieldMismatch
+		wantFixes int
+	}{
+		{
+			name:      "same as the existing UDF",
+			expected:  []interface{}{"0xabc", "5"},
+			existing:  []*string{stringPtr(`"0xabc"`), stringPtr(`"6"`)},
+			candidate: []*string{stringPtr(`"0xabc"`), stringPtr(`"6"`)},
+			want:      []FieldMismatch{},
+		},
+		{
+			name:      "fixed to match go-ethereum",
+			expected:  []interface{}{"0xabc", "5"},
+			existing:  []*string{stringPtr(`"0xabc"`), nil},
+			candidate: []*string{stringPtr(`"0xabc"`), stringPtr(`"5"`)},
+			want:      []FieldMismatch{},
+			wantFixes: 1,
+		},
+		{
+			name:      "regression",
+			expected:  []interface{}{"0xabc", "5"},
+			existing:  []*string{stringPtr(`"0xabc"`), stringPtr(`"5"`)},
+			candidate: []*string{stringPtr(`"0xabc"`), nil},
+			want:      []FieldMismatch{{Column: "inp_value", UDF: "null", Go: "5"}},
+		},
+		{
+			name:      "no existing UDF",
+			expected:  []interface{}{"0xabc", "5"},
+			candidate: []*string{stringPtr(`"0xabc"`), stringPtr(`"6"`)},
+			want:      []FieldMismatch{{Column: "inp_value", UDF: "6", Go: "5"}},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, fixes := CompareDecoders(columns, test.expected, test.existing, test.candidate)
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("got %+v, want %+v", got, test.want)
+			}
+			if fixes != test.wantFixes {
+				t.Errorf("got %d fixes, want %d", fixes, test.wantFixes)
+			}
+		})
+	}
+}
@@ -0,0 +1,5 @@
+package internal
+
+// Version identifies the build of the producer and consumer. It is set at build time with
+// -ldflags "-X github.com/credmark/abi-sql-view-generator/internal.Version=..."
+var Version = "dev"
@@ -0,0 +1,196 @@
+package internal
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"time"
+)
+
+const watermarkTimeFormat = "2006-01-02 15:04:05.999999999"
+
+// Watermark marks how far an incremental run got
+type Watermark struct {
+	// VerifiedAt is the latest verification or update time of the processed contracts
+	VerifiedAt time.Time `json:"verified_at"`
+	// Block is the latest block of the logs counted towards the log threshold
+	Block int64 `json:"block"`
+}
+
+// IsZero is true when no run completed yet
+func (w Watermark) IsZero() bool {
+	return w.VerifiedAt.IsZero() && w.Block == 0
+}
+
+// VerifiedAtString formats VerifiedAt as a snowflake TIMESTAMP_NTZ literal
+func (w Watermark) VerifiedAtString() string {
+	return w.VerifiedAt.UTC().Format(watermarkTimeFormat)
+}
+
+// WatermarkStore keeps the watermark between runs
+type WatermarkStore interface {
+	// Load returns the stored watermark, or a zero watermark if none was stored yet
+	Load(ctx context.Context) (Watermark, error)
+	// Save stores the watermark once a run completed
+	Save(ctx context.Context, watermark Watermark) error
+}
+
+// FileWatermarkStore keeps the watermark in a local JSON file
+type FileWatermarkStore struct {
+	path string
+}
+
+func NewFileWatermarkStore(path string) *FileWatermarkStore {
+	return &FileWatermarkStore{path: path}
+}
+
+func (s *FileWatermarkStore) Load(ctx context.Context) (Watermark, error) {
+	watermark := Watermark{}
+
+	data, err := ioutil.ReadFile(s.path)
+	if os.IsNotExist(err) {
+		return watermark, nil
+	}
+	if err != nil {
+		return watermark, fmt.Errorf("error reading watermark file %s: %w", s.path, err)
+	}
+
+	if err := json.Unmarshal(data, &watermark); err != nil {
+		return watermark, fmt.Errorf("error deserializing watermark file %s: %w", s.path, err)
+	}
+
+	return watermark, nil
+}
+
+func (s *FileWatermarkStore) Save(ctx context.Context, watermark Watermark) error {
+	data, err := json.MarshalIndent(watermark, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error serializing watermark: %w", err)
+	}
+
+	// Write to a temporary file first so that a crash never leaves a truncated watermark
+	tmp := s.path + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return fmt.Errorf("error writing watermark file %s: %w", tmp, err)
+	}
+
+	if err := os.Rename(tmp, s.path); err != nil {
+		return fmt.Errorf("error writing watermark file %s: %w", s.path, err)
+	}
+
+	return nil
+}
+
+// TableWatermarkStore keeps the watermark of each namespace in a row of a snowflake table,
+// along with the log count of every address up to the watermark block. Incremental runs add
+// the new logs to the stored counts to find the contracts that crossed the log threshold
+// instead of counting all logs again.
+type TableWatermarkStore struct {
+	db          *sql.DB
+	table       string
+	countsTable string
+	logsTable   string
+	name        string
+	// loaded is the watermark the stored log counts are counted up to
+	loaded Watermark
+}
+
+func NewTableWatermarkStore(db *sql.DB, table string, countsTable string, logsTable string, name string) *TableWatermarkStore {
+	return &TableWatermarkStore{
+		db:          db,
+		table:       table,
+		countsTable: countsTable,
+		logsTable:   logsTable,
+		name:        name,
+	}
+}
+
+// Load returns the stored watermark. A watermark stored without log counts, by a version
+// that didn't keep them, is ignored so that the next run counts all logs once.
+func (s *TableWatermarkStore) Load(ctx context.Context) (Watermark, error) {
+	watermark := Watermark{}
+
+	var verifiedAt sql.NullTime
+	var block sql.NullInt64
+	query := fmt.Sprintf("SELECT verified_at, block_number FROM %s WHERE name = ?", s.table)
+	err := s.db.QueryRowContext(ctx, query, s.name).Scan(&verifiedAt, &block)
+	if err == sql.ErrNoRows {
+		return watermark, nil
+	}
+	if err != nil {
+		return watermark, fmt.Errorf("error reading watermark from %s: %w", s.table, err)
+	}
+
+	var counted bool
+	query = fmt.Sprintf("SELECT count(*) > 0 FROM %s WHERE name = ?", s.countsTable)
+	if err := s.db.QueryRowContext(ctx, query, s.name).Scan(&counted); err != nil {
+		return watermark, fmt.Errorf("error reading log counts from %s: %w", s.countsTable, err)
+	}
+	if !counted {
+		return watermark, nil
+	}
+
+	watermark.VerifiedAt = verifiedAt.Time
+	watermark.Block = block.Int64
+	s.loaded = watermark
+
+	return watermark, nil
+}
+
+// Save adds the logs between the loaded and the new watermark to the log counts and stores
+// the watermark in one transaction, so that no logs are counted twice if either fails
+func (s *TableWatermarkStore) Save(ctx context.Context, watermark Watermark) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("error writing watermark to %s: %w", s.table, err)
+	}
+	defer tx.Rollback()
+
+	if s.loaded.IsZero() {
+		query := fmt.Sprintf("DELETE FROM %s WHERE name = ?", s.countsTable)
+		if _, err := tx.ExecContext(ctx, query, s.name); err != nil {
+			return fmt.Errorf("error writing log counts to %s: %w", s.countsTable, err)
+		}
+	}
+
+	counts := fmt.Sprintf(`MERGE INTO %s t
+USING (
+    SELECT ? AS name, address, count(*) AS log_count
+    FROM %s
+    WHERE block_number > ? AND block_number <= ?
+    GROUP BY address
+) s
+ON t.name = s.name AND t.address = s.address
+WHEN MATCHED THEN UPDATE SET
+    t.log_count = t.log_count + s.log_count
+WHEN NOT MATCHED THEN INSERT (name, address, log_count)
+    VALUES (s.name, s.address, s.log_count)`, s.countsTable, s.logsTable)
+
+	if _, err := tx.ExecContext(ctx, counts, s.name, s.loaded.Block, watermark.Block); err != nil {
+		return fmt.Errorf("error writing log counts to %s: %w", s.countsTable, err)
+	}
+
+	query := fmt.Sprintf(`MERGE INTO %s t
+USING (SELECT ? AS name, ?::TIMESTAMP_NTZ AS verified_at, ? AS block_number) s
+ON t.name = s.name
+WHEN MATCHED THEN UPDATE SET
+    t.verified_at = s.verified_at,
+    t.block_number = s.block_number,
+    t.updated_at = current_timestamp()
+WHEN NOT MATCHED THEN INSERT (name, verified_at, block_number, updated_at)
+    VALUES (s.name, s.verified_at, s.block_number, current_timestamp())`, s.table)
+
+	if _, err := tx.ExecContext(ctx, query, s.name, watermark.VerifiedAtString(), watermark.Block); err != nil {
+		return fmt.Errorf("error writing watermark to %s: %w", s.table, err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("error writing watermark to %s: %w", s.table, err)
+	}
+	s.loaded = watermark
+
+	return nil
+}
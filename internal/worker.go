@@ -0,0 +1,155 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type WorkerOptions struct {
+	// Workers is the number of goroutines polling and processing messages concurrently
+	Workers int
+	// WaitTime is how long a single receive call long polls for
+	WaitTime time.Duration
+	// VisibilityTimeout is how long a received message stays hidden. It is extended for as
+	// long as the message is being processed.
+	VisibilityTimeout time.Duration
+}
+
+// Worker is a long running alternative to the lambda handler. It keeps a single
+// snowflake connection pool for all messages it processes.
+type Worker struct {
+	queue    Queue
+	handler  *MessageHandler
+	options  WorkerOptions
+	inFlight int64
+	stopping int32
+}
+
+func NewWorker(queue Queue, handler *MessageHandler, options WorkerOptions) *Worker {
+	if options.Workers < 1 {
+		options.Workers = 1
+	}
+
+	if options.VisibilityTimeout < 2*time.Second {
+		options.VisibilityTimeout = 2 * time.Second
+	}
+
+	return &Worker{
+		queue:   queue,
+		handler: handler,
+		options: options,
+	}
+}
+
+// Run polls the queue until ctx is cancelled. Messages that are already being processed
+// when ctx is cancelled are allowed to finish before Run returns.
+func (w *Worker) Run(ctx context.Context) {
+	log.Printf("starting %d workers\n", w.options.Workers)
+
+	wg := new(sync.WaitGroup)
+	for i := 0; i < w.options.Workers; i++ {
+		wg.Add(1)
+		go func(id int) {
+			defer wg.Done()
+			w.poll(ctx, id)
+		}(i)
+	}
+
+	<-ctx.Done()
+	atomic.StoreInt32(&w.stopping, 1)
+	log.Printf("shutting down, waiting for %d in-flight messages to finish...\n", atomic.LoadInt64(&w.inFlight))
+
+	wg.Wait()
+	log.Println("all workers stopped")
+}
+
+func (w *Worker) poll(ctx context.Context, id int) {
+	for {
+		if ctx.Err() != nil {
+			return
+		}
+
+		deliveries, err := w.queue.Receive(ctx, 1, w.options.WaitTime)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			log.Printf("worker %d: %s\n", id, err)
+			time.Sleep(time.Second)
+			continue
+		}
+
+		for _, delivery := range deliveries {
+			// Messages are processed with a context detached from ctx so that a shutdown
+			// does not cancel queries that are already running
+			if err := w.process(context.Background(), delivery); err != nil {
+				log.Printf("ERROR: worker %d: %s\n", id, err)
+			}
+		}
+	}
+}
+
+func (w *Worker) process(ctx context.Context, delivery Delivery) error {
+	atomic.AddInt64(&w.inFlight, 1)
+	defer atomic.AddInt64(&w.inFlight, -1)
+
+	done := make(chan struct{})
+	defer close(done)
+
+	go w.extendVisibility(ctx, delivery, done)
+
+	return w.handler.Handle(ctx, w.queue, delivery)
+}
+
+// extendVisibility keeps the message hidden from other consumers until done is closed
+func (w *Worker) extendVisibility(ctx context.Context, delivery Delivery, done <-chan struct{}) {
+	ticker := time.NewTicker(w.options.VisibilityTimeout / 2)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			if err := w.queue.Extend(ctx, delivery, w.options.VisibilityTimeout); err != nil {
+				log.Println("ERROR:", err)
+			}
+		}
+	}
+}
+
+// HealthHandler serves /healthz, which reports whether the process is alive, and
+// /readyz, which reports whether the worker can take on messages.
+func (w *Worker) HealthHandler() http.Handler {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/healthz", func(rw http.ResponseWriter, r *http.Request) {
+		rw.WriteHeader(http.StatusOK)
+		fmt.Fprintf(rw, "ok in_flight=%d\n", atomic.LoadInt64(&w.inFlight))
+	})
+
+	mux.HandleFunc("/readyz", func(rw http.ResponseWriter, r *http.Request) {
+		if atomic.LoadInt32(&w.stopping) == 1 {
+			http.Error(rw, "shutting down", http.StatusServiceUnavailable)
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+		defer cancel()
+
+		if err := w.handler.DB.PingContext(ctx); err != nil {
+			http.Error(rw, fmt.Sprintf("snowflake unavailable: %s", err), http.StatusServiceUnavailable)
+			return
+		}
+
+		rw.WriteHeader(http.StatusOK)
+		fmt.Fprintln(rw, "ok")
+	})
+
+	return mux
+}
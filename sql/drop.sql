@@ -1,4 +1,4 @@
-SELECT table_name
+SELECT table_schema, table_name
 FROM information_schema.views
-WHERE table_schema = 'ETHEREUM_CONTRACTS'
-AND table_owner LIKE 'ABI_VIEW_MANAGER_%';
+WHERE table_schema = upper(?)
+AND table_owner LIKE 'ABI_VIEW_MANAGER_%'
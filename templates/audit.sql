@@ -0,0 +1,12 @@
+CREATE TABLE IF NOT EXISTS {{ .AuditTable }} (
+    run_id VARCHAR NOT NULL
+    ,contract_address VARCHAR
+    ,view_name VARCHAR NOT NULL
+    ,signature VARCHAR
+    ,ddl_hash VARCHAR
+    ,query_id VARCHAR
+    ,status VARCHAR NOT NULL
+    ,error VARCHAR
+    ,created_at TIMESTAMP_NTZ
+    ,updated_at TIMESTAMP_NTZ
+);
@@ -0,0 +1,6 @@
+CREATE TABLE IF NOT EXISTS {{ .BackupTable }} (
+    run_id VARCHAR NOT NULL
+    ,view_name VARCHAR NOT NULL
+    ,ddl VARCHAR NOT NULL
+    ,backed_up_at TIMESTAMP_NTZ
+);
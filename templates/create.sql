@@ -1,24 +1,67 @@
 with verified_contracts as (
-    select distinct contract_address, abi
-    from ethereum.deployed_contract_metadata
+    select contract_address, abi
+    {{ if .IncrementalRun }}, max({{ .WatermarkColumn }}) as verified_at{{ end }}
+    from {{ .ContractsTable }}
     {{ $length := len .ContractList }} {{ if ne $length 0 }}
         where contract_address = '0x00'
             {{ range $contractAddress := .ContractList }}
             or contract_address = '{{ $contractAddress }}'
             {{ end }}
     {{ end }}
+    group by contract_address, abi
 )
 
 {{ $length := len .ContractList }} {{ if ne $length 0 }}
-    select * from verified_contracts;
+    select contract_address, abi from verified_contracts;
+    {{ else if .IncrementalRun }}
+        -- contracts verified or updated since the last run that have enough logs, and
+        -- contracts whose new logs added to the log counts stored up to the watermark
+        -- crossed the log threshold
+        select distinct contract_address, abi
+        from (
+            select
+                l.address as contract_address,
+                c.abi as abi
+            from {{ .LogsTable }} l
+            join verified_contracts c on l.address = c.contract_address
+            where c.verified_at > '{{ .Watermark.VerifiedAtString }}'::timestamp_ntz
+            group by 1, 2
+            having count(*) >= {{ .Count }}
+            {{ if .LogCountsTable }}
+
+            union all
+
+            select
+                l.address as contract_address,
+                c.abi as abi
+            from (
+                select address, count(*) as log_count
+                from {{ .LogsTable }}
+                where block_number > {{ .Watermark.Block }}
+                    and block_number <= {{ .NextWatermark.Block }}
+                group by 1
+            ) l
+            join verified_contracts c on l.address = c.contract_address
+            left join {{ .LogCountsTable }} s on s.name = '{{ .Namespace }}' and s.address = l.address
+            where coalesce(s.log_count, 0) < {{ .Count }}
+                and coalesce(s.log_count, 0) + l.log_count >= {{ .Count }}
+            {{ end }}
+        )
+        {{ if .AddLimit }}
+        limit {{ .Limit }}
+        {{ end }}
+        ;
     {{ else }}
         select
             l.address as contract_address,
             c.abi as abi
-        from ethereum.logs l
+        from {{ .LogsTable }} l
         join verified_contracts c on l.address = c.contract_address
         group by 1, 2
         having count(*) >= {{ .Count }}
+        {{ if .OrderByActivity }}
+        order by count(*) desc
+        {{ end }}
         {{ if .AddLimit }}
         limit {{ .Limit }}
         {{ end }}
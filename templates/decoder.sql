@@ -0,0 +1,253 @@
+CREATE OR REPLACE FUNCTION {{ .DecoderUDF }}(DATA VARCHAR, TOPICS VARCHAR, INPUTS VARIANT, KIND VARCHAR, SUCCESS BOOLEAN)
+    RETURNS VARIANT
+    LANGUAGE JAVASCRIPT
+    COMMENT = '{{ .DecoderComment }}'
+    AS
+$$
+// Decodes the ABI encoded inputs of an event log or method call into an object keyed by
+// input name. DATA is the log data or call input, TOPICS the comma separated log topics.
+// INPUTS is the list of {name, type, indexed} of the event or method. Integers that don't
+// fit into a double are returned as decimal strings, tuples as arrays. Returns null if the
+// input can't be decoded, which is expected for some failed calls (SUCCESS false).
+
+function strip0x(hex) {
+    if (hex === null || hex === undefined) {
+        return '';
+    }
+    return hex.substring(0, 2).toLowerCase() === '0x' ? hex.substring(2) : hex;
+}
+
+function splitTopLevel(value) {
+    var parts = [];
+    var depth = 0;
+    var start = 0;
+    for (var i = 0; i < value.length; i++) {
+        var c = value.charAt(i);
+        if (c === '(') {
+            depth++;
+        } else if (c === ')') {
+            depth--;
+        } else if (c === ',' && depth === 0) {
+            parts.push(value.substring(start, i));
+            start = i + 1;
+        }
+    }
+    if (value.length > 0) {
+        parts.push(value.substring(start));
+    }
+    return parts;
+}
+
+function parseType(type) {
+    if (type.charAt(type.length - 1) === ']') {
+        var open = type.lastIndexOf('[');
+        var size = type.substring(open + 1, type.length - 1);
+        return {kind: 'array', elem: parseType(type.substring(0, open)), length: size === '' ? -1 : parseInt(size, 10)};
+    }
+    if (type.charAt(0) === '(') {
+        return {kind: 'tuple', components: splitTopLevel(type.substring(1, type.length - 1)).map(parseType)};
+    }
+    var match = /^(u?int|bytes|u?fixed)(\d*)/.exec(type);
+    if (match) {
+        if (match[1] === 'bytes') {
+            return match[2] === '' ? {kind: 'bytes'} : {kind: 'fixedbytes', size: parseInt(match[2], 10)};
+        }
+        return {kind: match[1] === 'ufixed' ? 'uint' : (match[1] === 'fixed' ? 'int' : match[1]), size: match[2] === '' ? 256 : parseInt(match[2], 10)};
+    }
+    return {kind: type};
+}
+
+function isDynamic(node) {
+    switch (node.kind) {
+    case 'string':
+    case 'bytes':
+        return true;
+    case 'array':
+        return node.length < 0 || isDynamic(node.elem);
+    case 'tuple':
+        return node.components.some(isDynamic);
+    default:
+        return false;
+    }
+}
+
+function headSize(node) {
+    if (isDynamic(node)) {
+        return 32;
+    }
+    if (node.kind === 'array') {
+        return node.length * headSize(node.elem);
+    }
+    if (node.kind === 'tuple') {
+        return node.components.reduce(function (sum, c) { return sum + headSize(c); }, 0);
+    }
+    return 32;
+}
+
+function word(hex, pos) {
+    var w = hex.substring(pos * 2, pos * 2 + 64);
+    if (w.length < 64) {
+        throw new Error('data too short at byte ' + pos);
+    }
+    return w;
+}
+
+var hexLimit = 0x7fffffff;
+
+function toLength(w) {
+    var n = parseInt(w, 16);
+    if (!isFinite(n) || n > hexLimit) {
+        throw new Error('invalid offset or length 0x' + w);
+    }
+    return n;
+}
+
+function hexToDecimal(hex) {
+    var digits = [0];
+    for (var i = 0; i < hex.length; i++) {
+        var carry = parseInt(hex.charAt(i), 16);
+        for (var j = 0; j < digits.length; j++) {
+            var v = digits[j] * 16 + carry;
+            digits[j] = v % 10;
+            carry = Math.floor(v / 10);
+        }
+        while (carry > 0) {
+            digits.push(carry % 10);
+            carry = Math.floor(carry / 10);
+        }
+    }
+    return digits.reverse().join('');
+}
+
+function negate(hex) {
+    var out = [];
+    for (var i = 0; i < hex.length; i++) {
+        out.push((15 - parseInt(hex.charAt(i), 16)).toString(16));
+    }
+    for (var k = out.length - 1; k >= 0; k--) {
+        var d = parseInt(out[k], 16) + 1;
+        if (d < 16) {
+            out[k] = d.toString(16);
+            break;
+        }
+        out[k] = '0';
+    }
+    return out.join('');
+}
+
+function decodeInteger(w, node) {
+    var negative = node.kind === 'int' && parseInt(w.charAt(0), 16) >= 8;
+    var decimal = negative ? '-' + hexToDecimal(negate(w)) : hexToDecimal(w);
+    return node.size <= 48 ? Number(decimal) : decimal;
+}
+
+function decodeUtf8(hex) {
+    try {
+        return decodeURIComponent(hex.replace(/(..)/g, '%$1'));
+    } catch (e) {
+        return '0x' + hex;
+    }
+}
+
+function decodeStatic(node, w) {
+    switch (node.kind) {
+    case 'address':
+        return '0x' + w.substring(24);
+    case 'bool':
+        return /[^0]/.test(w);
+    case 'uint':
+    case 'int':
+        return decodeInteger(w, node);
+    case 'fixedbytes':
+        return '0x' + w.substring(0, node.size * 2);
+    case 'function':
+        return '0x' + w.substring(0, 48);
+    default:
+        throw new Error('unsupported type ' + node.kind);
+    }
+}
+
+function decodeList(nodes, hex, start) {
+    var values = [];
+    var pos = start;
+    for (var i = 0; i < nodes.length; i++) {
+        values.push(decode(nodes[i], hex, start, pos));
+        pos += headSize(nodes[i]);
+    }
+    return values;
+}
+
+function decode(node, hex, base, pos) {
+    if (isDynamic(node)) {
+        return decodeAt(node, hex, base + toLength(word(hex, pos)));
+    }
+    return decodeAt(node, hex, pos);
+}
+
+function decodeAt(node, hex, start) {
+    var i;
+    var nodes;
+    switch (node.kind) {
+    case 'string':
+    case 'bytes':
+        var length = toLength(word(hex, start));
+        var data = hex.substring((start + 32) * 2, (start + 32 + length) * 2);
+        if (data.length < length * 2) {
+            throw new Error('data too short for ' + node.kind + ' at byte ' + start);
+        }
+        return node.kind === 'string' ? decodeUtf8(data) : '0x' + data;
+    case 'array':
+        var count = node.length;
+        var contentStart = start;
+        if (count < 0) {
+            count = toLength(word(hex, start));
+            contentStart = start + 32;
+        }
+        nodes = [];
+        for (i = 0; i < count; i++) {
+            nodes.push(node.elem);
+        }
+        return decodeList(nodes, hex, contentStart);
+    case 'tuple':
+        return decodeList(node.components, hex, start);
+    default:
+        return decodeStatic(node, word(hex, start));
+    }
+}
+
+function decodeInputs(data, topics, inputs, kind) {
+    var result = {};
+    var hex = strip0x(data);
+    if (kind === 'method') {
+        hex = hex.substring(8);
+    }
+
+    var topicList = kind === 'event' && topics ? topics.split(',') : [];
+    var topicIndex = 1;
+    var unindexed = [];
+    var unindexedNames = [];
+    for (var i = 0; i < inputs.length; i++) {
+        var node = parseType(inputs[i].type);
+        if (kind === 'event' && inputs[i].indexed) {
+            var topic = strip0x((topicList[topicIndex++] || '').trim());
+            // Indexed dynamic values are only stored as their keccak256 hash
+            result[inputs[i].name] = isDynamic(node) || node.kind === 'array' || node.kind === 'tuple' ? '0x' + topic : decodeStatic(node, word(topic, 0));
+            continue;
+        }
+        unindexed.push(node);
+        unindexedNames.push(inputs[i].name);
+    }
+
+    var values = decodeList(unindexed, hex, 0);
+    for (var j = 0; j < values.length; j++) {
+        result[unindexedNames[j]] = values[j];
+    }
+    return result;
+}
+
+try {
+    return decodeInputs(DATA, TOPICS, INPUTS, KIND);
+} catch (e) {
+    return null;
+}
+$$;
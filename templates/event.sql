@@ -1,4 +1,5 @@
-CREATE OR REPLACE VIEW ethereum_contracts.{{ .Namespace }}_{{ .ContractAddress }}_evt_{{ .Name }}
+CREATE OR REPLACE VIEW {{ .TargetSchema }}.{{ .Namespace }}_{{ .ContractAddress }}_evt_{{ .Name }}
+    COMMENT = '{{ .Signature }}'
     AS
         WITH q as (
             SELECT
@@ -6,8 +7,8 @@ CREATE OR REPLACE VIEW ethereum_contracts.{{ .Namespace }}_{{ .ContractAddress }
                 ,log_index as evt_index
                 ,block_number as evt_block_number
                 ,transaction_hash as evt_tx_hash
-                ,ethereum_contracts.decode_abi_input_prod(data, topics, parse_json('{{ .InputsJson }}'), 'event', true) as val
-            FROM ethereum.logs
+                ,{{ .DecoderUDF }}(data, topics, parse_json('{{ .InputsJson }}'), 'event', true) as val
+            FROM {{ .LogsTable }}
             WHERE address = '{{ .ContractAddress }}' AND substring(topics, 1, 66) = '{{ .SigHash }}'
         )
         SELECT
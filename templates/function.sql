@@ -1,4 +1,5 @@
-CREATE OR REPLACE VIEW ethereum_contracts.{{ .Namespace }}_{{ .ContractAddress }}_fn_{{ .Name }}
+CREATE OR REPLACE VIEW {{ .TargetSchema }}.{{ .Namespace }}_{{ .ContractAddress }}_fn_{{ .Name }}
+    COMMENT = '{{ .Signature }}'
     AS
         WITH q1 AS (
             SELECT
@@ -8,7 +9,7 @@ CREATE OR REPLACE VIEW ethereum_contracts.{{ .Namespace }}_{{ .ContractAddress }
                 ,transaction_index as txn_index
                 ,null as error
                 ,input
-            FROM ethereum.transactions
+            FROM {{ .TransactionsTable }}
             WHERE to_address='{{ .ContractAddress }}' AND substring(input, 1, 10)='{{ .MethodIdHash }}'
 
             UNION
@@ -20,7 +21,7 @@ CREATE OR REPLACE VIEW ethereum_contracts.{{ .Namespace }}_{{ .ContractAddress }
                 ,transaction_index as txn_index
                 ,error
                 ,input
-            FROM ethereum.traces
+            FROM {{ .TracesTable }}
             WHERE to_address='{{ .ContractAddress }}' AND substring(input, 1, 10)='{{ .MethodIdHash }}'
         )
 
@@ -35,7 +36,7 @@ CREATE OR REPLACE VIEW ethereum_contracts.{{ .Namespace }}_{{ .ContractAddress }
         ,q3 AS (
             SELECT
                 *
-                ,ethereum_contracts.decode_abi_input_prod(input, '', parse_json('{{ .InputsJson }}'), 'method', success) AS val
+                ,{{ .DecoderUDF }}(input, '', parse_json('{{ .InputsJson }}'), 'method', success) AS val
             FROM q2
             WHERE row_num = 1
         )
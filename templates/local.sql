@@ -0,0 +1,41 @@
+{{ range .LocalSchemas }}
+CREATE SCHEMA IF NOT EXISTS {{ . }};
+{{ end }}
+
+CREATE OR REPLACE TABLE {{ .LogsTable }} (
+    log_index BIGINT
+    ,transaction_hash VARCHAR
+    ,transaction_index BIGINT
+    ,block_number BIGINT
+    ,address VARCHAR
+    ,data VARCHAR
+    ,topics VARCHAR
+);
+
+CREATE OR REPLACE TABLE {{ .TransactionsTable }} (
+    hash VARCHAR
+    ,block_number BIGINT
+    ,transaction_index BIGINT
+    ,from_address VARCHAR
+    ,to_address VARCHAR
+    ,input VARCHAR
+);
+
+CREATE OR REPLACE TABLE {{ .TracesTable }} (
+    transaction_hash VARCHAR
+    ,transaction_index BIGINT
+    ,block_number BIGINT
+    ,from_address VARCHAR
+    ,to_address VARCHAR
+    ,input VARCHAR
+    ,error VARCHAR
+);
+
+CREATE OR REPLACE TABLE {{ .DecodedValuesTable }} (
+    kind VARCHAR
+    ,data VARCHAR
+    ,topics VARCHAR
+    ,inputs VARCHAR
+    ,name VARCHAR
+    ,value VARCHAR
+);
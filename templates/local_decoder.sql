@@ -0,0 +1,17 @@
+CREATE OR REPLACE TABLE {{ .DecodedInputsTable }} AS
+    SELECT
+        kind
+        ,data
+        ,topics
+        ,inputs
+        ,map(list(name), list(value)) AS val
+    FROM {{ .DecodedValuesTable }}
+    GROUP BY kind, data, topics, inputs;
+
+CREATE OR REPLACE MACRO parse_json(s) AS s;
+
+CREATE OR REPLACE MACRO {{ .DecoderUDF }}(in_data, in_topics, in_inputs, in_kind, in_success) AS (
+    SELECT val
+    FROM {{ .DecodedInputsTable }} d
+    WHERE d.kind = in_kind AND d.data = in_data AND d.topics = in_topics AND d.inputs = in_inputs
+);
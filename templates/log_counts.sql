@@ -0,0 +1,5 @@
+CREATE TABLE IF NOT EXISTS {{ .LogCountsTable }} (
+    name VARCHAR NOT NULL
+    ,address VARCHAR NOT NULL
+    ,log_count NUMBER NOT NULL
+);
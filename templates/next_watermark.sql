@@ -0,0 +1,3 @@
+select
+    (select max({{ .WatermarkColumn }}) from {{ .ContractsTable }}) as verified_at,
+    (select max(block_number) from {{ .LogsTable }}) as block_number;
@@ -0,0 +1,6 @@
+CREATE TABLE IF NOT EXISTS {{ .WatermarkTable }} (
+    name VARCHAR NOT NULL
+    ,verified_at TIMESTAMP_NTZ
+    ,block_number NUMBER
+    ,updated_at TIMESTAMP_NTZ
+);
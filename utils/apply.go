@@ -0,0 +1,203 @@
+package utils
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+
+	"github.com/credmark/abi-sql-view-generator/internal"
+	sf "github.com/snowflakedb/gosnowflake"
+)
+
+// ApplyPlan executes the views of a saved plan, either through the queue or directly on
+// snowflake. It refuses to run if any view the plan touches changed since it was created.
+func ApplyPlan(ctx context.Context, options *Options, path string) error {
+	file, err := internal.ReadPlanFile(path)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("applying plan %s created %s by producer version %s with %d views\n", path, file.CreatedAt.Format("2006-01-02 15:04:05"), file.ProducerVersion, len(file.Entries))
+
+	db, err := sql.Open("snowflake", options.DSN)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	drifted, err := file.Drift(ctx, internal.NewViewCatalog(db, file.Namespace))
+	if err != nil {
+		return err
+	}
+
+	if len(drifted) > 0 {
+		for _, entry := range drifted {
+			log.Printf("DRIFT: view=%s action=%s\n", entry.ViewName, entry.Action)
+		}
+		return fmt.Errorf("%d views changed since plan %s was created, create a new plan", len(drifted), path)
+	}
+
+	if options.DryRun {
+		log.Println("running in dry-run mode. The plan will not be applied")
+		return nil
+	}
+
+	checkDecoder(ctx, db, options)
+
+	contracts := planContracts(file)
+
+	if options.ApplyDirect {
+		return applyDirect(ctx, db, options, sf.NewUUID().String(), contracts)
+	}
+
+	return applyQueued(ctx, db, options, file, contracts)
+}
+
+type planContract struct {
+	contractAddress string
+	views           []internal.ViewEntry
+}
+
+// planContracts groups the entries of the plan by contract
+func planContracts(file *internal.PlanFile) []planContract {
+	byContract := make(map[string][]internal.ViewEntry)
+	for _, entry := range file.Entries {
+		byContract[entry.ContractAddress] = append(byContract[entry.ContractAddress], entry.ViewEntry)
+	}
+
+	contracts := make([]planContract, 0, len(byContract))
+	for contractAddress, views := range byContract {
+		contracts = append(contracts, planContract{contractAddress: contractAddress, views: views})
+	}
+
+	sort.Slice(contracts, func(i, j int) bool { return contracts[i].contractAddress < contracts[j].contractAddress })
+
+	return contracts
+}
+
+// applyDirect executes the statements of each contract one at a time, with
+// options.Concurrency contracts in parallel. The views a contract replaces or drops are
+// backed up under the run ID first.
+func applyDirect(ctx context.Context, db *sql.DB, options *Options, runID string, contracts []planContract) error {
+	log.Printf("applying as run %s\n", runID)
+	backup := newDDLBackup(ctx, db, options)
+
+	jobs := make(chan planContract)
+	var mu sync.Mutex
+	failed := 0
+
+	workers := options.Concurrency
+	if workers < 1 {
+		workers = 1
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for contract := range jobs {
+				if err := internal.BackupChangedViews(ctx, db, backup, runID, contract.views); err != nil {
+					log.Printf("FAILED: contractAddress=%s error=error backing up views: %s\n", contract.contractAddress, err)
+					mu.Lock()
+					failed += len(contract.views)
+					mu.Unlock()
+					continue
+				}
+
+				for _, result := range internal.ExecuteStatements(ctx, db, contract.views) {
+					if result.Error != nil {
+						log.Printf("FAILED: contractAddress=%s view=%s queryID=%s error=%s\n", contract.contractAddress, result.ViewName, result.QueryID, result.Error.Error())
+						mu.Lock()
+						failed += 1
+						mu.Unlock()
+						continue
+					}
+
+					log.Printf("OK: contractAddress=%s view=%s queryID=%s\n", contract.contractAddress, result.ViewName, result.QueryID)
+				}
+			}
+		}()
+	}
+
+	for _, contract := range contracts {
+		jobs <- contract
+	}
+	close(jobs)
+	wg.Wait()
+
+	if failed > 0 {
+		return fmt.Errorf("%d statements of the plan failed", failed)
+	}
+
+	return nil
+}
+
+// applyQueued publishes the statements of each contract to the queue like a regular run
+func applyQueued(ctx context.Context, db *sql.DB, options *Options, file *internal.PlanFile, contracts []planContract) error {
+	runID := sf.NewUUID().String()
+	log.Printf("queueing plan as run %s\n", runID)
+
+	auditLog := newAuditLog(ctx, db, options)
+	payloadStore := newPayloadStore(options)
+	router := newLaneRouter(ctx, options, nil)
+
+	var mu sync.Mutex
+	failed := 0
+	done := func(contractAddress string, views []internal.ViewEntry) func(error) {
+		return func(err error) {
+			if auditLog != nil {
+				if auditErr := auditLog.Record(ctx, auditRecords(runID, contractAddress, views, err)...); auditErr != nil {
+					log.Println("ERROR:", auditErr)
+				}
+			}
+
+			if err != nil {
+				log.Printf("ERROR: contractAddress=%s error=%s\n", contractAddress, err)
+				mu.Lock()
+				failed += 1
+				mu.Unlock()
+			}
+		}
+	}
+
+	for _, contract := range contracts {
+		header := internal.QueueMessage{
+			RunID:           runID,
+			Chain:           file.Chain,
+			Namespace:       file.Namespace,
+			ContractAddress: contract.contractAddress,
+		}
+
+		messages, err := buildMessages(ctx, payloadStore, header, contract.views)
+		if err != nil {
+			done(contract.contractAddress, contract.views)(err)
+			continue
+		}
+
+		pool, _ := router.route(ctx, contract.contractAddress, contract.views)
+		for _, message := range messages {
+			pool.Publish(message.message, done(contract.contractAddress, message.statements))
+		}
+	}
+
+	router.close()
+
+	if auditLog != nil {
+		if err := auditLog.Flush(ctx); err != nil {
+			log.Println("ERROR:", err)
+		}
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d messages of the plan could not be queued", failed)
+	}
+
+	log.Printf("%d contracts of the plan queued\n", len(contracts))
+
+	return nil
+}
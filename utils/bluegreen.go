@@ -0,0 +1,233 @@
+package utils
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+
+	"github.com/credmark/abi-sql-view-generator/internal"
+)
+
+// stagingDeployment builds the views of a run in a clone of the target schema and swaps the
+// clone with the target schema once every view was created and validated
+type stagingDeployment struct {
+	db      *sql.DB
+	target  string
+	staging string
+
+	mu sync.Mutex
+	// expected holds the staging names of all generated views
+	expected []string
+	// processed holds the contracts whose views were generated, only their orphaned views
+	// are dropped from the staging schema
+	processed map[string]bool
+	// skipped holds the contracts skipped for their ABI, their views are kept as they are in
+	// the clone of the target schema
+	skipped []string
+	// incomplete holds the contracts that failed before their views were generated, the
+	// schemas aren't swapped as their views might be missing or outdated
+	incomplete []string
+	// created maps the staging names of the views created by the run to their definition hash
+	created map[string]string
+	failed  int
+}
+
+// newStagingDeployment clones the target schema into the staging schema. It returns nil
+// unless options.BlueGreen is set.
+func newStagingDeployment(ctx context.Context, db *sql.DB, options *Options) *stagingDeployment {
+	if !options.BlueGreen || options.DryRun {
+		return nil
+	}
+
+	if options.Plan {
+		log.Fatal("blue/green deployments can't be combined with plan mode")
+	}
+
+	if strings.EqualFold(options.Staging(), options.TargetSchema) {
+		log.Fatalf("the staging schema can't be the target schema %s", options.TargetSchema)
+	}
+
+	if err := internal.CloneSchema(ctx, db, options.TargetSchema, options.Staging()); err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("cloned %s into staging schema %s\n", options.TargetSchema, options.Staging())
+
+	return &stagingDeployment{
+		db:         db,
+		target:     options.TargetSchema,
+		staging:    options.Staging(),
+		expected:   make([]string, 0),
+		processed:  make(map[string]bool),
+		skipped:    make([]string, 0),
+		incomplete: make([]string, 0),
+		created:    make(map[string]string),
+	}
+}
+
+// expect records the generated views of a contract, which have to exist in the staging
+// schema before it is swapped
+func (d *stagingDeployment) expect(contractAddress string, views []internal.ViewEntry) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.processed[strings.ToLower(contractAddress)] = true
+	for _, view := range views {
+		d.expected = append(d.expected, internal.RetargetView(view, d.staging).ViewName)
+	}
+}
+
+// skip records a contract skipped because its ABI can't be parsed or has names that are too
+// long. Its views in the staging schema are left alone and don't hold back the swap.
+func (d *stagingDeployment) skip(contractAddress string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.skipped = append(d.skipped, contractAddress)
+}
+
+// fail records a contract whose views couldn't be generated because of an error, or an
+// empty address if the contracts couldn't all be read. The schemas aren't swapped then.
+func (d *stagingDeployment) fail(contractAddress string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.incomplete = append(d.incomplete, contractAddress)
+}
+
+// create executes the statements of a contract in the staging schema
+func (d *stagingDeployment) create(ctx context.Context, contractAddress string, views []internal.ViewEntry) error {
+	staged := make([]internal.ViewEntry, len(views))
+	for idx, view := range views {
+		staged[idx] = internal.RetargetView(view, d.staging)
+	}
+
+	failed := 0
+	for idx, result := range internal.ExecuteStatements(ctx, d.db, staged) {
+		if result.Error != nil {
+			log.Printf("FAILED: contractAddress=%s view=%s queryID=%s error=%s\n", contractAddress, result.ViewName, result.QueryID, result.Error.Error())
+			failed += 1
+			continue
+		}
+
+		d.mu.Lock()
+		if internal.ViewName(staged[idx].DDL) != "" {
+			d.created[result.ViewName] = internal.DefinitionHash(staged[idx].DDL)
+		}
+		d.mu.Unlock()
+	}
+
+	if failed > 0 {
+		d.mu.Lock()
+		d.failed += failed
+		d.mu.Unlock()
+		return fmt.Errorf("%d of %d statements failed in staging schema %s", failed, len(views), d.staging)
+	}
+
+	return nil
+}
+
+// dropOrphaned drops the views of the staging schema that are no longer generated for a
+// contract of the run. Views of contracts that were skipped are kept.
+func (d *stagingDeployment) dropOrphaned(ctx context.Context, entries []internal.PlanEntry) error {
+	views := make([]internal.ViewEntry, 0)
+	for _, entry := range entries {
+		if entry.Action != internal.ViewActionOrphaned || !d.processed[strings.ToLower(entry.ContractAddress)] {
+			continue
+		}
+		view := internal.RetargetView(entry.ViewEntry, d.staging)
+		view.DDL = internal.DropViewDDL(view.ViewName)
+		views = append(views, view)
+	}
+
+	if len(views) == 0 {
+		return nil
+	}
+
+	log.Printf("dropping %d orphaned views from staging schema %s\n", len(views), d.staging)
+	return d.create(ctx, "", views)
+}
+
+// validate checks that no contract failed, that no statement failed, that every generated
+// view exists in the staging schema and that the views created by the run have their
+// generated definition
+func (d *stagingDeployment) validate(ctx context.Context, namespace string) error {
+	if len(d.incomplete) > 0 {
+		return fmt.Errorf("%d contracts failed, their views may be missing from staging schema %s", len(d.incomplete), d.staging)
+	}
+
+	if len(d.skipped) > 0 {
+		log.Printf("%d contracts were skipped for their ABI, their existing views are kept in staging schema %s\n", len(d.skipped), d.staging)
+	}
+
+	if d.failed > 0 {
+		return fmt.Errorf("%d statements failed in staging schema %s", d.failed, d.staging)
+	}
+
+	catalog := internal.NewViewCatalog(d.db, namespace)
+	missing, mismatched := 0, 0
+	for _, viewName := range d.expected {
+		state, err := catalog.State(ctx, viewName)
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case state == "":
+			log.Printf("MISSING: view=%s\n", viewName)
+			missing += 1
+		case d.created[viewName] != "" && d.created[viewName] != state:
+			log.Printf("MISMATCH: view=%s\n", viewName)
+			mismatched += 1
+		}
+	}
+
+	if missing > 0 || mismatched > 0 {
+		return fmt.Errorf("staging schema %s has %d missing and %d mismatched of %d views", d.staging, missing, mismatched, len(d.expected))
+	}
+
+	log.Printf("validated %d views in staging schema %s\n", len(d.expected), d.staging)
+
+	return nil
+}
+
+// finish validates the staging schema and swaps it with the target schema, which leaves
+// the previous views in the staging schema
+func (d *stagingDeployment) finish(ctx context.Context, namespace string) error {
+	if err := d.validate(ctx, namespace); err != nil {
+		return fmt.Errorf("%w, %s was left unchanged", err, d.target)
+	}
+
+	if err := internal.SwapSchemas(ctx, d.db, d.target, d.staging); err != nil {
+		return err
+	}
+
+	log.Printf("swapped %s with %s, the previous views are kept in %s until the next deployment\n", d.target, d.staging, d.staging)
+
+	return nil
+}
+
+// RollbackDeployment swaps the target schema with the staging schema again, restoring the
+// views from before the last blue/green deployment
+func RollbackDeployment(ctx context.Context, options *Options) error {
+	db, err := sql.Open("snowflake", options.DSN)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	if options.DryRun {
+		log.Printf("running in dry-run mode. %s would be swapped with %s\n", options.TargetSchema, options.Staging())
+		return nil
+	}
+
+	if err := internal.SwapSchemas(ctx, db, options.TargetSchema, options.Staging()); err != nil {
+		return err
+	}
+
+	log.Printf("swapped %s with %s\n", options.TargetSchema, options.Staging())
+
+	return nil
+}
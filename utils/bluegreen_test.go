@@ -0,0 +1,182 @@
+package utils
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/credmark/abi-sql-view-generator/internal"
+)
+
+// fakeDB is a database/sql driver that answers the information_schema.views query of the
+// view catalog with a fixed set of views and records every other statement
+type fakeDB struct {
+	mu sync.Mutex
+	// views maps upper cased view names to their definition
+	views map[string]string
+	execs []string
+}
+
+func (f *fakeDB) Open(name string) (driver.Conn, error) {
+	return &fakeConn{db: f}, nil
+}
+
+func (f *fakeDB) executed() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return append([]string{}, f.execs...)
+}
+
+type fakeConn struct {
+	db *fakeDB
+}
+
+func (c *fakeConn) Prepare(query string) (driver.Stmt, error) {
+	return nil, fmt.Errorf("prepared statements are not supported")
+}
+
+func (c *fakeConn) Close() error {
+	return nil
+}
+
+func (c *fakeConn) Begin() (driver.Tx, error) {
+	return nil, fmt.Errorf("transactions are not supported")
+}
+
+func (c *fakeConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	c.db.mu.Lock()
+	defer c.db.mu.Unlock()
+
+	c.db.execs = append(c.db.execs, query)
+	return driver.RowsAffected(0), nil
+}
+
+func (c *fakeConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	c.db.mu.Lock()
+	defer c.db.mu.Unlock()
+
+	rows := &fakeRows{}
+	for name, definition := range c.db.views {
+		rows.values = append(rows.values, []driver.Value{name, definition, ""})
+	}
+
+	return rows, nil
+}
+
+type fakeRows struct {
+	values [][]driver.Value
+}
+
+func (r *fakeRows) Columns() []string {
+	return []string{"table_name", "view_definition", "comment"}
+}
+
+func (r *fakeRows) Close() error {
+	return nil
+}
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if len(r.values) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.values[0])
+	r.values = r.values[1:]
+
+	return nil
+}
+
+// openFakeDB registers a fake driver holding the views and opens it
+func openFakeDB(t *testing.T, views map[string]string) (*sql.DB, *fakeDB) {
+	fake := &fakeDB{views: views}
+	name := "fake-" + strings.ReplaceAll(t.Name(), "/", "-")
+	sql.Register(name, fake)
+
+	db, err := sql.Open(name, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	return db, fake
+}
+
+func TestStagingDeploymentFinish(t *testing.T) {
+	view := internal.ViewEntry{
+		ViewName: "ethereum_contracts.ns_0xabc_evt_transfer",
+		DDL:      "CREATE OR REPLACE VIEW ethereum_contracts.ns_0xabc_evt_transfer AS SELECT 1;",
+	}
+
+	tests := []struct {
+		name     string
+		record   func(d *stagingDeployment)
+		wantSwap bool
+	}{
+		{
+			name: "all contracts processed",
+			record: func(d *stagingDeployment) {
+				d.expect("0xabc", []internal.ViewEntry{view})
+			},
+			wantSwap: true,
+		},
+		{
+			name: "contract skipped for its ABI",
+			record: func(d *stagingDeployment) {
+				d.expect("0xabc", []internal.ViewEntry{view})
+				d.skip("0xdef")
+			},
+			wantSwap: true,
+		},
+		{
+			name: "contract failed",
+			record: func(d *stagingDeployment) {
+				d.expect("0xabc", []internal.ViewEntry{view})
+				d.fail("0xdef")
+			},
+		},
+		{
+			name: "contracts couldn't all be read",
+			record: func(d *stagingDeployment) {
+				d.expect("0xabc", []internal.ViewEntry{view})
+				d.fail("")
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			db, fake := openFakeDB(t, map[string]string{
+				"NS_0XABC_EVT_TRANSFER": "CREATE OR REPLACE VIEW ethereum_contracts_staging.ns_0xabc_evt_transfer AS SELECT 1;",
+				"NS_0XDEF_EVT_APPROVAL": "CREATE OR REPLACE VIEW ethereum_contracts_staging.ns_0xdef_evt_approval AS SELECT 2;",
+			})
+
+			options := NewOptions("", "ns", "", "", "", "", false, false, 0, 0, "")
+			options.BlueGreen = true
+			deployment := newStagingDeployment(context.Background(), db, options)
+			test.record(deployment)
+
+			err := deployment.finish(context.Background(), options.Namespace)
+			if test.wantSwap && err != nil {
+				t.Fatal(err)
+			}
+			if !test.wantSwap && err == nil {
+				t.Fatal("finished a deployment with a failed contract")
+			}
+
+			swapped := false
+			for _, query := range fake.executed() {
+				if strings.HasPrefix(query, "ALTER SCHEMA ethereum_contracts SWAP WITH ethereum_contracts_staging") {
+					swapped = true
+				}
+			}
+			if swapped != test.wantSwap {
+				t.Errorf("got swapped %v, want %v: %v", swapped, test.wantSwap, fake.executed())
+			}
+		})
+	}
+}
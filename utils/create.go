@@ -6,14 +6,19 @@ import (
 	"database/sql"
 	"fmt"
 	"log"
+	"os"
 	"path/filepath"
 	"strings"
 	"sync"
 	"text/template"
+	"time"
 
+	awssdk "github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
 	"github.com/credmark/abi-sql-view-generator/internal"
 	"github.com/credmark/abi-sql-view-generator/internal/cloud/aws"
 	"github.com/ethereum/go-ethereum/accounts/abi"
+	sf "github.com/snowflakedb/gosnowflake"
 )
 
 type SnowflakeError struct {
@@ -48,13 +53,266 @@ func getCreateQuery(options *Options) string {
 	return buffer.String()
 }
 
+func getAuditTableQuery(options *Options) string {
+	fpath, err := filepath.Abs("templates/audit.sql")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	t, err := template.New("audit.sql").ParseFiles(fpath)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	buffer := bytes.Buffer{}
+	err = t.Execute(&buffer, options)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	return buffer.String()
+}
+
+// newAuditLog creates the audit table if needed. It returns nil when auditing is disabled.
+func newAuditLog(ctx context.Context, db *sql.DB, options *Options) *internal.AuditLog {
+	if options.AuditTable == "" || options.DryRun {
+		return nil
+	}
+
+	if _, err := db.ExecContext(ctx, getAuditTableQuery(options)); err != nil {
+		log.Fatal("error creating audit table:", err)
+	}
+
+	return internal.NewAuditLog(db, options.AuditTable)
+}
+
+func auditRecords(runID string, contractAddress string, statements []internal.ViewEntry, err error) []internal.AuditRecord {
+	records := make([]internal.AuditRecord, len(statements))
+	for idx, statement := range statements {
+		records[idx] = internal.AuditRecord{
+			RunID:           runID,
+			ContractAddress: contractAddress,
+			ViewName:        statement.ViewName,
+			Signature:       statement.Signature,
+			DDLHash:         internal.HashDDL(statement.DDL),
+			Status:          internal.AuditStatusQueued,
+		}
+		if err != nil {
+			records[idx].Status = internal.AuditStatusFailed
+			records[idx].Error = err.Error()
+		}
+	}
+
+	return records
+}
+
+// newPublisher returns options.Publisher if set, otherwise a publisher for target on the
+// configured queue backend. target is a queue URL, or a directory for the spool backend.
+func newPublisher(options *Options, target string) internal.Publisher {
+	if options.Publisher != nil {
+		return options.Publisher
+	}
+
+	switch options.QueueBackend {
+	case "", internal.QueueBackendSQS:
+		cfg := aws.NewConfigWithEndpoint(options.Key, options.Secret, options.Region, options.Endpoint)
+		return aws.NewSQSQueue(sqs.NewFromConfig(awssdk.Config(cfg)), target, 0)
+	case internal.QueueBackendSpool:
+		queue, err := internal.NewSpoolQueue(target, 0)
+		if err != nil {
+			log.Fatal(err)
+		}
+		return queue
+	default:
+		log.Fatalf("unknown queue backend %q", options.QueueBackend)
+	}
+
+	return nil
+}
+
+// newPayloadStore returns the store oversized messages are uploaded to, or nil if they are
+// split instead
+func newPayloadStore(options *Options) internal.BlobStore {
+	switch options.OversizeStrategy {
+	case "", internal.OversizeSplit:
+		return nil
+	case internal.OversizeStore:
+		if options.PayloadStore == "" {
+			log.Fatal("a payload store is required to store oversized messages")
+		}
+		cfg := aws.NewConfigWithEndpoint(options.Key, options.Secret, options.Region, options.Endpoint)
+		store, err := aws.NewBlobStore(cfg, options.PayloadStore)
+		if err != nil {
+			log.Fatal(err)
+		}
+		return store
+	default:
+		log.Fatalf("unknown oversize strategy %q", options.OversizeStrategy)
+	}
+
+	return nil
+}
+
+// outgoingMessage is a message ready to publish and the statements it carries
+type outgoingMessage struct {
+	message    internal.Message
+	statements []internal.ViewEntry
+}
+
+// newOutgoingMessage wraps the serialized body with the FIFO group and deduplication IDs of
+// the message it was serialized from
+func newOutgoingMessage(body string, message *internal.QueueMessage, statements []internal.ViewEntry) outgoingMessage {
+	return outgoingMessage{
+		message: internal.Message{
+			Body:            body,
+			GroupID:         message.GroupID(),
+			DeduplicationID: message.DeduplicationID(),
+		},
+		statements: statements,
+	}
+}
+
+// buildMessages serializes the statements of a contract into one message, or into several
+// when the message would exceed the queue size limit. Oversized messages are uploaded to
+// store if it is set and split into parts otherwise.
+func buildMessages(ctx context.Context, store internal.BlobStore, header internal.QueueMessage, statements []internal.ViewEntry) ([]outgoingMessage, error) {
+	message := newStatementsMessage(header, statements)
+	body, err := internal.SerializeMessage(message)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(body) <= internal.MaxMessageBytes {
+		return []outgoingMessage{newOutgoingMessage(body, message, statements)}, nil
+	}
+
+	if store != nil {
+		pointer, err := internal.NewPointerMessage(ctx, store, fmt.Sprintf("%s/%s.json", header.RunID, header.ContractAddress), message, body)
+		if err != nil {
+			return nil, err
+		}
+
+		log.Printf("message for contract address %s is %d bytes, uploaded to %s\n", header.ContractAddress, len(body), pointer.PayloadRef)
+
+		pointerBody, err := internal.SerializeMessage(pointer)
+		if err != nil {
+			return nil, err
+		}
+
+		// the pointer is deduplicated by the statements it refers to
+		return []outgoingMessage{newOutgoingMessage(pointerBody, message, statements)}, nil
+	}
+
+	// Greedily fill each part with as many statements as fit, leaving room for the part
+	// numbers which are only known once all parts are assembled
+	parts := make([][]internal.ViewEntry, 0)
+	current := make([]internal.ViewEntry, 0)
+	for _, statement := range statements {
+		candidate := append(current[:len(current):len(current)], statement)
+		candidateBody, err := serializeStatements(header, candidate)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(candidateBody) > internal.MaxMessageBytes-64 {
+			if len(current) == 0 {
+				return nil, fmt.Errorf("statement for view %s does not fit into a single message", statement.ViewName)
+			}
+			parts = append(parts, current)
+			current = []internal.ViewEntry{statement}
+			continue
+		}
+
+		current = candidate
+	}
+	parts = append(parts, current)
+
+	log.Printf("message for contract address %s is %d bytes, split into %d parts\n", header.ContractAddress, len(body), len(parts))
+
+	messages := make([]outgoingMessage, len(parts))
+	for idx, part := range parts {
+		message := newStatementsMessage(header, part)
+		message.Part = idx + 1
+		message.TotalParts = len(parts)
+
+		partBody, err := internal.SerializeMessage(message)
+		if err != nil {
+			return nil, err
+		}
+
+		messages[idx] = newOutgoingMessage(partBody, message, part)
+	}
+
+	return messages, nil
+}
+
+// newStatementsMessage creates a message carrying the statements, with the run, chain and
+// contract fields copied from header
+func newStatementsMessage(header internal.QueueMessage, statements []internal.ViewEntry) *internal.QueueMessage {
+	message := internal.NewMessage(header.RunID, header.ContractAddress, statements)
+	message.Chain = header.Chain
+	message.Namespace = header.Namespace
+
+	return message
+}
+
+func serializeStatements(header internal.QueueMessage, statements []internal.ViewEntry) (string, error) {
+	return internal.SerializeMessage(newStatementsMessage(header, statements))
+}
+
+// planStatements adds the action of every view of the contract to the plan and returns the
+// views that have to be created or replaced
+func planStatements(ctx context.Context, catalog *internal.ViewCatalog, plan *internal.Plan, contractAddress string, statements []internal.ViewEntry) ([]internal.ViewEntry, error) {
+	entries := make([]internal.PlanEntry, len(statements))
+	changed := make([]internal.ViewEntry, 0)
+	for idx, statement := range statements {
+		action, state, err := catalog.Lookup(ctx, statement)
+		if err != nil {
+			return nil, err
+		}
+
+		entries[idx] = internal.PlanEntry{ContractAddress: contractAddress, Action: action, State: state, ViewEntry: statement}
+		if action != internal.ViewActionUnchanged {
+			changed = append(changed, statement)
+		}
+	}
+
+	plan.Add(entries...)
+
+	return changed, nil
+}
+
+// detectABIChange logs how the views of the contract changed with its ABI and returns the
+// statements handling its stale views. It does nothing unless options.StaleViews is set.
+func detectABIChange(ctx context.Context, catalog *internal.ViewCatalog, options *Options, contractAddress string, statements []internal.ViewEntry) ([]internal.ViewEntry, error) {
+	if options.StaleViews == "" {
+		return nil, nil
+	}
+
+	change, err := catalog.ABIChange(ctx, contractAddress, statements)
+	if err != nil || change == nil {
+		return nil, err
+	}
+
+	log.Printf("ABI change: contractAddress=%s before=[%s] after=[%s]\n", contractAddress, strings.Join(change.Before, " "), strings.Join(change.After, " "))
+	for _, entry := range change.Stale {
+		log.Printf("STALE: contractAddress=%s view=%s signature=%s action=%s\n", contractAddress, entry.ViewName, entry.Signature, options.StaleViews)
+	}
+
+	if options.Plan {
+		return nil, nil
+	}
+
+	return change.StaleStatements(options.StaleViews, time.Now()), nil
+}
+
 func CreateViews(ctx context.Context, options *Options) {
 
 	if options.DryRun {
 		log.Println("running in dry-run mode. View create statements will not be submitted to snowflake")
 	}
 
-	cfg := aws.NewConfig(options.Key, options.Secret, options.Region)
+	payloadStore := newPayloadStore(options)
 
 	// Open snowflake connection
 	db, err := sql.Open("snowflake", options.DSN)
@@ -63,6 +321,41 @@ func CreateViews(ctx context.Context, options *Options) {
 	}
 	defer db.Close()
 
+	checkDecoder(ctx, db, options)
+
+	runID := sf.NewUUID().String()
+	log.Printf("starting run %s with producer version %s\n", runID, internal.Version)
+
+	auditLog := newAuditLog(ctx, db, options)
+
+	// The consumer backs up the views it replaces or drops in the backup table
+	if options.BackupTable != "" && !options.DryRun {
+		if _, err := db.ExecContext(ctx, executeTemplate("backup.sql", options)); err != nil {
+			log.Fatal("error creating backup table:", err)
+		}
+	}
+
+	watermarkStore := newWatermarkStore(ctx, db, options)
+	var next internal.Watermark
+	if watermarkStore != nil {
+		options.Watermark, err = watermarkStore.Load(ctx)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		next, err = nextWatermark(ctx, db, options)
+		if err != nil {
+			log.Fatal(err)
+		}
+		options.NextWatermark = next
+
+		if options.IncrementalRun() {
+			log.Printf("incremental run from watermark verified_at=%s block=%d\n", options.Watermark.VerifiedAtString(), options.Watermark.Block)
+		} else {
+			log.Println("no watermark stored yet, processing all contracts")
+		}
+	}
+
 	query := getCreateQuery(options)
 	log.Println("getting contracts to process with query:\n", query)
 
@@ -81,6 +374,15 @@ func CreateViews(ctx context.Context, options *Options) {
 	processingAttemptedDoneChan := make(chan int)
 	processingDoneChan := make(chan int)
 	processingErrors := make([]SnowflakeError, 0)
+	// Contracts skipped for their ABI are reported but don't hold back the watermark, a
+	// permanently broken ABI would stop incremental runs from moving forward otherwise
+	var skippedMu sync.Mutex
+	skippedContracts := make([]SnowflakeError, 0)
+	skipContract := func(contractAddress string, err error) {
+		skippedMu.Lock()
+		defer skippedMu.Unlock()
+		skippedContracts = append(skippedContracts, *NewSnowflakeError(contractAddress, err))
+	}
 	viewCountDoneChan := make(chan int)
 	viewCountChan := make(chan int)
 	viewCount := 0
@@ -141,69 +443,182 @@ func CreateViews(ctx context.Context, options *Options) {
 		}
 	}()
 
-	counter := 0
-	var contractProcessingGroup sync.WaitGroup
+	type contractJob struct {
+		contractAddress string
+		abi             abi.ABI
+	}
 
-	// Iterate through results, create and submit SQL statements for each ABI and contract address
-	for rows.Next() {
-		var contractAddress string
-		bs := []byte{}
-		err := rows.Scan(&contractAddress, &bs)
-		if err != nil {
-			processingErrorChan <- *NewSnowflakeError(contractAddress, err)
-			continue
-		}
+	// A fixed number of workers generates the SQL statements, and the publisher pool caps
+	// the number of requests to the queue in flight at any time
+	var catalog *internal.ViewCatalog
+	var plan *internal.Plan
+	// A blue/green deployment starts from a clone of the target schema, so only the views
+	// that differ have to be created in it
+	if options.Plan || options.DiffOnly || len(options.Routes) > 0 || options.StaleViews != "" || options.BlueGreen {
+		catalog = internal.NewViewCatalog(db, options.Namespace)
+	}
+	if options.Plan || options.DiffOnly || options.BlueGreen {
+		plan = &internal.Plan{}
+	}
 
-		abiVal, err := abi.JSON(strings.NewReader(string(bs)))
-		if err != nil {
-			processingErrorChan <- *NewSnowflakeError(contractAddress, err)
-			continue
-		}
+	deployment := newStagingDeployment(ctx, db, options)
+
+	router := newLaneRouter(ctx, options, catalog)
+	jobs := make(chan contractJob)
+	var contractProcessingGroup sync.WaitGroup
 
+	workers := options.Concurrency
+	if workers < 1 {
+		workers = 1
+	}
+
+	for i := 0; i < workers; i++ {
 		contractProcessingGroup.Add(1)
 
-		go func(ctx context.Context, contractAddress string, abi abi.ABI, options *Options, cfg aws.Config, wg *sync.WaitGroup) {
+		go func(ctx context.Context, options *Options, router *laneRouter, wg *sync.WaitGroup) {
 			defer wg.Done()
 
-			contractAbi := NewAbiContract(contractAddress, abi, options.Namespace)
-			contractAbi.ValidateNames()
-			if contractAbi.Skip {
-				log.Println("skipping contract due to long event or method name")
-				return
-			}
-			multiStatementBuffer := contractAbi.GenerateSql()
-			numStatements := contractAbi.GetNumberOfStatements()
+			for job := range jobs {
+				contractAddress := job.contractAddress
+
+				contractAbi := NewAbiContract(contractAddress, job.abi, options)
+				contractAbi.ValidateNames()
+				if contractAbi.Skip {
+					log.Println("skipping contract due to long event or method name")
+					skipContract(contractAddress, fmt.Errorf("long event or method name"))
+					if deployment != nil {
+						deployment.skip(contractAddress)
+					}
+					continue
+				}
+				statements := contractAbi.GenerateStatements()
+				numStatements := len(statements)
 
-			viewCountChan <- numStatements
+				viewCountChan <- numStatements
 
-			if numStatements == 0 {
-				log.Printf("contract_address %s has no events or methods. Skipping...\n", contractAddress)
-				return
-			}
+				if numStatements == 0 {
+					log.Printf("contract_address %s has no events or methods. Skipping...\n", contractAddress)
+					if deployment != nil {
+						deployment.expect(contractAddress, nil)
+					}
+					continue
+				}
+
+				// Stale views of a plan are reported as orphaned once all contracts are planned
+				staleStatements, err := detectABIChange(ctx, catalog, options, contractAddress, statements)
+				if err != nil {
+					processingErrorChan <- *NewSnowflakeError(contractAddress, err)
+					if deployment != nil {
+						deployment.fail(contractAddress)
+					}
+					continue
+				}
+
+				if deployment != nil {
+					deployment.expect(contractAddress, statements)
+				}
+
+				// Routing looks at all views of the contract, planning narrows the statements
+				// down to the views that need to be created or replaced
+				allStatements := statements
+				if plan != nil {
+					changed, err := planStatements(ctx, catalog, plan, contractAddress, statements)
+					if err != nil {
+						processingErrorChan <- *NewSnowflakeError(contractAddress, err)
+						if deployment != nil {
+							deployment.fail(contractAddress)
+						}
+						continue
+					}
+					if options.Plan || len(changed)+len(staleStatements) == 0 {
+						continue
+					}
+					statements = changed
+				}
+				statements = append(statements, staleStatements...)
+
+				if options.DryRun {
+					continue
+				}
+
+				if deployment != nil {
+					if err := deployment.create(ctx, contractAddress, statements); err != nil {
+						processingErrorChan <- *NewSnowflakeError(contractAddress, err)
+					}
+					continue
+				}
+
+				newDone := func(statements []internal.ViewEntry) func(error) {
+					return func(err error) {
+						if auditLog != nil {
+							if auditErr := auditLog.Record(ctx, auditRecords(runID, contractAddress, statements, err)...); auditErr != nil {
+								log.Println("ERROR:", auditErr)
+							}
+						}
+
+						if err != nil {
+							snowflakeError := NewSnowflakeError(contractAddress, err)
+							processingErrorChan <- *snowflakeError
+							processingAttemptedChan <- 1
+							return
+						}
+
+						processingAttemptedChan <- 1
+						processingSuccessfulChan <- 1
+					}
+				}
+
+				header := internal.QueueMessage{
+					RunID:           runID,
+					Chain:           options.Chain,
+					Namespace:       options.Namespace,
+					ContractAddress: contractAddress,
+				}
 
-			message := internal.NewMessage(contractAddress, multiStatementBuffer.String(), numStatements)
-			
-			if !options.DryRun {
-				body, err := internal.SerializeMessage(message)
+				messages, err := buildMessages(ctx, payloadStore, header, statements)
 				if err != nil {
-					snowflakeError := NewSnowflakeError(contractAddress, err)
-					processingErrorChan <- *snowflakeError
-					processingAttemptedChan <- 1
-					return
+					newDone(statements)(err)
+					continue
 				}
 
-				if err = aws.SendSQSMessage(cfg, options.QueueUrl, body); err != nil {
-					snowflakeError := NewSnowflakeError(contractAddress, err)
-					processingErrorChan <- *snowflakeError
-					processingAttemptedChan <- 1
-					return
+				pool, err := router.route(ctx, contractAddress, allStatements)
+				if err != nil {
+					newDone(statements)(err)
+					continue
 				}
 
-				processingAttemptedChan <- 1
-				processingSuccessfulChan <- 1
+				for _, message := range messages {
+					pool.Publish(message.message, newDone(message.statements))
+				}
 			}
+		}(ctx, options, router, &contractProcessingGroup)
+	}
 
-		}(ctx, contractAddress, abiVal, options, cfg, &contractProcessingGroup)
+	counter := 0
+
+	// Iterate through results, create and submit SQL statements for each ABI and contract address
+	for rows.Next() {
+		var contractAddress string
+		bs := []byte{}
+		err := rows.Scan(&contractAddress, &bs)
+		if err != nil {
+			processingErrorChan <- *NewSnowflakeError(contractAddress, err)
+			if deployment != nil {
+				deployment.fail(contractAddress)
+			}
+			continue
+		}
+
+		abiVal, err := abi.JSON(strings.NewReader(string(bs)))
+		if err != nil {
+			skipContract(contractAddress, err)
+			if deployment != nil {
+				deployment.skip(contractAddress)
+			}
+			continue
+		}
+
+		jobs <- contractJob{contractAddress: contractAddress, abi: abiVal}
 
 		counter += 1
 		if counter%100 == 0 {
@@ -215,15 +630,77 @@ func CreateViews(ctx context.Context, options *Options) {
 			log.Printf("%d messages out of %d successfully submitted (%s)\n", successCount, attemptedCount, pct)
 		}
 	}
+	if err := rows.Err(); err != nil {
+		processingErrorChan <- *NewSnowflakeError("", err)
+		if deployment != nil {
+			deployment.fail("")
+		}
+	}
+	close(jobs)
 
 	log.Println("waiting for all submitted queries to finish processing...")
 	contractProcessingGroup.Wait()
+	router.close()
+
+	if plan != nil {
+		// Views of contracts outside of a partial or incremental run are not orphaned
+		if len(options.ContractList) > 0 || options.AddLimit || options.IncrementalRun() {
+			plan.Add(catalog.Orphaned(plan.ContractAddresses())...)
+		} else {
+			plan.Add(catalog.Orphaned(nil)...)
+		}
+
+		plan.WriteReport(os.Stdout)
+
+		if deployment != nil {
+			if err := deployment.dropOrphaned(ctx, plan.Entries); err != nil {
+				log.Println("ERROR:", err)
+			}
+		}
+
+		if options.PlanFile != "" {
+			if err := internal.WritePlanFile(options.PlanFile, internal.NewPlanFile(plan, options.Chain, options.Namespace)); err != nil {
+				log.Fatal(err)
+			}
+			log.Printf("plan saved to %s, run apply %s to execute it\n", options.PlanFile, options.PlanFile)
+		}
+	}
+
+	if auditLog != nil {
+		if err := auditLog.Flush(ctx); err != nil {
+			log.Println("ERROR:", err)
+		}
+	}
+
+	if deployment != nil {
+		if err := deployment.finish(ctx, options.Namespace); err != nil {
+			processingErrorChan <- *NewSnowflakeError("", err)
+		}
+	}
 
 	processingDoneChan <- 0
 	viewCountDoneChan <- 0
 	processingAttemptedDoneChan <- 0
 	processingSuccessfulDoneChan <- 0
 
+	// The watermark only moves forward once every contract of a complete run was queued or
+	// skipped for its ABI
+	if watermarkStore != nil && len(processingErrors) == 0 && len(options.ContractList) == 0 && !options.AddLimit && !options.DryRun && !options.Plan {
+		if err := watermarkStore.Save(ctx, next); err != nil {
+			log.Println("ERROR:", err)
+		} else {
+			log.Printf("watermark advanced to verified_at=%s block=%d\n", next.VerifiedAtString(), next.Block)
+		}
+	}
+
+	if len(skippedContracts) > 0 {
+		log.Printf("skipped %d contracts with invalid ABIs\n", len(skippedContracts))
+
+		for _, err := range skippedContracts {
+			log.Printf("SKIPPED: contractAddress=%s error=%s", err.ContractAddress, err.Error.Error())
+		}
+	}
+
 	if len(processingErrors) > 0 {
 		log.Printf("processing finished with %d errors\n", len(processingErrors))
 
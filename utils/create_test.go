@@ -0,0 +1,128 @@
+package utils
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"math/rand"
+	"strings"
+	"testing"
+
+	"github.com/credmark/abi-sql-view-generator/internal"
+)
+
+// testViews returns count views whose DDL holds size random bytes, hex encoded so that
+// they don't compress away
+func testViews(count int, size int) []internal.ViewEntry {
+	random := rand.New(rand.NewSource(1))
+	views := make([]internal.ViewEntry, count)
+	for idx := range views {
+		data := make([]byte, size)
+		random.Read(data)
+
+		viewName := fmt.Sprintf("ethereum_contracts.ns_0xabc_evt_e%d", idx)
+		views[idx] = internal.ViewEntry{
+			ViewName: viewName,
+			Kind:     internal.ViewKindEvent,
+			DDL:      fmt.Sprintf("CREATE OR REPLACE VIEW %s AS SELECT '%s' AS data;", viewName, hex.EncodeToString(data)),
+		}
+	}
+
+	return views
+}
+
+func TestBuildMessages(t *testing.T) {
+	store, err := internal.NewFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name        string
+		store       internal.BlobStore
+		views       []internal.ViewEntry
+		wantParts   int
+		wantPointer bool
+		wantErr     string
+	}{
+		{
+			name:      "fits into one message",
+			views:     testViews(3, 100),
+			wantParts: 1,
+		},
+		{
+			name:      "split into parts",
+			views:     testViews(20, 20*1024),
+			wantParts: 3,
+		},
+		{
+			name:        "stored in the blob store",
+			store:       store,
+			views:       testViews(20, 20*1024),
+			wantParts:   1,
+			wantPointer: true,
+		},
+		{
+			name:    "single view too large",
+			views:   testViews(1, 400*1024),
+			wantErr: "does not fit into a single message",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			header := internal.QueueMessage{RunID: "run", Chain: "ethereum", Namespace: "ns", ContractAddress: "0xabc"}
+			messages, err := buildMessages(context.Background(), test.store, header, test.views)
+			if test.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+					t.Fatalf("got error %v, want %q", err, test.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(messages) != test.wantParts {
+				t.Fatalf("got %d messages, want %d", len(messages), test.wantParts)
+			}
+
+			viewNames := make([]string, 0)
+			for idx, outgoing := range messages {
+				if len(outgoing.message.Body) > internal.MaxMessageBytes {
+					t.Errorf("message %d is %d bytes, over the limit of %d", idx+1, len(outgoing.message.Body), internal.MaxMessageBytes)
+				}
+
+				message, err := internal.DeserializeMessage(outgoing.message.Body)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				if test.wantPointer != (message.PayloadRef != "") {
+					t.Errorf("got payload ref %q, want a pointer message: %v", message.PayloadRef, test.wantPointer)
+				}
+
+				if test.wantParts > 1 && (message.Part != idx+1 || message.TotalParts != test.wantParts) {
+					t.Errorf("got part %d of %d, want %d of %d", message.Part, message.TotalParts, idx+1, test.wantParts)
+				}
+
+				message, err = internal.ResolveMessage(context.Background(), store, message)
+				if err != nil {
+					t.Fatal(err)
+				}
+				for _, view := range message.Entries() {
+					viewNames = append(viewNames, view.ViewName)
+				}
+			}
+
+			if len(viewNames) != len(test.views) {
+				t.Fatalf("got %d views, want %d", len(viewNames), len(test.views))
+			}
+			for idx, view := range test.views {
+				if viewNames[idx] != view.ViewName {
+					t.Errorf("view %d is %s, want %s", idx+1, viewNames[idx], view.ViewName)
+				}
+			}
+		})
+	}
+}
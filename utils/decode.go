@@ -0,0 +1,463 @@
+package utils
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/credmark/abi-sql-view-generator/internal"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// parsedContract is a contract along with its parsed ABI
+type parsedContract struct {
+	address string
+	abi     abi.ABI
+}
+
+// loadABIDir reads the ABIs of a directory of <contract address>.json files, only those of
+// the contract list if it is set
+func loadABIDir(dir string, contractList []string) ([]parsedContract, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+
+	selected := make(map[string]bool, len(contractList))
+	for _, address := range contractList {
+		selected[strings.ToLower(address)] = true
+	}
+
+	sort.Strings(files)
+	contracts := make([]parsedContract, 0, len(files))
+	for _, file := range files {
+		address := strings.ToLower(strings.TrimSuffix(filepath.Base(file), filepath.Ext(file)))
+		if len(selected) > 0 && !selected[address] {
+			continue
+		}
+
+		bs, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+
+		abiVal, err := abi.JSON(strings.NewReader(string(bs)))
+		if err != nil {
+			return nil, fmt.Errorf("error parsing ABI of %s: %w", address, err)
+		}
+
+		contracts = append(contracts, parsedContract{address: address, abi: abiVal})
+	}
+
+	if len(contracts) == 0 {
+		return nil, fmt.Errorf("no contract ABIs found in %s", dir)
+	}
+
+	return contracts, nil
+}
+
+// decodedView is a generated view whose rows are decoded in Go instead of by the decoder UDF
+type decodedView struct {
+	// name is the name of the view without its schema
+	name string
+	// viewName is the schema qualified name of the view
+	viewName        string
+	contractAddress string
+	event           *abi.Event
+	method          *abi.Method
+	columns         []internal.ParquetColumn
+	writer          *internal.ParquetTableWriter
+	failed          int
+}
+
+// rawInput is the data and topics of a log, or the input of a call, as in the source tables
+type rawInput struct {
+	data   string
+	topics string
+}
+
+// inputs returns the inp_ columns of the view
+func (v *decodedView) inputs() []internal.ParquetColumn {
+	return v.columns[len(v.columns)-len(v.inputArguments()):]
+}
+
+func (v *decodedView) inputArguments() abi.Arguments {
+	if v.event != nil {
+		return v.event.Inputs
+	}
+
+	return v.method.Inputs
+}
+
+// decodeInputs decodes the inputs of a log or call of the view with go-ethereum
+func (v *decodedView) decodeInputs(raw rawInput) ([]interface{}, error) {
+	data, err := decodeHex(raw.data)
+	if err != nil {
+		return nil, err
+	}
+
+	if v.method != nil {
+		return internal.DecodeMethodInputs(*v.method, data)
+	}
+
+	topics := splitTopics(raw.topics)
+	hashes := make([]common.Hash, len(topics))
+	for idx, topic := range topics {
+		hashes[idx] = common.HexToHash(topic)
+	}
+
+	return internal.DecodeEventInputs(*v.event, data, hashes)
+}
+
+// inputColumns returns the inp_ columns of the view, named as in the templates
+func inputColumns(inputs abi.Arguments) []internal.ParquetColumn {
+	columns := make([]internal.ParquetColumn, len(inputs))
+	for idx, input := range createInputs(inputs) {
+		columns[idx] = internal.ParquetColumn{Name: "inp_" + input.Name, Type: inputColumnType(inputs[idx].Type)}
+	}
+
+	return columns
+}
+
+// inputColumnType returns the parquet type of the values the decoder UDF returns for an
+// input type. Large integers, arrays and tuples are stored as strings.
+func inputColumnType(t abi.Type) string {
+	switch {
+	case t.T == abi.BoolTy:
+		return internal.ParquetBoolean
+	case (t.T == abi.IntTy || t.T == abi.UintTy) && t.Size <= 48:
+		return internal.ParquetInt64
+	default:
+		return internal.ParquetString
+	}
+}
+
+// inputValues converts decoded values to the types of the inp_ columns. All of them are
+// null if the inputs couldn't be decoded, as in the views.
+func inputValues(columns []internal.ParquetColumn, decoded []interface{}) []interface{} {
+	values := make([]interface{}, len(columns))
+	if decoded == nil {
+		return values
+	}
+
+	for idx, value := range decoded {
+		if list, ok := value.([]interface{}); ok {
+			bs, _ := json.Marshal(list)
+			value = string(bs)
+		}
+		values[idx] = value
+	}
+
+	return values
+}
+
+// viewDecoder maps the logs and calls of the contracts to their generated views
+type viewDecoder struct {
+	outputDir string
+	// events maps contract address and topic 0 to event views
+	events map[string]*decodedView
+	// methods maps contract address and method ID to function views
+	methods map[string]*decodedView
+}
+
+func newViewDecoder(contracts []parsedContract, options *Options, outputDir string) *viewDecoder {
+	d := &viewDecoder{
+		outputDir: outputDir,
+		events:    make(map[string]*decodedView),
+		methods:   make(map[string]*decodedView),
+	}
+
+	for _, contract := range contracts {
+		abiContract := NewAbiContract(contract.address, contract.abi, options)
+		statements := abiContract.GenerateStatements()
+
+		for idx, e := range abiContract.Events {
+			event := contract.abi.Events[e.Name]
+			d.events[contract.address+strings.ToLower(e.SigHash)] = &decodedView{
+				name:            unqualifiedViewName(statements[idx].ViewName),
+				viewName:        statements[idx].ViewName,
+				contractAddress: contract.address,
+				event:           &event,
+				columns: append([]internal.ParquetColumn{
+					{Name: "contract_address", Type: internal.ParquetString},
+					{Name: "evt_block_number", Type: internal.ParquetInt64},
+					{Name: "evt_tx_hash", Type: internal.ParquetString},
+					{Name: "evt_index", Type: internal.ParquetInt64},
+				}, inputColumns(event.Inputs)...),
+			}
+		}
+
+		for idx, m := range abiContract.Methods {
+			method := contract.abi.Methods[m.Name]
+			d.methods[contract.address+m.MethodIdHash] = &decodedView{
+				name:            unqualifiedViewName(statements[len(abiContract.Events)+idx].ViewName),
+				viewName:        statements[len(abiContract.Events)+idx].ViewName,
+				contractAddress: contract.address,
+				method:          &method,
+				columns: append([]internal.ParquetColumn{
+					{Name: "contract_address", Type: internal.ParquetString},
+					{Name: "txn_block_number", Type: internal.ParquetInt64},
+					{Name: "txn_hash", Type: internal.ParquetString},
+					{Name: "txn_index", Type: internal.ParquetInt64},
+					{Name: "success", Type: internal.ParquetBoolean},
+				}, inputColumns(method.Inputs)...),
+			}
+		}
+	}
+
+	return d
+}
+
+func unqualifiedViewName(viewName string) string {
+	return strings.ToLower(viewName[strings.LastIndex(viewName, ".")+1:])
+}
+
+// write appends a row to the parquet file of the view, which is created with the first row
+func (d *viewDecoder) write(view *decodedView, values []interface{}) error {
+	if view.writer == nil {
+		writer, err := internal.NewParquetTableWriter(filepath.Join(d.outputDir, view.name+".parquet"), view.columns)
+		if err != nil {
+			return err
+		}
+		view.writer = writer
+	}
+
+	return view.writer.Write(values)
+}
+
+// views returns the event and function views ordered by name
+func (d *viewDecoder) views() []*decodedView {
+	views := make([]*decodedView, 0, len(d.events)+len(d.methods))
+	for _, view := range d.events {
+		views = append(views, view)
+	}
+	for _, view := range d.methods {
+		views = append(views, view)
+	}
+	sort.Slice(views, func(i, j int) bool { return views[i].name < views[j].name })
+
+	return views
+}
+
+// close closes the parquet files and logs the number of rows written to each of them
+func (d *viewDecoder) close() error {
+	var closeErr error
+	for _, view := range d.views() {
+		if view.writer == nil {
+			continue
+		}
+
+		if err := view.writer.Close(); err != nil && closeErr == nil {
+			closeErr = err
+		}
+		log.Printf("DECODED: view=%s rows=%d failed=%d\n", view.name, view.writer.Rows(), view.failed)
+	}
+
+	return closeErr
+}
+
+// decodeLog writes the decoded row of a log to the parquet file of its event view
+func (d *viewDecoder) decodeLog(row internal.ExportRow) error {
+	address := strings.ToLower(row["address"])
+	topics := splitTopics(row["topics"])
+	if len(topics) == 0 {
+		return nil
+	}
+
+	view, ok := d.events[address+strings.ToLower(topics[0])]
+	if !ok {
+		return nil
+	}
+
+	decoded, err := view.decodeInputs(rawInput{data: row["data"], topics: row["topics"]})
+	if err != nil {
+		view.failed += 1
+	}
+
+	values := []interface{}{view.contractAddress, exportInt(row["block_number"]), nullableString(row["transaction_hash"]), exportInt(row["log_index"])}
+	return d.write(view, append(values, inputValues(view.inputs(), decoded)...))
+}
+
+// call is a transaction or trace calling a method of a contract
+type call struct {
+	view        *decodedView
+	hash        string
+	blockNumber string
+	index       string
+	input       string
+	err         string
+}
+
+func (c *call) key() string {
+	return strings.Join([]string{c.view.name, c.hash, c.blockNumber, c.index}, "|")
+}
+
+// newCall returns the call of a transaction or trace row, nil if it doesn't call a method
+// of the contracts
+func (d *viewDecoder) newCall(row internal.ExportRow, hashColumn string) *call {
+	address := strings.ToLower(row["to_address"])
+	input := row["input"]
+	if len(input) < 10 {
+		return nil
+	}
+
+	view, ok := d.methods[address+strings.ToLower(input[:10])]
+	if !ok {
+		return nil
+	}
+
+	return &call{view: view, hash: row[hashColumn], blockNumber: row["block_number"], index: row["transaction_index"], input: input, err: row["error"]}
+}
+
+// decodeCall writes the decoded row of a call to the parquet file of its function view
+func (d *viewDecoder) decodeCall(c *call) error {
+	decoded, err := c.view.decodeInputs(rawInput{data: c.input})
+	if err != nil {
+		c.view.failed += 1
+	}
+
+	values := []interface{}{c.view.contractAddress, exportInt(c.blockNumber), nullableString(c.hash), exportInt(c.index), c.err == ""}
+	return d.write(c.view, append(values, inputValues(c.view.inputs(), decoded)...))
+}
+
+// decodeCalls decodes the calls of the transactions and traces exports. Like the function
+// views it keeps a single call per view and transaction, preferring failed calls.
+func (d *viewDecoder) decodeCalls(transactionsFile string, tracesFile string) error {
+	traces := make(map[string]*call)
+	keys := make([]string, 0)
+	if tracesFile != "" {
+		err := internal.ReadExport(tracesFile, func(row internal.ExportRow) error {
+			c := d.newCall(row, "transaction_hash")
+			if c == nil {
+				return nil
+			}
+
+			key := c.key()
+			previous, ok := traces[key]
+			if !ok {
+				keys = append(keys, key)
+			}
+			if !ok || (c.err != "" && (previous.err == "" || c.err < previous.err)) {
+				traces[key] = c
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	if transactionsFile != "" {
+		err := internal.ReadExport(transactionsFile, func(row internal.ExportRow) error {
+			c := d.newCall(row, "hash")
+			if c == nil {
+				return nil
+			}
+
+			// The call of the transaction is replaced by a failed call of its traces
+			if trace, ok := traces[c.key()]; ok {
+				if trace.err != "" {
+					return nil
+				}
+				delete(traces, c.key())
+			}
+			return d.decodeCall(c)
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, key := range keys {
+		if c, ok := traces[key]; ok {
+			if err := d.decodeCall(c); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// splitTopics splits the topics of a log, which are comma separated in the logs table and
+// may be a JSON array in exports
+func splitTopics(topics string) []string {
+	return strings.FieldsFunc(topics, func(r rune) bool {
+		return strings.ContainsRune(",[]\" ", r)
+	})
+}
+
+func decodeHex(value string) ([]byte, error) {
+	if strings.HasPrefix(value, "0x") || strings.HasPrefix(value, "0X") {
+		value = value[2:]
+	}
+
+	return hex.DecodeString(value)
+}
+
+func exportInt(value string) interface{} {
+	n, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return nil
+	}
+
+	return n
+}
+
+func nullableString(value string) interface{} {
+	if value == "" {
+		return nil
+	}
+
+	return value
+}
+
+// DecodeExports decodes the logs and calls of the contracts of options.AbiDir from exports of
+// the logs, transactions and traces tables with go-ethereum instead of the decoder UDF. It
+// writes a parquet file with the columns of the generated view to options.OutputDir for
+// every view that has rows. It doesn't connect to snowflake.
+func DecodeExports(ctx context.Context, options *Options) error {
+	if options.AbiDir == "" {
+		return fmt.Errorf("an ABI directory is required to decode exports")
+	}
+
+	if options.LogsFile == "" && options.TransactionsFile == "" && options.TracesFile == "" {
+		return fmt.Errorf("a logs, transactions or traces export is required to decode exports")
+	}
+
+	contracts, err := loadABIDir(options.AbiDir, options.ContractList)
+	if err != nil {
+		return err
+	}
+	log.Printf("decoding exports for %d contracts\n", len(contracts))
+
+	if err := os.MkdirAll(options.OutputDir, 0755); err != nil {
+		return fmt.Errorf("error creating output directory %s: %w", options.OutputDir, err)
+	}
+
+	decoder := newViewDecoder(contracts, options, options.OutputDir)
+
+	var decodeErr error
+	if options.LogsFile != "" {
+		decodeErr = internal.ReadExport(options.LogsFile, decoder.decodeLog)
+	}
+
+	if decodeErr == nil {
+		decodeErr = decoder.decodeCalls(options.TransactionsFile, options.TracesFile)
+	}
+
+	if err := decoder.close(); err != nil && decodeErr == nil {
+		decodeErr = err
+	}
+
+	return decodeErr
+}
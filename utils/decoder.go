@@ -0,0 +1,244 @@
+package utils
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/credmark/abi-sql-view-generator/internal"
+)
+
+// decoderTemplate is the data templates/decoder.sql is executed with
+type decoderTemplate struct {
+	DecoderUDF     string
+	DecoderComment string
+}
+
+// decoderSampleContracts is the number of contracts install-udf compares the decoder UDFs
+// on when no contracts or limit are given
+const decoderSampleContracts = 20
+
+// checkDecoder stops the run if the decoder UDF has a version other than the bundled one, as
+// the generated views would fail or decode differently. A UDF without a version, such as one
+// installed by other means, is only warned about.
+func checkDecoder(ctx context.Context, db *sql.DB, options *Options) {
+	if options.SkipDecoderCheck {
+		return
+	}
+
+	err := internal.CheckDecoder(ctx, db, options.DecoderUDF, internal.DecoderVersion)
+	if errors.Is(err, internal.ErrDecoderUnversioned) {
+		log.Printf("WARNING: %s, the views are generated for version %s\n", err, internal.DecoderVersion)
+		return
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	log.Printf("decoder UDF %s has version %s\n", options.DecoderUDF, internal.DecoderVersion)
+}
+
+// InstallDecoder installs the bundled decoder UDF next to the existing one under a versioned
+// name, options.DecoderUDF with a _v<version> suffix, and compares the two. The new UDF is
+// only marked with its version once it decodes sampled logs and calls like the existing UDF,
+// or like go-ethereum where the two differ. Until then the version check of runs refuses it.
+// Views are pointed at it with -decoder-udf.
+func InstallDecoder(ctx context.Context, options *Options) error {
+	udf := internal.VersionedDecoderUDF(options.DecoderUDF, internal.DecoderVersion)
+	query := executeTemplate("decoder.sql", decoderTemplate{
+		DecoderUDF:     udf,
+		DecoderComment: internal.UnverifiedDecoderComment(internal.DecoderVersion),
+	})
+
+	if options.DryRun {
+		log.Printf("running in dry-run mode. The decoder UDF would be installed with:\n%s\n", query)
+		return nil
+	}
+
+	db, err := sql.Open("snowflake", options.DSN)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	if _, err := db.ExecContext(ctx, query); err != nil {
+		return err
+	}
+
+	log.Printf("installed decoder UDF %s version %s, comparing it with %s\n", udf, internal.DecoderVersion, options.DecoderUDF)
+
+	regressions, err := compareDecoders(ctx, db, options, udf)
+	if err != nil {
+		return err
+	}
+	if regressions > 0 {
+		return fmt.Errorf("decoder UDF %s decoded %d fields differently than %s and go-ethereum, it was left unverified", udf, regressions, options.DecoderUDF)
+	}
+
+	if err := internal.MarkDecoderVerified(ctx, db, udf, internal.DecoderVersion); err != nil {
+		return err
+	}
+
+	log.Printf("verified decoder UDF %s, point the views at it with -decoder-udf %s\n", udf, udf)
+
+	return nil
+}
+
+// compareDecoders decodes the latest logs and calls of the views of the sampled contracts
+// with the existing and the candidate decoder UDF and go-ethereum, and reports the fields
+// the candidate decodes differently. It returns the number of regressions.
+func compareDecoders(ctx context.Context, db *sql.DB, options *Options, candidate string) (int, error) {
+	versions, err := internal.DeployedDecoderVersions(ctx, db, options.DecoderUDF)
+	if err != nil {
+		return 0, err
+	}
+	existing := options.DecoderUDF
+	if len(versions) == 0 {
+		log.Printf("decoder UDF %s does not exist, comparing %s with go-ethereum only\n", options.DecoderUDF, candidate)
+		existing = ""
+	}
+
+	sampled := *options
+	if len(sampled.ContractList) == 0 && !sampled.AddLimit {
+		sampled.AddLimit = true
+		sampled.Limit = decoderSampleContracts
+	}
+
+	contracts, err := loadContracts(ctx, db, &sampled)
+	if err != nil {
+		return 0, err
+	}
+
+	decoder := newViewDecoder(contracts, options, "")
+	views := decoder.views()
+	log.Printf("comparing %d rows of %d views of %d contracts\n", options.VerifySamples, len(views), len(contracts))
+
+	report := internal.NewVerifyReport()
+	fixes := 0
+	for _, view := range views {
+		viewFixes, err := compareViewDecoders(ctx, db, options, view, existing, candidate, report)
+		if err != nil {
+			return 0, err
+		}
+		fixes += viewFixes
+	}
+
+	report.WriteReport(os.Stdout)
+	if fixes > 0 {
+		log.Printf("%s decoded %d fields like go-ethereum where %s didn't\n", candidate, fixes, options.DecoderUDF)
+	}
+
+	return len(report.Mismatches), nil
+}
+
+// compareViewDecoders compares the decoder UDFs on the latest logs or transactions of a
+// view. Traces are left out, the transactions call the same decoder code.
+func compareViewDecoders(ctx context.Context, db *sql.DB, options *Options, view *decodedView, existing string, candidate string, report *internal.VerifyReport) (int, error) {
+	inputs := createInputs(view.inputArguments())
+	udfs := []string{candidate}
+	if existing != "" {
+		udfs = append(udfs, existing)
+	}
+
+	selects, calls := "", ""
+	for idx, udf := range udfs {
+		if view.event != nil {
+			calls += fmt.Sprintf(", %s(data, topics, parse_json(?), 'event', true) AS val%d", udf, idx)
+		} else {
+			calls += fmt.Sprintf(", %s(input, '', parse_json(?), 'method', true) AS val%d", udf, idx)
+		}
+		for _, input := range inputs {
+			selects += fmt.Sprintf(", to_json(val%d:%s)", idx, input.Name)
+		}
+	}
+
+	inputsJson := inputsToJson(inputs)
+	args := make([]interface{}, 0, len(udfs)+2)
+	for range udfs {
+		args = append(args, inputsJson)
+	}
+
+	var query string
+	if view.event != nil {
+		query = fmt.Sprintf(`SELECT transaction_hash, log_index, data, topics%s
+FROM (
+    SELECT transaction_hash, log_index, data, topics%s
+    FROM %s
+    WHERE address = ? AND substring(topics, 1, 66) = ?
+    ORDER BY block_number DESC
+    LIMIT %d
+)`, selects, calls, options.LogsTable, options.VerifySamples)
+		args = append(args, view.contractAddress, view.event.ID.Hex())
+	} else {
+		query = fmt.Sprintf(`SELECT hash, transaction_index, input, ''%s
+FROM (
+    SELECT hash, transaction_index, input%s
+    FROM %s
+    WHERE to_address = ? AND substring(input, 1, 10) = ?
+    ORDER BY block_number DESC
+    LIMIT %d
+)`, selects, calls, options.TransactionsTable, options.VerifySamples)
+		args = append(args, view.contractAddress, getMethodIdHash(view.method.ID))
+	}
+
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return 0, fmt.Errorf("error comparing decoder UDFs on view %s: %w", view.viewName, err)
+	}
+	defer rows.Close()
+
+	columns := make([]string, len(inputs))
+	for idx, input := range inputs {
+		columns[idx] = input.Name
+	}
+
+	sampled, fixes := 0, 0
+	mismatches := make([]internal.FieldMismatch, 0)
+	for rows.Next() {
+		values := make([]sql.NullString, 4+len(udfs)*len(inputs))
+		dest := make([]interface{}, len(values))
+		for idx := range values {
+			dest[idx] = &values[idx]
+		}
+		if err := rows.Scan(dest...); err != nil {
+			return 0, fmt.Errorf("error comparing decoder UDFs on view %s: %w", view.viewName, err)
+		}
+
+		decoded := make([][]*string, len(udfs))
+		for idx := range udfs {
+			decoded[idx] = make([]*string, len(inputs))
+			for i, value := range values[4+idx*len(inputs) : 4+(idx+1)*len(inputs)] {
+				if value.Valid {
+					v := value.String
+					decoded[idx][i] = &v
+				}
+			}
+		}
+
+		var existingValues []*string
+		if existing != "" {
+			existingValues = decoded[1]
+		}
+
+		// Inputs go-ethereum can't decode are compared as null
+		expected, _ := view.decodeInputs(rawInput{data: values[2].String, topics: values[3].String})
+		regressions, rowFixes := internal.CompareDecoders(columns, expected, existingValues, decoded[0])
+		for _, mismatch := range regressions {
+			mismatch.Row = values[0].String + "/" + values[1].String
+			mismatches = append(mismatches, mismatch)
+		}
+		fixes += rowFixes
+		sampled += 1
+	}
+
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("error comparing decoder UDFs on view %s: %w", view.viewName, err)
+	}
+
+	report.Add(view.viewName, sampled, 0, mismatches)
+
+	return fixes, nil
+}
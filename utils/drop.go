@@ -1,66 +1,305 @@
 package utils
 
 import (
+	"bufio"
 	"bytes"
 	"context"
 	"database/sql"
 	"fmt"
 	"io/ioutil"
 	"log"
+	"os"
 	"path/filepath"
+	"strings"
+	"sync"
 
+	"github.com/credmark/abi-sql-view-generator/internal"
+	"github.com/ethereum/go-ethereum/accounts/abi"
 	sf "github.com/snowflakedb/gosnowflake"
 )
 
-func getDropQuery() string {
+// getDropQuery returns the query of sql/drop.sql for the views of the schema narrowed down by
+// the filter, along with its bind values
+func getDropQuery(schema string, filter *internal.DropFilter) (string, []interface{}) {
 	path, _ := filepath.Abs("sql/drop.sql")
 	fb, err := ioutil.ReadFile(path)
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	return string(fb)
+	query := strings.TrimSuffix(strings.TrimSpace(string(fb)), ";")
+	conditions, args := filter.Conditions()
+	if conditions != "" {
+		query = query + "\n" + conditions
+	}
+
+	return query, append([]interface{}{schema}, args...)
 }
 
-// TODO: break up into batches to avoid buffer too big error if trying to delete all views later
-func generateDropStatements(rows *sql.Rows) (bytes.Buffer, int) {
-	buffer := bytes.Buffer{}
-	rowCount := 0
+// selectDropViews returns the schema qualified names of the views matching the drop filter
+func selectDropViews(ctx context.Context, db *sql.DB, options *Options) ([]string, error) {
+	query, args := getDropQuery(options.TargetSchema, &options.DropFilter)
+	log.Printf("selecting views to drop with query:\n%s\nparameters: %v\n", query, args)
+
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	viewNames := make([]string, 0)
+	for rows.Next() {
+		var schema, name string
+		if err := rows.Scan(&schema, &name); err != nil {
+			return nil, err
+		}
+		viewNames = append(viewNames, strings.ToLower(schema+"."+name))
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if !options.DropFilter.OrphanedOnly {
+		return viewNames, nil
+	}
+
+	generated, skipped, err := generatedViewNames(ctx, db, options)
+	if err != nil {
+		return nil, err
+	}
+
+	// The views of contracts whose ABI can't be parsed or is skipped are kept, they are
+	// unknown rather than orphaned
+	prefix := strings.ToLower(options.TargetSchema + "." + options.Namespace + "_")
+	orphaned := make([]string, 0)
+	kept := 0
+	for _, viewName := range viewNames {
+		if generated[viewName] {
+			continue
+		}
+		if strings.HasPrefix(viewName, prefix) && skipped[strings.SplitN(strings.TrimPrefix(viewName, prefix), "_", 2)[0]] {
+			kept += 1
+			continue
+		}
+		orphaned = append(orphaned, viewName)
+	}
+	if kept > 0 {
+		log.Printf("keeping %d views of %d contracts that were skipped for their ABI\n", kept, len(skipped))
+	}
+	log.Printf("%d of %d matching views are orphaned\n", len(orphaned), len(viewNames))
+
+	return orphaned, nil
+}
+
+// generatedViewNames returns the lower cased names of every view generated from the ABI
+// source for the namespace of the options, and the lower cased addresses of the contracts
+// skipped because their ABI can't be parsed or has names that are too long
+func generatedViewNames(ctx context.Context, db *sql.DB, options *Options) (map[string]bool, map[string]bool, error) {
+	rows, err := db.QueryContext(ctx, getCreateQuery(options))
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	viewNames := make(map[string]bool)
+	skipped := make(map[string]bool)
 	for rows.Next() {
-		var viewName string
-		err := rows.Scan(&viewName)
+		var contractAddress string
+		bs := []byte{}
+		if err := rows.Scan(&contractAddress, &bs); err != nil {
+			return nil, nil, err
+		}
+
+		abiVal, err := abi.JSON(strings.NewReader(string(bs)))
 		if err != nil {
-			log.Fatal(err)
+			log.Printf("ERROR: contractAddress=%s error=%s\n", contractAddress, err)
+			skipped[strings.ToLower(contractAddress)] = true
+			continue
 		}
 
-		statement := fmt.Sprintf("DROP VIEW IF EXISTS %s;\n", viewName)
-		buffer.WriteString(statement)
-		rowCount += 1
+		contractAbi := NewAbiContract(contractAddress, abiVal, options)
+		contractAbi.ValidateNames()
+		if contractAbi.Skip {
+			skipped[strings.ToLower(contractAddress)] = true
+			continue
+		}
+
+		for _, statement := range contractAbi.GenerateStatements() {
+			viewNames[strings.ToLower(statement.ViewName)] = true
+		}
 	}
 
-	return buffer, rowCount
+	return viewNames, skipped, rows.Err()
 }
 
-func dropViews(ctx context.Context, db *sql.DB, buffer bytes.Buffer, rowCount int) {
-	multiStatementCtx, _ := sf.WithMultiStatement(ctx, rowCount)
+// generateDropStatements splits the views into batches of at most batchSize views, and
+// returns the multi-statement SQL dropping each batch
+func generateDropStatements(viewNames []string, batchSize int) []dropBatch {
+	if batchSize < 1 {
+		batchSize = 1
+	}
+
+	batches := make([]dropBatch, 0, len(viewNames)/batchSize+1)
+	for start := 0; start < len(viewNames); start += batchSize {
+		end := start + batchSize
+		if end > len(viewNames) {
+			end = len(viewNames)
+		}
 
-	_, err := db.ExecContext(multiStatementCtx, buffer.String())
+		buffer := bytes.Buffer{}
+		for _, viewName := range viewNames[start:end] {
+			buffer.WriteString(fmt.Sprintf("%s\n", internal.DropViewDDL(viewName)))
+		}
+
+		batches = append(batches, dropBatch{number: len(batches) + 1, viewNames: viewNames[start:end], sql: buffer.String()})
+	}
+
+	return batches
+}
+
+// dropBatch is a set of views dropped with a single multi-statement query
+type dropBatch struct {
+	number    int
+	viewNames []string
+	sql       string
+	err       error
+}
+
+// dropViews backs up and drops the views of each batch, running up to concurrency batches
+// at once. A batch is only dropped once its backup was saved. It returns the batches that
+// failed.
+func dropViews(ctx context.Context, db *sql.DB, backup internal.DDLBackup, runID string, batches []dropBatch, concurrency int) []dropBatch {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	jobs := make(chan dropBatch)
+	results := make(chan dropBatch)
+	var wg sync.WaitGroup
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for batch := range jobs {
+				batch.err = dropBatchViews(ctx, db, backup, runID, batch)
+				results <- batch
+			}
+		}()
+	}
+
+	go func() {
+		for _, batch := range batches {
+			jobs <- batch
+		}
+		close(jobs)
+		wg.Wait()
+		close(results)
+	}()
+
+	failed := make([]dropBatch, 0)
+	for batch := range results {
+		if batch.err != nil {
+			log.Printf("ERROR: batch %d of %d with views %s to %s failed: %s\n", batch.number, len(batches), batch.viewNames[0], batch.viewNames[len(batch.viewNames)-1], batch.err)
+			failed = append(failed, batch)
+			continue
+		}
+		log.Printf("batch %d of %d dropped %d views\n", batch.number, len(batches), len(batch.viewNames))
+	}
+
+	return failed
+}
+
+func dropBatchViews(ctx context.Context, db *sql.DB, backup internal.DDLBackup, runID string, batch dropBatch) error {
+	if err := internal.BackupViews(ctx, db, backup, runID, batch.viewNames); err != nil {
+		return fmt.Errorf("error backing up views, none were dropped: %w", err)
+	}
+
+	multiStatementCtx, _ := sf.WithMultiStatement(ctx, len(batch.viewNames))
+	if _, err := db.ExecContext(multiStatementCtx, batch.sql); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// newDDLBackup creates the backup table if needed and returns the backup views are saved to
+// before they are replaced or dropped
+func newDDLBackup(ctx context.Context, db *sql.DB, options *Options) internal.DDLBackup {
+	if options.BackupTable != "" {
+		if _, err := db.ExecContext(ctx, executeTemplate("backup.sql", options)); err != nil {
+			log.Fatal("error creating backup table:", err)
+		}
+		return internal.NewTableDDLBackup(db, options.BackupTable)
+	}
+
+	if options.BackupDir == "" {
+		log.Fatal("a backup table or directory is required to replace or drop views")
+	}
+
+	backup, err := internal.NewFileDDLBackup(options.BackupDir)
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	log.Printf("view deletion complete")
+	return backup
+}
+
+// stdinIsTerminal reports whether stdin is a terminal someone can answer the confirmation on
+func stdinIsTerminal() bool {
+	info, err := os.Stdin.Stat()
+	if err != nil {
+		return false
+	}
+
+	return info.Mode()&os.ModeCharDevice != 0
+}
+
+// confirmDrop asks for confirmation on stdin unless it was given with -yes
+func confirmDrop(options *Options, count int) bool {
+	if options.Yes {
+		return true
+	}
+
+	fmt.Printf("%d views will be dropped, continue? [y/N] ", count)
+	answer, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil {
+		return false
+	}
+
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes"
 }
 
 func DropViews(ctx context.Context, options *Options) {
 
+	if err := options.DropFilter.Validate(); err != nil {
+		log.Fatal(err)
+	}
+
+	// A limited list of contracts would make the views of all other contracts look orphaned
+	if options.DropFilter.OrphanedOnly && options.AddLimit {
+		log.Fatal("-limit can't be combined with dropping orphaned views")
+	}
+
+	// Views of other namespaces are never generated, so they would all look orphaned
+	if options.DropFilter.OrphanedOnly && options.DropFilter.Namespace == "" {
+		options.DropFilter.Namespace = options.Namespace
+	}
+
 	if options.DryRun {
 		log.Println("running in dry-run mode. Views will not be dropped")
-	} else {
+	} else if options.DropFilter.IsZero() {
 		log.Println("preparing to drop all views...")
+	} else {
+		log.Printf("preparing to drop views matching %+v...\n", options.DropFilter)
+	}
+
+	// Scripted runs would otherwise wait for an answer or read one from whatever is piped in
+	if !options.DryRun && !options.Yes && !stdinIsTerminal() {
+		log.Fatal("stdin is not a terminal, so dropping views can't be confirmed. Pass -yes to drop them without confirmation")
 	}
 
-	query := getDropQuery()
 	log.Println("connecting to database...")
 
 	db, err := sql.Open("snowflake", options.DSN)
@@ -69,15 +308,39 @@ func DropViews(ctx context.Context, options *Options) {
 	}
 	defer db.Close()
 
-	rows, err := db.Query(query)
+	viewNames, err := selectDropViews(ctx, db, options)
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	buffer, rowCount := generateDropStatements(rows)
-	log.Printf("dropping %d views\n", rowCount)
+	if options.MaxDrop > 0 && len(viewNames) > options.MaxDrop {
+		log.Fatalf("%d views match, more than the maximum of %d. Narrow down the filters or raise -max-drop", len(viewNames), options.MaxDrop)
+	}
+
+	batches := generateDropStatements(viewNames, options.DropBatchSize)
+	log.Printf("dropping %d views in %d batches\n", len(viewNames), len(batches))
+
+	if options.DryRun || len(viewNames) == 0 {
+		return
+	}
+
+	if !confirmDrop(options, len(viewNames)) {
+		log.Println("aborted, no views were dropped")
+		return
+	}
+
+	runID := sf.NewUUID().String()
+	backup := newDDLBackup(ctx, db, options)
+	log.Printf("starting drop run %s, the DDL of every view is backed up before it is dropped\n", runID)
 
-	if !options.DryRun {
-		dropViews(ctx, db, buffer, rowCount)
+	failed := dropViews(ctx, db, backup, runID, batches, options.DropConcurrency)
+	if len(failed) > 0 {
+		failedViews := 0
+		for _, batch := range failed {
+			failedViews += len(batch.viewNames)
+		}
+		log.Fatalf("%d of %d batches with %d views failed", len(failed), len(batches), failedViews)
 	}
+
+	log.Printf("view deletion complete")
 }
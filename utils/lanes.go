@@ -0,0 +1,74 @@
+package utils
+
+import (
+	"context"
+	"log"
+
+	"github.com/credmark/abi-sql-view-generator/internal"
+)
+
+// laneRouter sends the messages of each contract to the lane its routes select. Every lane
+// has its own publisher pool.
+type laneRouter struct {
+	lanes   []internal.Lane
+	routes  internal.Routes
+	catalog *internal.ViewCatalog
+	pools   map[string]*internal.PublisherPool
+}
+
+// newLaneRouter creates a router for options.Lanes. Without lanes all messages are sent to
+// options.QueueUrl, or options.SpoolDir for the spool backend. The catalog is used to
+// classify contracts for options.Routes.
+func newLaneRouter(ctx context.Context, options *Options, catalog *internal.ViewCatalog) *laneRouter {
+	lanes := options.Lanes
+	if len(lanes) == 0 {
+		target := options.QueueUrl
+		if options.QueueBackend == internal.QueueBackendSpool {
+			target = options.SpoolDir
+		}
+		lanes = []internal.Lane{{Name: "default", Target: target}}
+	}
+
+	router := &laneRouter{
+		lanes:  lanes,
+		routes: options.Routes,
+		pools:  make(map[string]*internal.PublisherPool, len(lanes)),
+	}
+
+	for _, lane := range lanes {
+		router.pools[lane.Name] = internal.NewPublisherPool(ctx, newPublisher(options, lane.Target), options.MaxInFlight)
+	}
+
+	// Existing views are only looked up when a route depends on them
+	if len(lanes) > 1 && len(options.Routes) > 0 {
+		router.catalog = catalog
+	}
+
+	return router
+}
+
+// route returns the pool of the lane the contract with the generated views is sent to
+func (r *laneRouter) route(ctx context.Context, contractAddress string, views []internal.ViewEntry) (*internal.PublisherPool, error) {
+	if r.catalog == nil {
+		return r.pools[r.lanes[len(r.lanes)-1].Name], nil
+	}
+
+	class, err := r.catalog.Classify(ctx, views)
+	if err != nil {
+		return nil, err
+	}
+
+	lane := r.routes.Lane(class, r.lanes)
+	if class != internal.ContractUnchanged {
+		log.Printf("contract address %s is %s, sending it to lane %s\n", contractAddress, class, lane)
+	}
+
+	return r.pools[lane], nil
+}
+
+// close flushes the pools of all lanes
+func (r *laneRouter) close() {
+	for _, pool := range r.pools {
+		pool.Close()
+	}
+}
@@ -0,0 +1,379 @@
+package utils
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/credmark/abi-sql-view-generator/internal"
+	sf "github.com/snowflakedb/gosnowflake"
+)
+
+// localReceiveBatchSize is the number of messages the local consumer receives at once
+const localReceiveBatchSize = 10
+
+// LocalSchemas returns the schemas of the views, source tables and decoder UDF, which local
+// mode creates in DuckDB
+func (o *Options) LocalSchemas() []string {
+	schemas := []string{o.TargetSchema}
+	seen := map[string]bool{o.TargetSchema: true}
+	for _, name := range []string{o.LogsTable, o.TransactionsTable, o.TracesTable, o.DecoderUDF} {
+		idx := strings.LastIndex(name, ".")
+		if idx < 0 || seen[name[:idx]] {
+			continue
+		}
+		seen[name[:idx]] = true
+		schemas = append(schemas, name[:idx])
+	}
+
+	return schemas
+}
+
+// DecodedValuesTable is the table local mode stores the inputs decoded by go-ethereum in,
+// one row per input
+func (o *Options) DecodedValuesTable() string {
+	return o.DecoderUDF + "_values"
+}
+
+// DecodedInputsTable is the table the decoder macro of local mode looks up the decoded
+// inputs of a log or call in
+func (o *Options) DecodedInputsTable() string {
+	return o.DecoderUDF + "_inputs"
+}
+
+// execTemplate executes the statements of a template one at a time
+func execTemplate(ctx context.Context, db *sql.DB, name string, options *Options) error {
+	for _, statement := range internal.SplitStatements(executeTemplate(name, options)) {
+		if _, err := db.ExecContext(ctx, statement); err != nil {
+			return fmt.Errorf("error executing %s: %w", name, err)
+		}
+	}
+
+	return nil
+}
+
+// localDecoder stores the inputs of the fixture logs and calls of the generated views,
+// decoded with go-ethereum, for the decoder macro to return in place of the decoder UDF
+type localDecoder struct {
+	*viewDecoder
+	insert  *sql.Stmt
+	seen    map[string]bool
+	decoded int
+	failed  int
+}
+
+// record decodes a log or call once per distinct data, topics and inputs, the arguments the
+// decoder UDF is called with. Inputs that can't be decoded aren't stored, so the macro
+// returns null for them as the UDF does.
+func (d *localDecoder) record(ctx context.Context, view *decodedView, kind string, raw rawInput) error {
+	inputs := createInputs(view.inputArguments())
+	inputsJson := inputsToJson(inputs)
+
+	key := strings.Join([]string{kind, raw.data, raw.topics, inputsJson}, "|")
+	if d.seen[key] {
+		return nil
+	}
+	d.seen[key] = true
+
+	decoded, err := view.decodeInputs(raw)
+	if err != nil {
+		d.failed += 1
+		return nil
+	}
+
+	for idx, input := range inputs {
+		bs, err := json.Marshal(decoded[idx])
+		if err != nil {
+			return err
+		}
+
+		if _, err := d.insert.ExecContext(ctx, kind, raw.data, raw.topics, inputsJson, input.Name, string(bs)); err != nil {
+			return err
+		}
+	}
+	d.decoded += 1
+
+	return nil
+}
+
+func (d *localDecoder) recordLog(ctx context.Context, row internal.ExportRow) error {
+	topics := splitTopics(row["topics"])
+	if len(topics) == 0 {
+		return nil
+	}
+
+	if view, ok := d.events[strings.ToLower(row["address"])+strings.ToLower(topics[0])]; ok {
+		return d.record(ctx, view, "event", rawInput{data: row["data"], topics: strings.Join(topics, ",")})
+	}
+
+	return nil
+}
+
+func (d *localDecoder) recordCall(ctx context.Context, row internal.ExportRow, hashColumn string) error {
+	if c := d.newCall(row, hashColumn); c != nil {
+		return d.record(ctx, c.view, "method", rawInput{data: c.input})
+	}
+
+	return nil
+}
+
+// loadFixture inserts the rows of an export into a table created by templates/local.sql and
+// passes every row to fn. Columns missing from the export are null.
+func loadFixture(ctx context.Context, tx *sql.Tx, table string, file string, fn func(row internal.ExportRow) error) (int, error) {
+	rows, err := tx.QueryContext(ctx, fmt.Sprintf("SELECT * FROM %s LIMIT 0", table))
+	if err != nil {
+		return 0, err
+	}
+	columnTypes, err := rows.ColumnTypes()
+	rows.Close()
+	if err != nil {
+		return 0, err
+	}
+
+	columns := make([]string, len(columnTypes))
+	placeholders := make([]string, len(columnTypes))
+	for idx, columnType := range columnTypes {
+		columns[idx] = columnType.Name()
+		placeholders[idx] = "?"
+	}
+
+	insert, err := tx.PrepareContext(ctx, fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s)", table, strings.Join(columns, ", "), strings.Join(placeholders, ", ")))
+	if err != nil {
+		return 0, err
+	}
+	defer insert.Close()
+
+	count := 0
+	err = internal.ReadExport(file, func(row internal.ExportRow) error {
+		values := make([]interface{}, len(columnTypes))
+		for idx, columnType := range columnTypes {
+			value := row[columnType.Name()]
+			switch {
+			case columnType.DatabaseTypeName() == "BIGINT":
+				values[idx] = exportInt(value)
+			case columnType.Name() == "topics":
+				// Topics are comma separated in the logs table and may be a JSON array in exports
+				values[idx] = nullableString(strings.Join(splitTopics(value), ","))
+			default:
+				values[idx] = nullableString(value)
+			}
+		}
+
+		if _, err := insert.ExecContext(ctx, values...); err != nil {
+			return err
+		}
+		count += 1
+
+		return fn(row)
+	})
+
+	return count, err
+}
+
+// loadFixtures loads the logs, transactions and traces exports into DuckDB along with their
+// inputs decoded by go-ethereum
+func loadFixtures(ctx context.Context, db *sql.DB, decoder *viewDecoder, options *Options) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	insert, err := tx.PrepareContext(ctx, fmt.Sprintf("INSERT INTO %s (kind, data, topics, inputs, name, value) VALUES (?, ?, ?, ?, ?, ?)", options.DecodedValuesTable()))
+	if err != nil {
+		return err
+	}
+	defer insert.Close()
+
+	local := &localDecoder{viewDecoder: decoder, insert: insert, seen: make(map[string]bool)}
+
+	fixtures := []struct {
+		table string
+		file  string
+		fn    func(row internal.ExportRow) error
+	}{
+		{options.LogsTable, options.LogsFile, func(row internal.ExportRow) error {
+			return local.recordLog(ctx, row)
+		}},
+		{options.TransactionsTable, options.TransactionsFile, func(row internal.ExportRow) error {
+			return local.recordCall(ctx, row, "hash")
+		}},
+		{options.TracesTable, options.TracesFile, func(row internal.ExportRow) error {
+			return local.recordCall(ctx, row, "transaction_hash")
+		}},
+	}
+
+	for _, fixture := range fixtures {
+		if fixture.file == "" {
+			continue
+		}
+
+		count, err := loadFixture(ctx, tx, fixture.table, fixture.file, fixture.fn)
+		if err != nil {
+			return fmt.Errorf("error loading %s into %s: %w", fixture.file, fixture.table, err)
+		}
+		log.Printf("LOADED: table=%s rows=%d\n", fixture.table, count)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	log.Printf("decoded %d distinct logs and calls, %d could not be decoded\n", local.decoded, local.failed)
+
+	return nil
+}
+
+// publishLocal generates the statements of the contracts and publishes them to the queue
+// as the producer does
+func publishLocal(ctx context.Context, queue internal.Publisher, contracts []parsedContract, options *Options) (int, error) {
+	runID := sf.NewUUID().String()
+	published := 0
+	for _, contract := range contracts {
+		contractAbi := NewAbiContract(contract.address, contract.abi, options)
+		contractAbi.ValidateNames()
+		if contractAbi.Skip {
+			log.Printf("skipping contract %s due to long event or method name\n", contract.address)
+			continue
+		}
+
+		statements := contractAbi.GenerateStatements()
+		if len(statements) == 0 {
+			log.Printf("contract_address %s has no events or methods. Skipping...\n", contract.address)
+			continue
+		}
+
+		header := internal.QueueMessage{
+			RunID:           runID,
+			Chain:           options.Chain,
+			Namespace:       options.Namespace,
+			ContractAddress: contract.address,
+		}
+
+		messages, err := buildMessages(ctx, nil, header, statements)
+		if err != nil {
+			return published, err
+		}
+
+		for _, message := range messages {
+			if err := queue.Publish(ctx, message.message); err != nil {
+				return published, err
+			}
+			published += 1
+		}
+	}
+
+	return published, nil
+}
+
+// consumeLocal handles the messages of the queue until it is empty. Failed messages are
+// acknowledged instead of being retried, there is no dead-letter queue to end up in.
+func consumeLocal(ctx context.Context, queue *internal.MemoryQueue, handler *internal.MessageHandler, workers int) int {
+	if workers < 1 {
+		workers = 1
+	}
+
+	var mu sync.Mutex
+	failed := 0
+	for queue.Len() > 0 {
+		deliveries, err := queue.Receive(ctx, localReceiveBatchSize, 0)
+		if err != nil {
+			log.Println("ERROR:", err)
+			return failed + queue.Len()
+		}
+
+		jobs := make(chan internal.Delivery)
+		var wg sync.WaitGroup
+		for i := 0; i < workers; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+
+				for delivery := range jobs {
+					if err := handler.Handle(ctx, queue, delivery); err != nil {
+						log.Printf("FAILED: message=%s error=%s\n", delivery.ID, err.Error())
+						mu.Lock()
+						failed += 1
+						mu.Unlock()
+						_ = queue.Ack(ctx, delivery)
+					}
+				}
+			}()
+		}
+
+		for _, delivery := range deliveries {
+			jobs <- delivery
+		}
+		close(jobs)
+		wg.Wait()
+	}
+
+	return failed
+}
+
+// RunLocal runs the producer and consumer end to end in a single process without AWS or
+// snowflake. The ABIs are read from options.AbiDir and the messages go through an in-memory
+// queue to a consumer creating the views in an embedded DuckDB database loaded with the
+// logs, transactions and traces exports. The decoder UDF is replaced by a macro returning
+// the inputs decoded by go-ethereum, so the views can be queried with real decoded values.
+// The DuckDB driver has to be registered by the caller.
+func RunLocal(ctx context.Context, options *Options) error {
+	if options.AbiDir == "" {
+		return fmt.Errorf("an ABI directory is required in local mode")
+	}
+
+	contracts, err := loadABIDir(options.AbiDir, options.ContractList)
+	if err != nil {
+		return err
+	}
+
+	db, err := sql.Open("duckdb", options.DuckDBFile)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	if err := execTemplate(ctx, db, "local.sql", options); err != nil {
+		return err
+	}
+
+	decoder := newViewDecoder(contracts, options, "")
+	if err := loadFixtures(ctx, db, decoder, options); err != nil {
+		return err
+	}
+
+	if err := execTemplate(ctx, db, "local_decoder.sql", options); err != nil {
+		return err
+	}
+
+	queue := internal.NewMemoryQueue(time.Minute)
+	published, err := publishLocal(ctx, queue, contracts, options)
+	if err != nil {
+		return err
+	}
+	log.Printf("published %d messages for %d contracts\n", published, len(contracts))
+
+	handler := &internal.MessageHandler{DB: db, Execute: internal.ExecuteDuckDBMessage}
+	failedMessages := consumeLocal(ctx, queue, handler, options.Concurrency)
+
+	missing := 0
+	for _, view := range decoder.views() {
+		var count int
+		if err := db.QueryRowContext(ctx, fmt.Sprintf("SELECT count(*) FROM %s", view.viewName)).Scan(&count); err != nil {
+			log.Printf("FAILED: view=%s error=%s\n", view.viewName, err.Error())
+			missing += 1
+			continue
+		}
+		log.Printf("VIEW: view=%s rows=%d\n", view.viewName, count)
+	}
+
+	if failedMessages > 0 || missing > 0 {
+		return fmt.Errorf("%d messages failed and %d of %d views can't be queried", failedMessages, missing, len(decoder.views()))
+	}
+
+	return nil
+}
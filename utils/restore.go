@@ -0,0 +1,99 @@
+package utils
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"sync"
+
+	"github.com/credmark/abi-sql-view-generator/internal"
+	sf "github.com/snowflakedb/gosnowflake"
+)
+
+// RestoreViews recreates the views a run replaced or dropped from their backed up DDL, or
+// only the listed views. The current DDL of the restored views is backed up as well, so a
+// restore can itself be undone.
+func RestoreViews(ctx context.Context, options *Options, runID string, viewNames []string) error {
+	db, err := sql.Open("snowflake", options.DSN)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	backup := newDDLBackup(ctx, db, options)
+
+	records, err := backup.Load(ctx, runID)
+	if err != nil {
+		return err
+	}
+
+	statements, err := internal.RestoreStatements(records, viewNames)
+	if err != nil {
+		return err
+	}
+
+	if len(statements) == 0 {
+		return fmt.Errorf("the backup of run %s has no views", runID)
+	}
+
+	log.Printf("restoring %d views backed up by run %s\n", len(statements), runID)
+
+	if options.DryRun {
+		for _, statement := range statements {
+			log.Printf("RESTORE: view=%s\n", statement.ViewName)
+		}
+		log.Println("running in dry-run mode. Views will not be restored")
+		return nil
+	}
+
+	restoreRunID := sf.NewUUID().String()
+	log.Printf("starting restore run %s\n", restoreRunID)
+
+	if err := internal.BackupChangedViews(ctx, db, backup, restoreRunID, statements); err != nil {
+		return fmt.Errorf("error backing up views, none were restored: %w", err)
+	}
+
+	jobs := make(chan internal.ViewEntry)
+	var mu sync.Mutex
+	failed := 0
+
+	workers := options.Concurrency
+	if workers < 1 {
+		workers = 1
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for statement := range jobs {
+				for _, result := range internal.ExecuteStatements(ctx, db, []internal.ViewEntry{statement}) {
+					if result.Error != nil {
+						log.Printf("FAILED: view=%s queryID=%s error=%s\n", result.ViewName, result.QueryID, result.Error.Error())
+						mu.Lock()
+						failed += 1
+						mu.Unlock()
+						continue
+					}
+
+					log.Printf("RESTORED: view=%s queryID=%s\n", result.ViewName, result.QueryID)
+				}
+			}
+		}()
+	}
+
+	for _, statement := range statements {
+		jobs <- statement
+	}
+	close(jobs)
+	wg.Wait()
+
+	if failed > 0 {
+		return fmt.Errorf("%d of %d views could not be restored", failed, len(statements))
+	}
+
+	return nil
+}
@@ -11,6 +11,7 @@ import (
 	"strings"
 	"text/template"
 
+	"github.com/credmark/abi-sql-view-generator/internal"
 	"github.com/ethereum/go-ethereum/accounts/abi"
 )
 
@@ -49,10 +50,14 @@ type AbiEvent struct {
 	Inputs []AbiContractColumn
 	// InputsJson is the json string of inputs data
 	InputsJson string
+	// Signature is the canonical event signature, i.e. Transfer(address,address,uint256)
+	Signature string
 	// SigHash is the hash of the event signature
 	SigHash string
 	// Namespace is the namespace prefix added to the name of the SQL view
 	Namespace string
+	// ViewConfig holds the target schema, the logs table and the decoder UDF of event.sql
+	ViewConfig
 }
 
 type AbiMethod struct {
@@ -64,10 +69,30 @@ type AbiMethod struct {
 	Inputs []AbiContractColumn
 	// InputsJson is the json string of inputs data
 	InputsJson string
+	// Signature is the canonical method signature, i.e. transfer(address,uint256)
+	Signature string
 	// MethodIdHash is the hash of the method ID
 	MethodIdHash string
 	// Namespace is the namespace prefix added to the name of the SQL view
 	Namespace string
+	// ViewConfig holds the target schema, the transactions and traces tables and the decoder
+	// UDF of function.sql
+	ViewConfig
+}
+
+// ViewConfig is the part of the options the view templates are executed with. It is kept
+// apart from Options so that the templates can't reach the credentials.
+type ViewConfig struct {
+	// TargetSchema is the schema the views are created in
+	TargetSchema string
+	// LogsTable is the fully qualified name of the logs table
+	LogsTable string
+	// TransactionsTable is the fully qualified name of the transactions table
+	TransactionsTable string
+	// TracesTable is the fully qualified name of the traces table
+	TracesTable string
+	// DecoderUDF is the fully qualified name of the decoder UDF
+	DecoderUDF string
 }
 
 type Options struct {
@@ -83,6 +108,110 @@ type Options struct {
 	Limit int
 	Count int
 	ContractList []string
+	// AuditTable is the fully qualified name of the view audit table, auditing is disabled if empty
+	AuditTable string
+	// QueueBackend is the backend messages are published to, one of the internal.QueueBackend constants
+	QueueBackend string
+	// Endpoint overrides the SQS endpoint, i.e. for a local SQS stand-in
+	Endpoint string
+	// SpoolDir is the directory of the spool queue backend
+	SpoolDir string
+	// Publisher overrides the publisher created from QueueBackend, i.e. with an in-memory queue
+	Publisher internal.Publisher
+	// Concurrency is the number of goroutines generating SQL statements
+	Concurrency int
+	// MaxInFlight is the maximum number of publish requests in flight at any time
+	MaxInFlight int
+	// OversizeStrategy handles messages over the queue size limit, one of the internal.Oversize constants
+	OversizeStrategy string
+	// PayloadStore is the s3:// or file:// location oversized messages are uploaded to
+	PayloadStore string
+	// Chain is the blockchain the contracts are deployed on, sent along with every message
+	Chain string
+	// Lanes are the target queues from the highest to the lowest priority. QueueUrl is the
+	// only target if empty.
+	Lanes []internal.Lane
+	// Routes selects the lane of a contract by its class, see internal.Routes
+	Routes internal.Routes
+	// OrderByActivity processes the contracts with the most logs first
+	OrderByActivity bool
+	// Plan compares the generated views to the existing ones and reports the differences
+	// without queueing anything
+	Plan bool
+	// DiffOnly only queues the views that have to be created or replaced
+	DiffOnly bool
+	// PlanFile is the path the plan is saved to in plan mode
+	PlanFile string
+	// ApplyDirect executes a saved plan on snowflake instead of queueing it
+	ApplyDirect bool
+	// Incremental only processes contracts verified or updated since the stored watermark,
+	// and contracts that crossed the log threshold since then
+	Incremental bool
+	// WatermarkTable is the fully qualified name of the table the watermark is stored in
+	WatermarkTable string
+	// WatermarkFile is the local file the watermark is stored in if WatermarkTable is empty
+	WatermarkFile string
+	// WatermarkColumn is the verification or update time column of deployed_contract_metadata
+	WatermarkColumn string
+	// Watermark is the watermark loaded for an incremental run
+	Watermark internal.Watermark
+	// NextWatermark is the watermark the run advances to once it completes
+	NextWatermark internal.Watermark
+	// StaleViews enables ABI change detection and handles the views that are no longer
+	// generated, one of the internal.StaleViews constants. Detection is disabled if empty.
+	StaleViews string
+	// DropFilter narrows down the views dropped in drop mode
+	DropFilter internal.DropFilter
+	// DropBatchSize is the number of views dropped with each query
+	DropBatchSize int
+	// DropConcurrency is the number of drop batches running at once
+	DropConcurrency int
+	// MaxDrop aborts a drop that matches more views, no limit if 0
+	MaxDrop int
+	// Yes skips the confirmation before views are dropped
+	Yes bool
+	// BackupTable is the fully qualified name of the table the DDL of views is backed up in
+	BackupTable string
+	// BackupDir is the local directory the DDL of views is backed up in if BackupTable is empty
+	BackupDir string
+	// BlueGreen creates the views in a clone of the target schema and swaps it with the
+	// target schema once all of them were created and validated
+	BlueGreen bool
+	// StagingSchema is the schema views are staged in, and the previous views are kept in
+	// after a blue/green deployment. Defaults to TargetSchema with a _staging suffix.
+	StagingSchema string
+	// TargetSchema is the schema views are created in
+	TargetSchema string
+	// ContractsTable is the fully qualified name of the table of verified contracts and ABIs
+	ContractsTable string
+	// LogsTable is the fully qualified name of the table of event logs
+	LogsTable string
+	// TransactionsTable is the fully qualified name of the table of transactions
+	TransactionsTable string
+	// TracesTable is the fully qualified name of the table of traces
+	TracesTable string
+	// DecoderUDF is the fully qualified name of the UDF decoding event and method inputs
+	DecoderUDF string
+	// SkipDecoderCheck runs without checking the version of the installed decoder UDF
+	SkipDecoderCheck bool
+	// AbiDir is the local directory of <contract address>.json ABI files decode mode reads
+	// instead of the contracts table
+	AbiDir string
+	// LogsFile is a CSV, JSON lines or parquet export of the logs table
+	LogsFile string
+	// TransactionsFile is a CSV, JSON lines or parquet export of the transactions table
+	TransactionsFile string
+	// TracesFile is a CSV, JSON lines or parquet export of the traces table
+	TracesFile string
+	// OutputDir is the directory decode mode writes a parquet file per view to
+	OutputDir string
+	// ViewDir is the local directory of view exports, named after the views, verify mode
+	// samples instead of the views in snowflake
+	ViewDir string
+	// VerifySamples is the number of rows verify mode samples from each view
+	VerifySamples int
+	// DuckDBFile is the database file local mode creates the views in, in memory if empty
+	DuckDBFile string
 }
 
 func NewOptions(dsn, namespace, key, secret, region, queueURL string, dryRun, drop bool, limit, count int, contractList string) *Options {
@@ -114,52 +243,109 @@ func NewOptions(dsn, namespace, key, secret, region, queueURL string, dryRun, dr
 		Limit: limit,
 		Count: count,
 		ContractList: contracts,
+		Concurrency: 8,
+		MaxInFlight: 8,
+		OversizeStrategy: internal.OversizeSplit,
+		Chain: "ethereum",
+		WatermarkColumn: "updated_at",
+		DropBatchSize: 100,
+		DropConcurrency: 4,
+		BackupDir: "backups",
+		TargetSchema: "ethereum_contracts",
+		ContractsTable: "ethereum.deployed_contract_metadata",
+		LogsTable: "ethereum.logs",
+		TransactionsTable: "ethereum.transactions",
+		TracesTable: "ethereum.traces",
+		DecoderUDF: "ethereum_contracts.decode_abi_input_prod",
+		OutputDir: "decoded",
+		VerifySamples: 10,
 	}
 }
 
-func NewAbiContract(contractAddress string, abi abi.ABI, namespace string) *AbiContract {
+// Staging returns the schema views are staged in by blue/green deployments
+func (o *Options) Staging() string {
+	if o.StagingSchema != "" {
+		return o.StagingSchema
+	}
+
+	return o.TargetSchema + "_staging"
+}
+
+// ViewConfig returns the target schema, source tables and decoder UDF the views are
+// generated with
+func (o *Options) ViewConfig() ViewConfig {
+	return ViewConfig{
+		TargetSchema: o.TargetSchema,
+		LogsTable: o.LogsTable,
+		TransactionsTable: o.TransactionsTable,
+		TracesTable: o.TracesTable,
+		DecoderUDF: o.DecoderUDF,
+	}
+}
+
+// LogCountsTable is the table the log counts of every address up to the watermark are
+// stored in next to the watermark table, empty if the watermark is kept in a file
+func (o *Options) LogCountsTable() string {
+	if o.WatermarkTable == "" {
+		return ""
+	}
+
+	return o.WatermarkTable + "_log_counts"
+}
+
+// IncrementalRun is true for incremental runs that have a watermark to start from. The first
+// incremental run processes all contracts.
+func (o *Options) IncrementalRun() bool {
+	return o.Incremental && !o.Watermark.IsZero()
+}
+
+func NewAbiContract(contractAddress string, abi abi.ABI, options *Options) *AbiContract {
 	return &AbiContract{
-		Events:  newAbiEvents(abi, contractAddress, namespace),
-		Methods: newAbiMethods(abi, contractAddress, namespace),
+		Events:  newAbiEvents(abi, contractAddress, options),
+		Methods: newAbiMethods(abi, contractAddress, options),
 		Skip:    false,
 	}
 }
 
-func newAbiEvent(event abi.Event, contractAddress string, namespace string) *AbiEvent {
+func newAbiEvent(event abi.Event, contractAddress string, options *Options) *AbiEvent {
 	return &AbiEvent{
 		ContractAddress: contractAddress,
 		Name:            event.Name,
+		Signature:       event.Sig,
 		SigHash:         event.ID.Hex(),
 		Inputs:          createInputs(event.Inputs),
 		InputsJson:      inputsToJson(createInputs(event.Inputs)),
-		Namespace:       namespace,
+		Namespace:       options.Namespace,
+		ViewConfig:      options.ViewConfig(),
 	}
 }
 
-func newAbiEvents(abi abi.ABI, contractAddress string, namespace string) []AbiEvent {
+func newAbiEvents(abi abi.ABI, contractAddress string, options *Options) []AbiEvent {
 	newEvents := []AbiEvent{}
 	for _, event := range abi.Events {
-		newEvents = append(newEvents, *newAbiEvent(event, contractAddress, namespace))
+		newEvents = append(newEvents, *newAbiEvent(event, contractAddress, options))
 	}
 
 	return newEvents
 }
 
-func newAbiMethod(method abi.Method, contractAddress string, namespace string) *AbiMethod {
+func newAbiMethod(method abi.Method, contractAddress string, options *Options) *AbiMethod {
 	return &AbiMethod{
 		ContractAddress: contractAddress,
 		Name:            method.Name,
+		Signature:       method.Sig,
 		MethodIdHash:    getMethodIdHash(method.ID),
 		Inputs:          createInputs(method.Inputs),
 		InputsJson:      inputsToJson(createInputs(method.Inputs)),
-		Namespace:       namespace,
+		Namespace:       options.Namespace,
+		ViewConfig:      options.ViewConfig(),
 	}
 }
 
-func newAbiMethods(abi abi.ABI, contractAddress string, namespace string) []AbiMethod {
+func newAbiMethods(abi abi.ABI, contractAddress string, options *Options) []AbiMethod {
 	newMethods := []AbiMethod{}
 	for _, method := range abi.Methods {
-		newMethods = append(newMethods, *newAbiMethod(method, contractAddress, namespace))
+		newMethods = append(newMethods, *newAbiMethod(method, contractAddress, options))
 	}
 
 	return newMethods
@@ -206,21 +392,43 @@ func validateInputName(input string, idx int) string {
 
 func (c *AbiContract) GenerateSql() bytes.Buffer {
 	buffer := bytes.Buffer{}
-	for _, v := range c.Events {
-		_, err := buffer.Write(v.generateSql())
+	for _, v := range c.GenerateStatements() {
+		_, err := buffer.WriteString(v.DDL)
 		if err != nil {
 			log.Fatal(err)
 		}
 	}
 
+	return buffer
+}
+
+// GenerateStatements returns the view entry of every event and method in the same order
+// as GenerateSql
+func (c *AbiContract) GenerateStatements() []internal.ViewEntry {
+	statements := make([]internal.ViewEntry, 0, c.GetNumberOfStatements())
+	for _, v := range c.Events {
+		ddl := string(v.generateSql())
+		statements = append(statements, internal.ViewEntry{
+			ViewName:  internal.ViewName(ddl),
+			Kind:      internal.ViewKindEvent,
+			Signature: v.Signature,
+			Hash:      v.SigHash,
+			DDL:       ddl,
+		})
+	}
+
 	for _, v := range c.Methods {
-		_, err := buffer.Write(v.generateSql())
-		if err != nil {
-			log.Fatal(err)
-		}
+		ddl := string(v.generateSql())
+		statements = append(statements, internal.ViewEntry{
+			ViewName:  internal.ViewName(ddl),
+			Kind:      internal.ViewKindFunction,
+			Signature: v.Signature,
+			Hash:      v.MethodIdHash,
+			DDL:       ddl,
+		})
 	}
 
-	return buffer
+	return statements
 }
 
 func (e *AbiEvent) generateSql() []byte {
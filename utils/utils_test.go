@@ -0,0 +1,78 @@
+package utils
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+)
+
+// TestMain runs the tests from the repository root, where the templates are loaded from
+func TestMain(m *testing.M) {
+	if err := os.Chdir(".."); err != nil {
+		panic(err)
+	}
+
+	os.Exit(m.Run())
+}
+
+const testABI = `[
+	{"type":"event","name":"Transfer","inputs":[{"name":"from","type":"address","indexed":true},{"name":"to","type":"address","indexed":true},{"name":"value","type":"uint256","indexed":false}]},
+	{"type":"function","name":"approve","inputs":[{"name":"spender","type":"address"},{"name":"amount","type":"uint256"}],"outputs":[{"name":"","type":"bool"}]}
+]`
+
+func TestGenerateStatements(t *testing.T) {
+	contractAbi, err := abi.JSON(strings.NewReader(testABI))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	options := NewOptions("user:password@account/db", "ns", "aws-key", "aws-secret", "", "", false, false, 0, 0, "")
+	options.TargetSchema = "dev_contracts"
+	options.LogsTable = "dev.logs"
+	options.TransactionsTable = "dev.transactions"
+	options.TracesTable = "dev.traces"
+	options.DecoderUDF = "dev_contracts.decode_v2"
+
+	statements := NewAbiContract("0xabc", contractAbi, options).GenerateStatements()
+
+	tests := []struct {
+		viewName string
+		contains []string
+	}{
+		{
+			viewName: "dev_contracts.ns_0xabc_evt_Transfer",
+			contains: []string{"FROM dev.logs", "dev_contracts.decode_v2(", "val:value as inp_value", "COMMENT = 'Transfer(address,address,uint256)'"},
+		},
+		{
+			viewName: "dev_contracts.ns_0xabc_fn_approve",
+			contains: []string{"dev.transactions", "dev.traces", "dev_contracts.decode_v2(", "val:spender as inp_spender"},
+		},
+	}
+
+	if len(statements) != len(tests) {
+		t.Fatalf("got %d statements, want %d", len(statements), len(tests))
+	}
+
+	for idx, test := range tests {
+		t.Run(test.viewName, func(t *testing.T) {
+			statement := statements[idx]
+			if statement.ViewName != test.viewName {
+				t.Fatalf("got view %s, want %s", statement.ViewName, test.viewName)
+			}
+
+			for _, s := range test.contains {
+				if !strings.Contains(statement.DDL, s) {
+					t.Errorf("DDL doesn't contain %q:\n%s", s, statement.DDL)
+				}
+			}
+
+			for _, secret := range []string{"password", "aws-key", "aws-secret"} {
+				if strings.Contains(statement.DDL, secret) {
+					t.Errorf("DDL contains %q", secret)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,388 @@
+package utils
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/credmark/abi-sql-view-generator/internal"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+)
+
+// viewSample is a row sampled from a view along with the raw logs or calls it may have been
+// decoded from. A function view keeps a single call per transaction, so every call of the
+// transaction to the method is a candidate.
+type viewSample struct {
+	// row identifies the row by transaction hash and log or transaction index
+	row string
+	// values holds the JSON of the inp_ columns, nil for SQL NULL
+	values []*string
+	raw    []rawInput
+}
+
+// viewSampler samples rows of the generated views
+type viewSampler interface {
+	sample(ctx context.Context, view *decodedView, n int) ([]viewSample, error)
+}
+
+// snowflakeSampler samples the views in snowflake and joins them with the source tables
+type snowflakeSampler struct {
+	db      *sql.DB
+	options *Options
+}
+
+func (s *snowflakeSampler) sample(ctx context.Context, view *decodedView, n int) ([]viewSample, error) {
+	selects := ""
+	for _, column := range view.inputs() {
+		selects += fmt.Sprintf(", to_json(s.%s)", column.Name)
+	}
+
+	var query string
+	var args []interface{}
+	if view.event != nil {
+		query = fmt.Sprintf(`SELECT s.evt_tx_hash, s.evt_index, l.data, l.topics%s
+FROM (SELECT * FROM %s SAMPLE (%d ROWS)) s
+LEFT JOIN %s l ON l.transaction_hash = s.evt_tx_hash AND l.log_index = s.evt_index AND l.address = ?`,
+			selects, view.viewName, n, s.options.LogsTable)
+		args = []interface{}{view.contractAddress}
+	} else {
+		methodID := getMethodIdHash(view.method.ID)
+		query = fmt.Sprintf(`SELECT s.txn_hash, s.txn_index, c.input, ''%s
+FROM (SELECT * FROM %s SAMPLE (%d ROWS)) s
+LEFT JOIN (
+    SELECT hash AS txn_hash, input FROM %s WHERE to_address = ? AND substring(input, 1, 10) = ?
+    UNION
+    SELECT transaction_hash AS txn_hash, input FROM %s WHERE to_address = ? AND substring(input, 1, 10) = ?
+) c ON c.txn_hash = s.txn_hash`,
+			selects, view.viewName, n, s.options.TransactionsTable, s.options.TracesTable)
+		args = []interface{}{view.contractAddress, methodID, view.contractAddress, methodID}
+	}
+
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("error sampling view %s: %w", view.viewName, err)
+	}
+	defer rows.Close()
+
+	samples := make([]viewSample, 0, n)
+	byRow := make(map[string]int)
+	for rows.Next() {
+		columns := make([]sql.NullString, 4+len(view.inputs()))
+		dest := make([]interface{}, len(columns))
+		for idx := range columns {
+			dest[idx] = &columns[idx]
+		}
+
+		if err := rows.Scan(dest...); err != nil {
+			return nil, fmt.Errorf("error sampling view %s: %w", view.viewName, err)
+		}
+
+		row := columns[0].String + "/" + columns[1].String
+		idx, ok := byRow[row]
+		if !ok {
+			values := make([]*string, len(view.inputs()))
+			for i, column := range columns[4:] {
+				if column.Valid {
+					value := column.String
+					values[i] = &value
+				}
+			}
+			idx = len(samples)
+			byRow[row] = idx
+			samples = append(samples, viewSample{row: row, values: values})
+		}
+
+		if columns[2].Valid {
+			samples[idx].raw = append(samples[idx].raw, rawInput{data: columns[2].String, topics: columns[3].String})
+		}
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error sampling view %s: %w", view.viewName, err)
+	}
+
+	return samples, nil
+}
+
+// fixtureSampler samples exports of the views, named after the views, and looks up their raw
+// logs and calls in exports of the source tables
+type fixtureSampler struct {
+	// files maps lower cased view names to their exports
+	files map[string]string
+	// logs maps view names, transaction hashes and log indexes to logs
+	logs map[string]rawInput
+	// calls maps view names and transaction hashes to the calls of the transaction
+	calls map[string][]rawInput
+	rand  *rand.Rand
+	mu    sync.Mutex
+}
+
+func newFixtureSampler(decoder *viewDecoder, options *Options) (*fixtureSampler, error) {
+	s := &fixtureSampler{
+		files: make(map[string]string),
+		logs:  make(map[string]rawInput),
+		calls: make(map[string][]rawInput),
+		// A fixed seed samples the same rows every run
+		rand: rand.New(rand.NewSource(1)),
+	}
+
+	files, err := filepath.Glob(filepath.Join(options.ViewDir, "*"))
+	if err != nil {
+		return nil, err
+	}
+	for _, file := range files {
+		switch strings.ToLower(filepath.Ext(file)) {
+		case ".csv", ".json", ".jsonl", ".ndjson", ".parquet":
+			s.files[strings.ToLower(strings.TrimSuffix(filepath.Base(file), filepath.Ext(file)))] = file
+		}
+	}
+
+	if options.LogsFile != "" {
+		err := internal.ReadExport(options.LogsFile, func(row internal.ExportRow) error {
+			topics := splitTopics(row["topics"])
+			if len(topics) == 0 {
+				return nil
+			}
+			if view, ok := decoder.events[strings.ToLower(row["address"])+strings.ToLower(topics[0])]; ok {
+				s.logs[view.name+"|"+row["transaction_hash"]+"/"+row["log_index"]] = rawInput{data: row["data"], topics: row["topics"]}
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for _, export := range []struct{ file, hashColumn string }{{options.TransactionsFile, "hash"}, {options.TracesFile, "transaction_hash"}} {
+		if export.file == "" {
+			continue
+		}
+		err := internal.ReadExport(export.file, func(row internal.ExportRow) error {
+			if c := decoder.newCall(row, export.hashColumn); c != nil {
+				s.calls[c.view.name+"|"+c.hash] = append(s.calls[c.view.name+"|"+c.hash], rawInput{data: c.input})
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return s, nil
+}
+
+// sample picks n random rows of the export of the view. Views without an export have no rows.
+func (s *fixtureSampler) sample(ctx context.Context, view *decodedView, n int) ([]viewSample, error) {
+	file, ok := s.files[view.name]
+	if !ok {
+		return nil, nil
+	}
+
+	hashColumn, indexColumn := "txn_hash", "txn_index"
+	if view.event != nil {
+		hashColumn, indexColumn = "evt_tx_hash", "evt_index"
+	}
+
+	samples := make([]viewSample, 0, n)
+	seen := 0
+	err := internal.ReadExport(file, func(row internal.ExportRow) error {
+		values := make([]*string, len(view.inputs()))
+		for idx, column := range view.inputs() {
+			if value := row[strings.ToLower(column.Name)]; value != "" {
+				values[idx] = &value
+			}
+		}
+
+		sample := viewSample{row: row[hashColumn] + "/" + row[indexColumn], values: values}
+		if view.event != nil {
+			if raw, ok := s.logs[view.name+"|"+sample.row]; ok {
+				sample.raw = []rawInput{raw}
+			}
+		} else {
+			sample.raw = s.calls[view.name+"|"+row[hashColumn]]
+		}
+
+		// Reservoir sampling keeps n rows of the export with equal probability
+		seen += 1
+		if len(samples) < n {
+			samples = append(samples, sample)
+			return nil
+		}
+		s.mu.Lock()
+		idx := s.rand.Intn(seen)
+		s.mu.Unlock()
+		if idx < n {
+			samples[idx] = sample
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return samples, nil
+}
+
+// verifyView compares the sampled rows of a view to the inputs decoded by go-ethereum. Rows
+// of function views match if any call of their transaction decodes to the same values.
+func verifyView(ctx context.Context, sampler viewSampler, view *decodedView, n int, report *internal.VerifyReport) error {
+	samples, err := sampler.sample(ctx, view, n)
+	if err != nil {
+		return err
+	}
+
+	columns := make([]string, len(view.inputs()))
+	for idx, column := range view.inputs() {
+		columns[idx] = column.Name
+	}
+
+	unmatched := 0
+	mismatches := make([]internal.FieldMismatch, 0)
+	for _, sample := range samples {
+		if len(sample.raw) == 0 {
+			unmatched += 1
+			continue
+		}
+
+		var closest []internal.FieldMismatch
+		for _, raw := range sample.raw {
+			// Inputs go-ethereum can't decode are compared as null
+			decoded, _ := view.decodeInputs(raw)
+			rowMismatches := internal.CompareInputs(columns, decoded, sample.values)
+			if closest == nil || len(rowMismatches) < len(closest) {
+				closest = rowMismatches
+			}
+			if len(closest) == 0 {
+				break
+			}
+		}
+
+		for _, mismatch := range closest {
+			mismatch.Row = sample.row
+			mismatches = append(mismatches, mismatch)
+		}
+	}
+
+	report.Add(view.viewName, len(samples), unmatched, mismatches)
+
+	return nil
+}
+
+// loadContracts reads the contracts of the ABI directory if it is set and of the contracts
+// table otherwise
+func loadContracts(ctx context.Context, db *sql.DB, options *Options) ([]parsedContract, error) {
+	if options.AbiDir != "" {
+		return loadABIDir(options.AbiDir, options.ContractList)
+	}
+
+	rows, err := db.QueryContext(ctx, getCreateQuery(options))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	contracts := make([]parsedContract, 0)
+	for rows.Next() {
+		var contractAddress string
+		bs := []byte{}
+		if err := rows.Scan(&contractAddress, &bs); err != nil {
+			return nil, err
+		}
+
+		abiVal, err := abi.JSON(strings.NewReader(string(bs)))
+		if err != nil {
+			log.Printf("ERROR: contractAddress=%s error=%s\n", contractAddress, err)
+			continue
+		}
+
+		contracts = append(contracts, parsedContract{address: contractAddress, abi: abiVal})
+	}
+
+	return contracts, rows.Err()
+}
+
+// VerifyViews samples rows of every generated view, decodes their raw logs and calls with
+// go-ethereum and reports the inputs the decoder UDF decoded differently. The views are
+// sampled in snowflake, or from the exports in options.ViewDir along with exports of the
+// logs, transactions and traces tables.
+func VerifyViews(ctx context.Context, options *Options) error {
+	var db *sql.DB
+	if options.ViewDir == "" {
+		var err error
+		db, err = sql.Open("snowflake", options.DSN)
+		if err != nil {
+			return err
+		}
+		defer db.Close()
+	} else if options.AbiDir == "" {
+		return fmt.Errorf("an ABI directory is required to verify view exports")
+	}
+
+	contracts, err := loadContracts(ctx, db, options)
+	if err != nil {
+		return err
+	}
+
+	decoder := newViewDecoder(contracts, options, "")
+
+	var sampler viewSampler = &snowflakeSampler{db: db, options: options}
+	if options.ViewDir != "" {
+		if sampler, err = newFixtureSampler(decoder, options); err != nil {
+			return err
+		}
+	}
+
+	views := decoder.views()
+	log.Printf("verifying %d rows of %d views of %d contracts\n", options.VerifySamples, len(views), len(contracts))
+
+	report := internal.NewVerifyReport()
+	jobs := make(chan *decodedView)
+	var mu sync.Mutex
+	failed := 0
+
+	workers := options.Concurrency
+	if workers < 1 {
+		workers = 1
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for view := range jobs {
+				if err := verifyView(ctx, sampler, view, options.VerifySamples, report); err != nil {
+					log.Printf("FAILED: view=%s error=%s\n", view.viewName, err.Error())
+					mu.Lock()
+					failed += 1
+					mu.Unlock()
+				}
+			}
+		}()
+	}
+
+	for _, view := range views {
+		jobs <- view
+	}
+	close(jobs)
+	wg.Wait()
+
+	report.WriteReport(os.Stdout)
+
+	if failed > 0 {
+		return fmt.Errorf("%d of %d views could not be sampled", failed, len(views))
+	}
+
+	if mismatched := report.MismatchedViews(); mismatched > 0 {
+		return fmt.Errorf("%d fields of %d views were decoded differently by the decoder UDF", len(report.Mismatches), mismatched)
+	}
+
+	return nil
+}
@@ -0,0 +1,74 @@
+package utils
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"path/filepath"
+	"text/template"
+
+	"github.com/credmark/abi-sql-view-generator/internal"
+)
+
+// executeTemplate renders the template with the options, or other data for templates that
+// only need a few fields
+func executeTemplate(name string, data interface{}) string {
+	fpath, err := filepath.Abs(filepath.Join("templates", name))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	t, err := template.New(name).ParseFiles(fpath)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	buffer := bytes.Buffer{}
+	err = t.Execute(&buffer, data)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	return buffer.String()
+}
+
+// newWatermarkStore returns the store of the watermark, or nil if the run is not incremental
+func newWatermarkStore(ctx context.Context, db *sql.DB, options *Options) internal.WatermarkStore {
+	if !options.Incremental {
+		return nil
+	}
+
+	if options.WatermarkTable != "" {
+		for _, name := range []string{"watermark.sql", "log_counts.sql"} {
+			if _, err := db.ExecContext(ctx, executeTemplate(name, options)); err != nil {
+				log.Fatal("error creating watermark table:", err)
+			}
+		}
+		return internal.NewTableWatermarkStore(db, options.WatermarkTable, options.LogCountsTable(), options.LogsTable, options.Namespace)
+	}
+
+	if options.WatermarkFile == "" {
+		log.Fatal("a watermark table or file is required for incremental runs")
+	}
+
+	return internal.NewFileWatermarkStore(options.WatermarkFile)
+}
+
+// nextWatermark returns the watermark a run that starts now covers. It is read before the
+// contracts are queried so that contracts verified during the run are picked up next time.
+func nextWatermark(ctx context.Context, db *sql.DB, options *Options) (internal.Watermark, error) {
+	watermark := internal.Watermark{}
+
+	var verifiedAt sql.NullTime
+	var block sql.NullInt64
+	if err := db.QueryRowContext(ctx, executeTemplate("next_watermark.sql", options)).Scan(&verifiedAt, &block); err != nil {
+		return watermark, fmt.Errorf("error reading next watermark: %w", err)
+	}
+
+	watermark.VerifiedAt = verifiedAt.Time
+	watermark.Block = block.Int64
+
+	return watermark, nil
+}